@@ -0,0 +1,89 @@
+// replay streams the rows of a weather file to the ingest endpoint of a running server,
+// for demos and for load-testing higher frequency ingestion
+//
+//	go run ./cmd/replay -file data/weather.dat -rate 50 -concurrency 4
+//	go run ./cmd/replay -speedup 86400   # one day of readings per second
+//	REPLAY_API_KEY=... go run ./cmd/replay   # against a server with API key authentication
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/config"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/replay"
+	"go.uber.org/zap"
+)
+
+func main() {
+	file := flag.String("file", "data/weather.dat", "file to replay, in any layout the server parses")
+	baseURL := flag.String("url", "http://localhost:8080", "base URL of the API")
+	columns := flag.String("columns", config.ColumnsPath, "column definitions used to parse the file")
+	rate := flag.Float64("rate", 0, "rows per second, 0 sends as fast as possible")
+	speedup := flag.Float64("speedup", 0, "space rows like their timestamps, compressed by this factor")
+	concurrency := flag.Int("concurrency", 1, "requests in flight at once")
+	retries := flag.Int("retries", 3, "retries per row on network errors, 429 and 5xx")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of a single request")
+	apiKey := flag.String("api-key", os.Getenv("REPLAY_API_KEY"), "API key with the ingest scope, defaults to $REPLAY_API_KEY")
+	flag.Parse()
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Sync()
+
+	// parse with the same columns as the server, the default layout if there is no columns.yaml
+	if defs, err := config.LoadColumns(*columns); err == nil {
+		schema, err := config.BuildSchema(defs)
+		if err != nil {
+			logger.Fatal("Invalid column definitions", zap.Error(err))
+		}
+		model.SetSchema(schema)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Fatal("Failed to load column definitions", zap.Error(err))
+	}
+
+	src, err := os.Open(*file)
+	if err != nil {
+		logger.Fatal("Failed to open file", zap.Error(err))
+	}
+	defer src.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	replayer := replay.New(*baseURL,
+		replay.WithRate(*rate),
+		replay.WithSpeedup(*speedup),
+		replay.WithConcurrency(*concurrency),
+		replay.WithRetries(*retries),
+		replay.WithHTTPClient(&http.Client{Timeout: *timeout}),
+		replay.WithAPIKey(*apiKey),
+	)
+
+	logger.Info("Replaying", zap.String("file", *file), zap.String("url", *baseURL))
+	report, err := replayer.Replay(ctx, src)
+	if report == nil {
+		logger.Fatal("Replay failed", zap.Error(err))
+	}
+	if err != nil {
+		// interrupted, the partial report is still worth printing
+		logger.Warn("Replay stopped early", zap.Error(err))
+	}
+	if err := report.Print(os.Stdout); err != nil {
+		logger.Error("Failed to print report", zap.Error(err))
+	}
+
+	if err != nil || report.Failed > 0 {
+		os.Exit(1)
+	}
+}
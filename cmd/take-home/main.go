@@ -9,9 +9,12 @@ import (
 	"time"
 
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/config"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/watcher"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
@@ -33,36 +36,164 @@ func main() {
 		logger.Fatal("Failed to load config", zap.Error(err))
 	}
 
-	// connect to db
-	mongoClient, err := storage.Connect(ctx, cfg.MongoURI)
+	// columns.yaml drives parsing, validation and serialisation of readings
+	model.SetSchema(cfg.Schema)
+
+	// open the configured storage backend
+	repo, err := storage.Open(ctx, storage.BackendConfig{
+		Backend:  cfg.StorageBackend,
+		MongoURI: cfg.MongoURI,
+		Path:     cfg.StoragePath,
+	})
 	if err != nil {
-		logger.Fatal("Failed to connect to MongoDB", zap.Error(err))
+		logger.Fatal("Failed to open storage", zap.String("backend", cfg.StorageBackend), zap.Error(err))
 	}
 	defer func() {
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			logger.Error("Error disconnecting from MongoDB", zap.Error(err))
+		if err := repo.CloseConnection(context.Background()); err != nil {
+			logger.Error("Error closing storage", zap.Error(err))
 		}
 	}()
+	logger.Info("Storage ready", zap.String("backend", cfg.StorageBackend))
 
-	// init repo
-	repo := storage.NewMongoDBRepository(mongoClient)
+	// rows rejected by lenient file ingestion
+	var deadLetters storage.DeadLetterSink
+	switch cfg.DeadLetterSink {
+	case "file":
+		deadLetters = storage.NewFileDeadLetterSink(cfg.DeadLetterPath)
+	case "mongo":
+		deadLetters, _ = repo.(storage.DeadLetterSink)
+	}
+
+	// changes of readings travel over the backplane, so clients of every instance see the writes of all of them
+	readingWatcher, _ := repo.(events.ReadingWatcher)
+	bus, err := events.Open(ctx, events.Config{
+		Backplane:    cfg.Backplane,
+		RedisURL:     cfg.RedisURL,
+		RedisChannel: cfg.RedisChannel,
+		Watcher:      readingWatcher,
+	})
+	if err != nil {
+		logger.Fatal("Failed to open backplane", zap.String("backplane", cfg.Backplane), zap.Error(err))
+	}
+	defer func() {
+		if err := bus.Close(); err != nil {
+			logger.Error("Error closing backplane", zap.Error(err))
+		}
+	}()
 
 	// init services
-	ingestService := service.NewIngestService(repo)
-	queryService := service.NewQueryService(repo)
+	ingestService := service.NewIngestService(repo,
+		service.WithBulkOptions(storage.BulkOptions{
+			BatchSize: cfg.IngestBatchSize,
+			Ordered:   cfg.IngestOrdered,
+			History:   cfg.History,
+		}),
+		service.WithDailyUpsert(cfg.DailyUpsert),
+		service.WithDefaultStation(cfg.DefaultStation),
+		service.WithLenientIngestion(cfg.IngestLenient),
+		service.WithDeadLetterSink(deadLetters),
+		service.WithCheckpoints(repo),
+		service.WithConflictPolicy(cfg.ConflictPolicy),
+		service.WithEvents(bus, logger),
+	)
+	queryService := service.NewQueryService(repo,
+		service.WithMaxStreamRange(cfg.StreamMaxRange),
+		service.WithRevisions(repo),
+	)
+	stationService := service.NewStationService(repo)
 
 	// init WebSocket
-	wsHub := handler.NewWebSocketHub(logger)
+	wsHub := handler.NewWebSocketHub(logger,
+		handler.WithClientQueueSize(cfg.WSQueueSize),
+		handler.WithOverflowPolicy(cfg.WSOverflow),
+		handler.WithReplayBuffer(cfg.WSReplaySize),
+		handler.WithStreamKeepalive(cfg.SSEKeepalive),
+	)
 
 	// run websocket in separate goroutine
 	go wsHub.Run(ctx)
 
+	// push the changes announced on the backplane to the WebSocket and SSE clients, resubscribing when the backplane fails
+	go events.SubscribeWithRetry(ctx, bus, func(event events.Event) {
+		if event.Type == events.TypeDeleted {
+			wsHub.BroadcastDeleted(event.Reading)
+			return
+		}
+		wsHub.Broadcast(event.Reading)
+	}, time.Second, func(err error, wait time.Duration) {
+		logger.Error("Backplane subscription failed, resubscribing",
+			zap.String("backplane", cfg.Backplane), zap.Duration("wait", wait), zap.Error(err))
+	})
+	logger.Info("Backplane ready", zap.String("backplane", cfg.Backplane))
+
+	// ingest files dropped into the inbox and rows appended to followed files
+	if cfg.WatchInbox != "" || len(cfg.WatchFollow) > 0 {
+		w := watcher.New(ingestService, logger,
+			watcher.WithInbox(cfg.WatchInbox),
+			watcher.WithDoneDir(cfg.WatchDone),
+			watcher.WithFailedDir(cfg.WatchFailed),
+			watcher.WithFollow(cfg.WatchFollow...),
+			watcher.WithInterval(cfg.WatchInterval),
+		)
+		go w.Run(ctx)
+	}
+
+	// retried ingest requests with the same Idempotency-Key replay the first response
+	handlerOpts := []handler.HandlerOption{
+		handler.WithIdempotency(service.NewIdempotencyService(repo, service.WithIdempotencyTTL(cfg.IdempotencyTTL))),
+	}
+
+	// every route requires an API key with its scope, the admin key of the config bootstraps key management
+	if cfg.AuthEnabled {
+		apiKeys := service.NewAPIKeyService(repo)
+		if cfg.AdminAPIKey != "" {
+			if err := apiKeys.EnsureKey(ctx, "admin", cfg.AdminAPIKey, []string{model.ScopeAdmin}); err != nil {
+				logger.Fatal("Failed to store admin API key", zap.Error(err))
+			}
+		}
+		handlerOpts = append(handlerOpts, handler.WithAPIKeys(apiKeys))
+		logger.Info("API key authentication enabled")
+	}
+
+	// queue single readings and write them in micro-batches
+	pipelineDone := make(chan struct{})
+	pipelineCtx, stopPipeline := context.WithCancel(context.Background())
+	defer stopPipeline()
+	if cfg.IngestAsync {
+		pipeline, err := service.NewIngestPipeline(ingestService,
+			service.WithQueueSize(cfg.IngestQueueSize),
+			service.WithWorkers(cfg.IngestWorkers),
+			service.WithMicroBatchSize(cfg.IngestBatchSize),
+			service.WithLinger(cfg.IngestLinger),
+			service.WithBackpressure(cfg.IngestBackpressure),
+			service.WithSpillFile(cfg.IngestSpillPath),
+		)
+		if err != nil {
+			logger.Fatal("Failed to create ingest pipeline", zap.Error(err))
+		}
+		// stopped after the HTTP server so requests in flight can still enqueue
+		go func() {
+			pipeline.Run(pipelineCtx)
+			close(pipelineDone)
+		}()
+		handlerOpts = append(handlerOpts, handler.WithIngestPipeline(pipeline))
+		logger.Info("Asynchronous ingestion enabled",
+			zap.Int("queueSize", cfg.IngestQueueSize),
+			zap.Int("workers", cfg.IngestWorkers),
+			zap.String("backpressure", cfg.IngestBackpressure),
+		)
+	} else {
+		close(pipelineDone)
+	}
+
 	// init HTTP handler
 	httpHandler := handler.NewHTTPHandler(
 		ingestService,
 		queryService,
+		stationService,
 		wsHub,
 		logger,
+		handlerOpts...,
 	)
 
 	// create router + register routes
@@ -89,11 +220,22 @@ func main() {
 	// load initial data from weather.dat
 	go func() {
 		logger.Info("Loading initial weather data")
-		if err := ingestService.IngestFile(ctx, "data/weather.dat"); err != nil {
+		summary, err := ingestService.IngestFile(ctx, "data/weather.dat", &service.FileOptions{Force: cfg.IngestForce})
+		if err != nil {
 			logger.Error("Failed to ingest initial data", zap.Error(err))
-		} else {
-			logger.Info("Initial data loaded successfully")
+			return
 		}
+		if summary.AlreadyIngested {
+			logger.Info("Initial data already ingested, set INGEST_FORCE=true to ingest it again")
+			return
+		}
+		logger.Info("Initial data loaded",
+			zap.Int("resumedFromLine", summary.ResumedFromLine),
+			zap.Int("parsed", summary.Parsed),
+			zap.Int("accepted", summary.Accepted),
+			zap.Int("rejected", summary.Rejected),
+			zap.Int("skipped", summary.Skipped),
+		)
 	}()
 
 	// wait for termination signal
@@ -109,5 +251,13 @@ func main() {
 		logger.Error("Server shutdown failed", zap.Error(err))
 	}
 
+	// write what is left in the ingest queue
+	stopPipeline()
+	select {
+	case <-pipelineDone:
+	case <-shutdownCtx.Done():
+		logger.Error("Ingest queue not drained before shutdown timeout")
+	}
+
 	logger.Info("Server gracefully stopped")
 }
@@ -1,22 +1,109 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
 )
 
 type ColumnDefinition struct {
-	Description string `yaml:"description"`
-	Unit        string `yaml:"unit"`
+	Description string   `yaml:"description"`
+	Unit        string   `yaml:"unit"`
+	Position    int      `yaml:"position"`
+	Field       string   `yaml:"field"`
+	Type        string   `yaml:"type"`
+	Min         *float64 `yaml:"min"`
+	Max         *float64 `yaml:"max"`
+	Nullable    bool     `yaml:"nullable"`
 }
 
 type Config struct {
-	Port     string
-	MongoURI string
-	Columns  map[string]ColumnDefinition `yaml:"columns"`
+	Port string
+
+	// storage backend (mongo, memory or bolt), MongoURI is only required for mongo
+	StorageBackend string
+	MongoURI       string
+	// database file of the bolt backend
+	StoragePath string
+
+	Columns map[string]ColumnDefinition `yaml:"columns"`
+	// Schema is built from Columns and drives parsing, validation and projection
+	Schema *model.Schema
+
+	// bulk write tuning for file and batch ingestion
+	IngestBatchSize int
+	IngestOrdered   bool
+
+	// keep one reading per day by truncating timestamps to midnight UTC (legacy feeds)
+	DailyUpsert bool
+
+	// station assigned to readings that do not carry one
+	DefaultStation string
+
+	// lenient file ingestion skips bad rows instead of aborting the run
+	IngestLenient bool
+	// ingest the startup file again even if it was completely ingested before
+	IngestForce bool
+	// where rejected rows are kept: a file, a mongo collection or nowhere
+	DeadLetterSink string
+	DeadLetterPath string
+
+	// maximum date range of streamed range queries, zero means unlimited
+	StreamMaxRange time.Duration
+
+	// asynchronous ingestion of single readings through a bounded queue and worker pool
+	IngestAsync        bool
+	IngestQueueSize    int
+	IngestWorkers      int
+	IngestBackpressure string // block, reject or spill
+	IngestSpillPath    string
+	IngestLinger       time.Duration
+
+	// how long responses to requests with an Idempotency-Key are replayed
+	IdempotencyTTL time.Duration
+
+	// what happens to readings that are stored already, per station or for all of them
+	ConflictPolicy storage.ConflictPolicy
+	// record every change of a reading in the revision history
+	History bool
+
+	// messages that may wait for a slow WebSocket client and what happens once its queue is full
+	WSQueueSize int
+	WSOverflow  string // drop-oldest, drop-newest or disconnect
+	// broadcasts kept for clients resuming with ?since=
+	WSReplaySize int
+	// interval of keepalive comments on idle SSE streams
+	SSEKeepalive time.Duration
+	// carries reading changes to the live clients of every instance: local, mongo or redis
+	Backplane    string
+	RedisURL     string
+	RedisChannel string
+
+	// require API keys with the scope of each route, AdminAPIKey is stored as the "admin" key at startup
+	AuthEnabled bool
+	AdminAPIKey string
+
+	// directory polled for new data files, empty disables the inbox
+	WatchInbox  string
+	WatchDone   string
+	WatchFailed string
+	// files followed like tail -F
+	WatchFollow   []string
+	WatchInterval time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -30,13 +117,346 @@ func LoadConfig() (*Config, error) {
 		port = "8080"
 	}
 
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = storage.BackendMongo
+	}
+
 	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		return nil, fmt.Errorf("MONGO_URI must be set")
+	switch storageBackend {
+	case storage.BackendMongo:
+		if mongoURI == "" {
+			return nil, fmt.Errorf("MONGO_URI must be set")
+		}
+	case storage.BackendMemory, storage.BackendBolt:
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND must be one of mongo, memory or bolt")
+	}
+
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "data/weather.db"
+	}
+
+	ingestBatchSize := 500
+	if v := os.Getenv("INGEST_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("INGEST_BATCH_SIZE must be a positive integer")
+		}
+		ingestBatchSize = n
+	}
+
+	ingestOrdered := false
+	if v := os.Getenv("INGEST_ORDERED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("INGEST_ORDERED must be a boolean")
+		}
+		ingestOrdered = b
+	}
+
+	dailyUpsert := false
+	if v := os.Getenv("DAILY_UPSERT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("DAILY_UPSERT must be a boolean")
+		}
+		dailyUpsert = b
+	}
+
+	ingestLenient := false
+	switch v := os.Getenv("INGEST_MODE"); v {
+	case "", "strict":
+	case "lenient":
+		ingestLenient = true
+	default:
+		return nil, fmt.Errorf("INGEST_MODE must be strict or lenient")
+	}
+
+	ingestForce := false
+	if v := os.Getenv("INGEST_FORCE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("INGEST_FORCE must be a boolean")
+		}
+		ingestForce = b
+	}
+
+	deadLetterSink := os.Getenv("DEAD_LETTER_SINK")
+	switch deadLetterSink {
+	case "":
+		deadLetterSink = "file"
+	case "file", "none":
+	case "mongo":
+		if storageBackend != storage.BackendMongo {
+			return nil, fmt.Errorf("DEAD_LETTER_SINK=mongo requires STORAGE_BACKEND=mongo")
+		}
+	default:
+		return nil, fmt.Errorf("DEAD_LETTER_SINK must be one of file, mongo or none")
+	}
+
+	deadLetterPath := os.Getenv("DEAD_LETTER_PATH")
+	if deadLetterPath == "" {
+		deadLetterPath = "data/dead_letter.ndjson"
+	}
+
+	var streamMaxRange time.Duration
+	if v := os.Getenv("STREAM_MAX_RANGE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("STREAM_MAX_RANGE_DAYS must be a non-negative integer")
+		}
+		streamMaxRange = time.Duration(days) * 24 * time.Hour
+	}
+
+	ingestAsync := false
+	if v := os.Getenv("INGEST_ASYNC"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("INGEST_ASYNC must be a boolean")
+		}
+		ingestAsync = b
+	}
+
+	ingestQueueSize := 10000
+	if v := os.Getenv("INGEST_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("INGEST_QUEUE_SIZE must be a positive integer")
+		}
+		ingestQueueSize = n
+	}
+
+	ingestWorkers := 4
+	if v := os.Getenv("INGEST_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("INGEST_WORKERS must be a positive integer")
+		}
+		ingestWorkers = n
+	}
+
+	ingestBackpressure := os.Getenv("INGEST_BACKPRESSURE")
+	switch ingestBackpressure {
+	case "":
+		ingestBackpressure = service.BackpressureBlock
+	case service.BackpressureBlock, service.BackpressureReject, service.BackpressureSpill:
+	default:
+		return nil, fmt.Errorf("INGEST_BACKPRESSURE must be one of block, reject or spill")
+	}
+
+	ingestSpillPath := os.Getenv("INGEST_SPILL_PATH")
+	if ingestSpillPath == "" {
+		ingestSpillPath = "data/ingest_spill.ndjson"
+	}
+
+	ingestLinger := 50 * time.Millisecond
+	if v := os.Getenv("INGEST_LINGER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("INGEST_LINGER must be a positive duration, e.g. 50ms")
+		}
+		ingestLinger = d
+	}
+
+	idempotencyTTL := 24 * time.Hour
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("IDEMPOTENCY_TTL must be a positive duration, e.g. 24h")
+		}
+		idempotencyTTL = d
+	}
+
+	conflictPolicy, err := loadConflictPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	history := true
+	if v := os.Getenv("HISTORY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("HISTORY must be a boolean")
+		}
+		history = b
+	}
+
+	wsQueueSize := 64
+	if v := os.Getenv("WS_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("WS_QUEUE_SIZE must be a positive integer")
+		}
+		wsQueueSize = n
+	}
+
+	wsOverflow := os.Getenv("WS_OVERFLOW")
+	if wsOverflow == "" {
+		wsOverflow = handler.OverflowDropOldest
+	}
+	if !handler.ValidOverflowPolicy(wsOverflow) {
+		return nil, fmt.Errorf("WS_OVERFLOW must be one of drop-oldest, drop-newest or disconnect")
+	}
+
+	wsReplaySize := 1024
+	if v := os.Getenv("WS_REPLAY_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("WS_REPLAY_SIZE must be a positive integer")
+		}
+		wsReplaySize = n
+	}
+
+	sseKeepalive := 15 * time.Second
+	if v := os.Getenv("SSE_KEEPALIVE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("SSE_KEEPALIVE must be a positive duration, e.g. 15s")
+		}
+		sseKeepalive = d
+	}
+
+	backplane := os.Getenv("BACKPLANE")
+	if backplane == "" {
+		backplane = events.BackplaneLocal
+	}
+	redisURL := os.Getenv("REDIS_URL")
+	switch backplane {
+	case events.BackplaneLocal:
+	case events.BackplaneMongo:
+		if storageBackend != storage.BackendMongo {
+			return nil, fmt.Errorf("BACKPLANE=mongo requires STORAGE_BACKEND=mongo")
+		}
+	case events.BackplaneRedis:
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL must be set for BACKPLANE=redis")
+		}
+	default:
+		return nil, fmt.Errorf("BACKPLANE must be one of local, mongo or redis")
+	}
+
+	authEnabled := false
+	if v := os.Getenv("AUTH_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_ENABLED must be a boolean")
+		}
+		authEnabled = b
+	}
+
+	watchInterval := time.Second
+	if v := os.Getenv("WATCH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("WATCH_INTERVAL must be a positive duration, e.g. 500ms")
+		}
+		watchInterval = d
+	}
+
+	var watchFollow []string
+	for _, path := range strings.Split(os.Getenv("WATCH_FOLLOW"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			watchFollow = append(watchFollow, path)
+		}
 	}
 
 	// Load YAML column definitions
-	data, err := os.ReadFile("config/columns.yaml")
+	columns, err := LoadColumns(ColumnsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := BuildSchema(columns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid columns.yaml: %w", err)
+	}
+
+	return &Config{
+		Port:    port,
+		Columns: columns,
+		Schema:  schema,
+
+		StorageBackend: storageBackend,
+		MongoURI:       mongoURI,
+		StoragePath:    storagePath,
+
+		IngestBatchSize: ingestBatchSize,
+		IngestOrdered:   ingestOrdered,
+		DailyUpsert:     dailyUpsert,
+		DefaultStation:  os.Getenv("DEFAULT_STATION"),
+		IngestLenient:   ingestLenient,
+		IngestForce:     ingestForce,
+		DeadLetterSink:  deadLetterSink,
+		DeadLetterPath:  deadLetterPath,
+		StreamMaxRange:  streamMaxRange,
+
+		IngestAsync:        ingestAsync,
+		IngestQueueSize:    ingestQueueSize,
+		IngestWorkers:      ingestWorkers,
+		IngestBackpressure: ingestBackpressure,
+		IngestSpillPath:    ingestSpillPath,
+		IngestLinger:       ingestLinger,
+		IdempotencyTTL:     idempotencyTTL,
+		ConflictPolicy:     conflictPolicy,
+		History:            history,
+
+		WSQueueSize:  wsQueueSize,
+		WSOverflow:   wsOverflow,
+		WSReplaySize: wsReplaySize,
+		SSEKeepalive: sseKeepalive,
+		Backplane:    backplane,
+		RedisURL:     redisURL,
+		RedisChannel: os.Getenv("REDIS_CHANNEL"),
+
+		AuthEnabled: authEnabled,
+		AdminAPIKey: os.Getenv("ADMIN_API_KEY"),
+
+		WatchInbox:    os.Getenv("WATCH_INBOX"),
+		WatchDone:     os.Getenv("WATCH_DONE"),
+		WatchFailed:   os.Getenv("WATCH_FAILED"),
+		WatchFollow:   watchFollow,
+		WatchInterval: watchInterval,
+	}, nil
+}
+
+// ColumnsPath is where the column definitions are read from
+const ColumnsPath = "config/columns.yaml"
+
+// loadConflictPolicy reads CONFLICT_POLICY and the per station overrides of
+// CONFLICT_POLICY_BY_STATION, e.g. "berlin=reject,oslo=revisions"
+func loadConflictPolicy() (storage.ConflictPolicy, error) {
+	const policies = "last-write-wins, first-write-wins, reject or revisions"
+
+	policy := storage.ConflictPolicy{Default: storage.ConflictLastWriteWins}
+	if v := os.Getenv("CONFLICT_POLICY"); v != "" {
+		if !storage.ValidConflictPolicy(v) {
+			return policy, fmt.Errorf("CONFLICT_POLICY must be one of %s", policies)
+		}
+		policy.Default = v
+	}
+
+	for _, entry := range strings.Split(os.Getenv("CONFLICT_POLICY_BY_STATION"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		station, name, ok := strings.Cut(entry, "=")
+		station, name = strings.TrimSpace(station), strings.TrimSpace(name)
+		if !ok || station == "" || !storage.ValidConflictPolicy(name) {
+			return policy, fmt.Errorf("CONFLICT_POLICY_BY_STATION entries must be station=policy with a policy of %s, got %q", policies, entry)
+		}
+		if policy.Stations == nil {
+			policy.Stations = make(map[string]string)
+		}
+		policy.Stations[station] = name
+	}
+	return policy, nil
+}
+
+// LoadColumns reads the column definitions of a columns.yaml file
+func LoadColumns(path string) (map[string]ColumnDefinition, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read columns.yaml: %w", err)
 	}
@@ -48,17 +468,39 @@ func LoadConfig() (*Config, error) {
 	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
 		return nil, fmt.Errorf("failed to parse columns.yaml: %w", err)
 	}
+	return yamlConfig.Columns, nil
+}
+
+// BuildSchema orders the column definitions by position and converts them into a model.Schema
+func BuildSchema(columns map[string]ColumnDefinition) (*model.Schema, error) {
+	names := slices.Collect(maps.Keys(columns))
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Compare(columns[a].Position, columns[b].Position)
+	})
+
+	schemaColumns := make([]model.Column, 0, len(names))
+	for i, name := range names {
+		def := columns[name]
+		if def.Position != i {
+			return nil, fmt.Errorf("column positions must be unique and start at 0, got %d for %s", def.Position, name)
+		}
 
-	required := []string{"Date", "Temperature", "Humidity"}
-	for _, col := range required {
-		if _, exists := yamlConfig.Columns[col]; !exists {
-			return nil, fmt.Errorf("missing required column in YAML: %s", col)
+		field := def.Field
+		if field == "" {
+			field = strings.ToLower(name)
 		}
+
+		schemaColumns = append(schemaColumns, model.Column{
+			Name:        name,
+			Field:       field,
+			Type:        model.ColumnType(def.Type),
+			Unit:        def.Unit,
+			Description: def.Description,
+			Min:         def.Min,
+			Max:         def.Max,
+			Nullable:    def.Nullable,
+		})
 	}
 
-	return &Config{
-		Port:     port,
-		MongoURI: mongoURI,
-		Columns:  yamlConfig.Columns,
-	}, nil
+	return model.NewSchema(schemaColumns)
 }
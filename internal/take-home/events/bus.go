@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// backplanes selectable through BACKPLANE
+const (
+	BackplaneLocal = "local"
+	BackplaneMongo = "mongo"
+	BackplaneRedis = "redis"
+)
+
+// kinds of change of a reading
+const (
+	TypeStored  = "stored"
+	TypeDeleted = "deleted"
+)
+
+// Event is a change of a stored reading, Reading holds the new values or, for deletions, the removed reading
+type Event struct {
+	Type    string             `json:"type"`
+	Reading *model.WeatherData `json:"reading"`
+}
+
+// Stored and Deleted build the events of written and removed readings
+func Stored(data *model.WeatherData) Event  { return Event{Type: TypeStored, Reading: data} }
+func Deleted(data *model.WeatherData) Event { return Event{Type: TypeDeleted, Reading: data} }
+
+// Publisher announces the changes made by this instance
+type Publisher interface {
+	Publish(ctx context.Context, events ...Event) error
+}
+
+// Subscriber delivers the changes made by every instance, including this one
+type Subscriber interface {
+	// Subscribe calls fn for every event until ctx is done, it returns the error that ended the subscription otherwise
+	// fn is called from a single goroutine and must not block
+	Subscribe(ctx context.Context, fn func(Event)) error
+}
+
+// Bus carries changes of readings between the instances of the service
+type Bus interface {
+	Publisher
+	Subscriber
+	Close() error
+}
+
+var (
+	_ Bus = (*LocalBus)(nil)
+	_ Bus = (*RedisBus)(nil)
+	_ Bus = (*ChangeStreamBus)(nil)
+)
+
+// Config selects a backplane and carries the settings it needs
+type Config struct {
+	Backplane    string
+	RedisURL     string // redis only
+	RedisChannel string // redis only
+	// the MongoDB repository, mongo only
+	Watcher ReadingWatcher
+}
+
+// Open creates the configured backplane and checks that it can deliver events, Close releases it
+func Open(ctx context.Context, cfg Config) (Bus, error) {
+	switch cfg.Backplane {
+	case BackplaneLocal, "":
+		return NewLocalBus(), nil
+	case BackplaneRedis:
+		bus, err := NewRedisBus(cfg.RedisURL, cfg.RedisChannel)
+		if err != nil {
+			return nil, err
+		}
+		if err := bus.Ping(ctx); err != nil {
+			bus.Close()
+			return nil, err
+		}
+		return bus, nil
+	case BackplaneMongo:
+		if cfg.Watcher == nil {
+			return nil, fmt.Errorf("the mongo backplane needs the mongo storage backend")
+		}
+		if err := cfg.Watcher.CheckWatch(ctx); err != nil {
+			return nil, err
+		}
+		return NewChangeStreamBus(cfg.Watcher), nil
+	default:
+		return nil, fmt.Errorf("unknown backplane %q", cfg.Backplane)
+	}
+}
+
+// maxRetryBackoff caps the wait between two attempts of SubscribeWithRetry
+const maxRetryBackoff = 30 * time.Second
+
+// SubscribeWithRetry subscribes to sub until ctx is done, subscribing again whenever the subscription ends with an error
+// backoff is the wait before the first retry, it doubles with every further failure and starts over
+// once a subscription held for maxRetryBackoff; onRetry is told about every failure and the wait that follows
+func SubscribeWithRetry(ctx context.Context, sub Subscriber, fn func(Event), backoff time.Duration, onRetry func(err error, wait time.Duration)) {
+	wait := backoff
+	for {
+		began := time.Now()
+		err := sub.Subscribe(ctx, fn)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(began) >= maxRetryBackoff {
+			wait = backoff
+		}
+		if err == nil {
+			err = fmt.Errorf("subscription ended")
+		}
+		onRetry(err, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		wait = min(wait*2, maxRetryBackoff)
+	}
+}
+
+// LocalBus delivers events to the subscribers of this instance only, enough for a single replica
+type LocalBus struct {
+	mu   sync.RWMutex
+	subs map[int]func(Event)
+	next int
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subs: make(map[int]func(Event))}
+}
+
+// Publish hands the events to every subscriber before it returns
+func (b *LocalBus) Publish(ctx context.Context, events ...Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subs {
+		for _, event := range events {
+			fn(event)
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(ctx context.Context, fn func(Event)) error {
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.subs, id)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *LocalBus) Close() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// ReadingWatcher is a storage backend that reports the changes of its readings, whoever made them
+type ReadingWatcher interface {
+	WatchReadings(ctx context.Context, fn func(data *model.WeatherData, deleted bool)) error
+	// CheckWatch fails when the backend cannot report changes, e.g. MongoDB without a replica set
+	CheckWatch(ctx context.Context) error
+}
+
+// ChangeStreamBus takes the events from the database instead of the writers, so it also sees
+// writes of other tools, publishing is a no-op
+type ChangeStreamBus struct {
+	watcher ReadingWatcher
+}
+
+func NewChangeStreamBus(watcher ReadingWatcher) *ChangeStreamBus {
+	return &ChangeStreamBus{watcher: watcher}
+}
+
+// Publish does nothing, the database announces the write itself
+func (b *ChangeStreamBus) Publish(ctx context.Context, events ...Event) error {
+	return nil
+}
+
+func (b *ChangeStreamBus) Subscribe(ctx context.Context, fn func(Event)) error {
+	return b.watcher.WatchReadings(ctx, func(data *model.WeatherData, deleted bool) {
+		if deleted {
+			fn(Deleted(data))
+			return
+		}
+		fn(Stored(data))
+	})
+}
+
+func (b *ChangeStreamBus) Close() error {
+	return nil
+}
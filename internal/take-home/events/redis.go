@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultRedisChannel = "weather:events"
+
+// RedisBus publishes events as JSON on a Redis pub/sub channel shared by every instance
+// Redis does not keep messages, an instance that is disconnected misses the events of that time
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBus connects to the Redis server at url, e.g. redis://localhost:6379/0
+func NewRedisBus(url, channel string) (*RedisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if channel == "" {
+		channel = DefaultRedisChannel
+	}
+	return &RedisBus{client: redis.NewClient(opts), channel: channel}, nil
+}
+
+// Ping checks that the Redis server answers
+func (b *RedisBus) Ping(ctx context.Context) error {
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to reach Redis: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBus) Publish(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	_, err := b.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+			pipe.Publish(ctx, b.channel, payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish events: %w", err)
+	}
+	return nil
+}
+
+// Subscribe reconnects by itself when the connection to Redis drops, messages it cannot decode are skipped
+func (b *RedisBus) Subscribe(ctx context.Context, fn func(Event)) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+
+	// wait for the confirmation, so events published after Subscribe started are not missed
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to subscribe to %s: %w", b.channel, err)
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil || event.Reading == nil {
+				continue
+			}
+			fn(event)
+		}
+	}
+}
+
+func (b *RedisBus) Close() error {
+	return b.client.Close()
+}
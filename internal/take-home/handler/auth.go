@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	// browsers cannot set headers on WebSocket upgrades or EventSource requests, the key may be passed as a parameter there
+	apiKeyParam = "api_key"
+)
+
+type keyParamKey struct{}
+
+// withKeyParam lets authorize take the key from ?api_key=, for the live update routes that browsers open without headers
+// other routes ignore the parameter, so keys do not end up in the access logs of ordinary requests
+func withKeyParam(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), keyParamKey{}, true)))
+	}
+}
+
+// apiKeyResponse is a key together with its secret, which is only ever shown in this response
+type apiKeyResponse struct {
+	*model.APIKey
+	Secret string `json:"secret"`
+}
+
+// WithAPIKeys requires an API key with the scope of each route, see registerAdminRoutes for key management
+// without it every route is open
+func WithAPIKeys(apiKeys service.APIKeyServiceInterface) HandlerOption {
+	return func(h *HTTPHandler) {
+		h.apiKeys = apiKeys
+	}
+}
+
+// authorize lets requests through whose API key grants scope, 401 without a valid key and 403 without the scope
+// the key is recorded as the source of the writes of the request
+func (h *HTTPHandler) authorize(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.apiKeys == nil {
+			next(w, r)
+			return
+		}
+
+		key, err := h.apiKeys.Authenticate(r.Context(), apiKeyFromRequest(r))
+		switch {
+		case errors.Is(err, service.ErrInvalidAPIKey):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="weather"`)
+			respondWithError(w, http.StatusUnauthorized, "A valid API key is required")
+			return
+		case err != nil:
+			h.logger.Error("API key lookup failed", zap.Error(err))
+			respondWithError(w, http.StatusServiceUnavailable, "Failed to check API key")
+			return
+		case !key.Allows(scope):
+			respondWithError(w, http.StatusForbidden, "API key lacks the '"+scope+"' scope")
+			return
+		}
+
+		ctx := service.ContextWithSource(r.Context(), service.APIKeySource(key.ID))
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// apiKeyFromRequest reads the key from a bearer token, the X-API-Key header or, on routes wrapped in withKeyParam, ?api_key=
+func apiKeyFromRequest(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if allowed, _ := r.Context().Value(keyParamKey{}).(bool); allowed {
+		return r.URL.Query().Get(apiKeyParam)
+	}
+	return ""
+}
+
+// registerAdminRoutes registers key management below /admin, only when API keys are enabled
+func (h *HTTPHandler) registerAdminRoutes(adminRouter *mux.Router) {
+	if h.apiKeys == nil {
+		return
+	}
+
+	adminRouter.HandleFunc("/keys", h.authorize(model.ScopeAdmin, h.listAPIKeys)).
+		Methods("GET")
+
+	adminRouter.HandleFunc("/keys", h.authorize(model.ScopeAdmin, h.createAPIKey)).
+		Methods("POST")
+
+	adminRouter.HandleFunc("/keys/{key}/rotate", h.authorize(model.ScopeAdmin, h.rotateAPIKey)).
+		Methods("POST")
+
+	adminRouter.HandleFunc("/keys/{key}", h.authorize(model.ScopeAdmin, h.revokeAPIKey)).
+		Methods("DELETE")
+}
+
+func (h *HTTPHandler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeys.ListKeys(r.Context())
+	if err != nil {
+		h.logger.Error("Query failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve API keys")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (h *HTTPHandler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var spec service.APIKeySpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		h.logger.Warn("Invalid request payload", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	key, secret, err := h.apiKeys.CreateKey(r.Context(), spec)
+	if errors.Is(err, service.ErrInvalidAPIKeySpec) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error("API key creation failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	h.logger.Info("API key created", zap.String("key", key.ID), zap.Strings("scopes", key.Scopes))
+	respondWithJSON(w, http.StatusCreated, apiKeyResponse{APIKey: key, Secret: secret})
+}
+
+func (h *HTTPHandler) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, secret, err := h.apiKeys.RotateKey(r.Context(), mux.Vars(r)["key"])
+	if err != nil {
+		h.respondWithAPIKeyError(w, err)
+		return
+	}
+
+	h.logger.Info("API key rotated", zap.String("key", key.ID))
+	respondWithJSON(w, http.StatusOK, apiKeyResponse{APIKey: key, Secret: secret})
+}
+
+// revokeAPIKey disables a key, it stays listed with its revocation time
+func (h *HTTPHandler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.apiKeys.RevokeKey(r.Context(), mux.Vars(r)["key"])
+	if err != nil {
+		h.respondWithAPIKeyError(w, err)
+		return
+	}
+
+	h.logger.Info("API key revoked", zap.String("key", key.ID))
+	respondWithJSON(w, http.StatusOK, key)
+}
+
+func (h *HTTPHandler) respondWithAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "Unknown API key")
+	case errors.Is(err, service.ErrAPIKeyRevoked):
+		respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		h.logger.Error("API key update failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to update API key")
+	}
+}
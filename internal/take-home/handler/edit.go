@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// deleteResponse lists the removed readings, or the ones a dry run would remove
+type deleteResponse struct {
+	Deleted  int                  `json:"deleted"`
+	DryRun   bool                 `json:"dryRun"`
+	Readings []*model.WeatherData `json:"readings"`
+}
+
+// readingDate parses the {date} route variable, either a day or the RFC 3339 timestamp of a single reading
+// day reports whether a whole day was given
+func readingDate(r *http.Request) (date time.Time, day bool, err error) {
+	v := mux.Vars(r)["date"]
+	if date, err := time.Parse("2006-01-02", v); err == nil {
+		return date, true, nil
+	}
+	if date, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return date.UTC(), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date, expected YYYY-MM-DD or an RFC 3339 timestamp")
+}
+
+// pickReadingDate resolves the timestamp of the reading meant by a request, the date in the body
+// selects a reading within the day of the route and must match a route timestamp, without it a day means midnight
+func pickReadingDate(routeDate time.Time, day bool, bodyDate time.Time) (time.Time, error) {
+	switch {
+	case bodyDate.IsZero():
+		return routeDate, nil
+	case day && (bodyDate.Before(routeDate) || !bodyDate.Before(routeDate.Add(24*time.Hour))):
+		return time.Time{}, fmt.Errorf("date %s is not within %s", bodyDate.Format(time.RFC3339), routeDate.Format("2006-01-02"))
+	case !day && !bodyDate.Equal(routeDate):
+		return time.Time{}, fmt.Errorf("date %s does not match route date %s", bodyDate.Format(time.RFC3339), routeDate.Format(time.RFC3339))
+	}
+	return bodyDate, nil
+}
+
+// replaceWeatherData stores the reading of the body in place of the stored one, missing columns are removed
+func (h *HTTPHandler) replaceWeatherData(w http.ResponseWriter, r *http.Request) {
+	routeDate, day, err := readingDate(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var data model.WeatherData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.logger.Warn("Invalid request payload", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if data.Date, err = pickReadingDate(routeDate, day, data.Date); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyRouteStation(r, &data); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if data.Station == "" {
+		data.Station = r.URL.Query().Get("station")
+	}
+
+	outcome, err := h.ingestSvc.ReplaceReading(r.Context(), &data)
+	if errors.Is(err, service.ErrInvalidEdit) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error("Replace failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to replace reading")
+		return
+	}
+	w.Header().Set(ingestOutcomeHeader, outcome)
+
+	status := http.StatusOK
+	if outcome == storage.OutcomeInserted {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, data)
+}
+
+// patchWeatherData changes the columns named in the body, null removes a column
+// the body may name the date of the reading within the day and its station
+func (h *HTTPHandler) patchWeatherData(w http.ResponseWriter, r *http.Request) {
+	routeDate, day, err := readingDate(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.logger.Warn("Invalid request payload", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	target := model.WeatherData{Station: buildQueryOptionsFromRequest(r).Station}
+	values := make(map[string]any, len(body))
+	for field, raw := range body {
+		switch field {
+		case "date":
+			err = json.Unmarshal(raw, &target.Date)
+		case "station":
+			var station string
+			if err = json.Unmarshal(raw, &station); err == nil && target.Station != "" && station != target.Station {
+				err = fmt.Errorf("does not match station %q", target.Station)
+			}
+			target.Station = station
+		default:
+			values[field], err = patchValue(field, raw)
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", field, err))
+			return
+		}
+	}
+	if target.Date, err = pickReadingDate(routeDate, day, target.Date); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patched, err := h.ingestSvc.PatchReading(r.Context(), target.Station, target.Date, values)
+	switch {
+	case errors.Is(err, service.ErrInvalidEdit):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "No reading found for specified date")
+		return
+	case err != nil:
+		h.logger.Error("Patch failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to update reading")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, patched)
+}
+
+// patchValue decodes the new value of a schema column, nil for null
+func patchValue(field string, raw json.RawMessage) (any, error) {
+	col, ok := model.ActiveSchema().Column(field)
+	if !ok || col.Type == model.ColumnDate {
+		return nil, fmt.Errorf("unknown field")
+	}
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+	return col.Coerce(decoded)
+}
+
+// deleteWeatherByDate removes the readings of a day, or the single reading at a timestamp
+func (h *HTTPHandler) deleteWeatherByDate(w http.ResponseWriter, r *http.Request) {
+	date, day, err := readingDate(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	end := date
+	if day {
+		end = date.Add(24*time.Hour - time.Nanosecond)
+	}
+	h.deleteWeather(w, r, date, end)
+}
+
+// deleteWeatherByDateRange removes the readings from the start of the 'from' day to the end of the 'to' day
+func (h *HTTPHandler) deleteWeatherByDateRange(w http.ResponseWriter, r *http.Request) {
+	from, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid 'from' date format")
+		return
+	}
+
+	to, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid 'to' date format")
+		return
+	}
+
+	h.deleteWeather(w, r, from, to.Add(24*time.Hour-time.Nanosecond))
+}
+
+// deleteWeather removes the readings in [start, end] of the requested station, ?dryRun=true only lists them
+func (h *HTTPHandler) deleteWeather(w http.ResponseWriter, r *http.Request, start, end time.Time) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	station := buildQueryOptionsFromRequest(r).Station
+
+	deleted, err := h.ingestSvc.DeleteReadings(r.Context(), start, end, station, dryRun)
+	if errors.Is(err, service.ErrInvalidEdit) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error("Delete failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete data")
+		return
+	}
+
+	if len(deleted) == 0 && !dryRun {
+		respondWithError(w, http.StatusNotFound, "No data found for specified range")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deleteResponse{Deleted: len(deleted), DryRun: dryRun, Readings: deleted})
+}
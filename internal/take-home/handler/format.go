@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// exportFormat is an output representation of weather readings
+type exportFormat string
+
+const (
+	formatJSON     exportFormat = "json"
+	formatNDJSON   exportFormat = "ndjson"
+	formatCSV      exportFormat = "csv"
+	formatDAT      exportFormat = "dat"      // tab-separated weather.dat layout
+	formatColumnar exportFormat = "columnar" // one array per column, ready for DataFrame constructors
+)
+
+var formatContentTypes = map[exportFormat]string{
+	formatJSON:     "application/json",
+	formatNDJSON:   "application/x-ndjson",
+	formatCSV:      "text/csv; charset=utf-8",
+	formatDAT:      "text/tab-separated-values; charset=utf-8",
+	formatColumnar: "application/vnd.weather.columnar+json",
+}
+
+// media types and ?format= values accepted for each format
+var (
+	formatsByMediaType = map[string]exportFormat{
+		"application/json":                      formatJSON,
+		"application/x-ndjson":                  formatNDJSON,
+		"text/csv":                              formatCSV,
+		"text/tab-separated-values":             formatDAT,
+		"application/vnd.weather.columnar+json": formatColumnar,
+	}
+	formatsByName = map[string]exportFormat{
+		"json":     formatJSON,
+		"ndjson":   formatNDJSON,
+		"csv":      formatCSV,
+		"dat":      formatDAT,
+		"tsv":      formatDAT,
+		"columnar": formatColumnar,
+	}
+)
+
+// errNotAcceptable is returned when neither ?format= nor Accept names a supported format
+var errNotAcceptable = fmt.Errorf("supported formats: json, ndjson, csv, dat, columnar")
+
+// negotiateFormat picks the output format from ?format= or, failing that, the Accept header
+// JSON stays the default for missing or wildcard Accept headers
+func negotiateFormat(r *http.Request) (exportFormat, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		if f, ok := formatsByName[strings.ToLower(name)]; ok {
+			return f, nil
+		}
+		return "", errNotAcceptable
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		if f, ok := formatsByMediaType[c.mediaType]; ok {
+			return f, nil
+		}
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			return formatJSON, nil
+		}
+	}
+	return "", errNotAcceptable
+}
+
+// recordWriter serialises readings one at a time so buffered and streamed responses share the encoders
+type recordWriter interface {
+	write(data *model.WeatherData) error
+	// flush pushes buffered rows to the underlying writer
+	flush() error
+	// close writes any trailer, e.g. the closing bracket of a JSON array
+	close() error
+}
+
+// newRecordWriter starts a response body in the given format
+// fields restricts the value columns, nil writes every column
+// station adds a station column to the dat layout, the other formats always carry the station
+func newRecordWriter(w io.Writer, format exportFormat, fields []string, station bool) (recordWriter, error) {
+	columns := exportColumns(fields)
+
+	switch format {
+	case formatNDJSON:
+		return &jsonRecordWriter{w: w, enc: json.NewEncoder(w), fields: fields}, nil
+	case formatCSV:
+		return newDelimitedWriter(w, ',', columns, false, true)
+	case formatDAT:
+		return newDelimitedWriter(w, '\t', columns, true, station)
+	case formatColumnar:
+		return newColumnarWriter(w, columns), nil
+	default:
+		if _, err := w.Write([]byte("[")); err != nil {
+			return nil, err
+		}
+		return &jsonRecordWriter{w: w, enc: json.NewEncoder(w), fields: fields, array: true}, nil
+	}
+}
+
+// exportColumns returns the value columns of the schema, restricted to the projected fields
+func exportColumns(fields []string) []model.Column {
+	columns := model.ActiveSchema().ValueColumns()
+	if fields == nil {
+		return columns
+	}
+
+	requested := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		requested[f] = true
+	}
+	projected := make([]model.Column, 0, len(fields))
+	for _, col := range columns {
+		if requested[col.Field] {
+			projected = append(projected, col)
+		}
+	}
+	return projected
+}
+
+type jsonRecordWriter struct {
+	w      io.Writer
+	enc    *json.Encoder
+	fields []string
+	array  bool
+	count  int
+}
+
+func (j *jsonRecordWriter) write(data *model.WeatherData) error {
+	if j.array && j.count > 0 {
+		if _, err := j.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	j.count++
+	if j.fields != nil {
+		return j.enc.Encode(projectRecord(data, j.fields))
+	}
+	return j.enc.Encode(data)
+}
+
+func (j *jsonRecordWriter) flush() error { return nil }
+
+func (j *jsonRecordWriter) close() error {
+	if j.array {
+		_, err := j.w.Write([]byte("]\n"))
+		return err
+	}
+	return nil
+}
+
+// delimitedWriter writes CSV, or the weather.dat layout when dat is set
+// the header row names each column with its unit from columns.yaml
+// in the dat layout the header is a comment so the output can be ingested again
+// the dat layout has no station column unless the rows come from more than one station
+type delimitedWriter struct {
+	csv     *csv.Writer
+	columns []model.Column
+	dat     bool
+	station bool
+}
+
+func newDelimitedWriter(w io.Writer, comma rune, columns []model.Column, dat, station bool) (*delimitedWriter, error) {
+	d := &delimitedWriter{csv: csv.NewWriter(w), columns: columns, dat: dat, station: station}
+	d.csv.Comma = comma
+
+	schema := model.ActiveSchema()
+	header := make([]string, 0, len(columns)+2)
+	for _, col := range schema.Columns {
+		if col.Type == model.ColumnDate {
+			header = append(header, col.Name)
+			break
+		}
+	}
+	if station {
+		header = append(header, "Station")
+	}
+	for _, col := range columns {
+		if col.Unit != "" {
+			header = append(header, fmt.Sprintf("%s (%s)", col.Name, col.Unit))
+		} else {
+			header = append(header, col.Name)
+		}
+	}
+	if dat {
+		header[0] = "# " + header[0]
+	}
+
+	if err := d.csv.Write(header); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *delimitedWriter) write(data *model.WeatherData) error {
+	row := make([]string, 0, len(d.columns)+2)
+	if d.dat {
+		row = append(row, formatDATDate(data.Date))
+	} else {
+		row = append(row, data.Date.Format(time.RFC3339Nano))
+	}
+	if d.station {
+		row = append(row, data.Station)
+	}
+
+	for _, col := range d.columns {
+		row = append(row, formatCell(data.Values[col.Field], d.dat))
+	}
+	return d.csv.Write(row)
+}
+
+func (d *delimitedWriter) flush() error {
+	d.csv.Flush()
+	return d.csv.Error()
+}
+
+func (d *delimitedWriter) close() error {
+	return d.flush()
+}
+
+// formatDATDate keeps the original YYYY-MM-DD layout for daily readings
+func formatDATDate(t time.Time) string {
+	if t.Equal(model.TruncateToDay(t)) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatCell renders a value, missing values are empty in CSV and NA in the dat layout
+func formatCell(v any, dat bool) string {
+	switch n := v.(type) {
+	case nil:
+		if dat {
+			return "NA"
+		}
+		return ""
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case int32:
+		return strconv.FormatInt(int64(n), 10)
+	case string:
+		return n
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// columnarWriter collects one array per column and writes them on close
+// it has to buffer the whole result, so it does not reduce memory use of streamed requests
+type columnarWriter struct {
+	w       io.Writer
+	columns []model.Column
+	dates   []time.Time
+	station []string
+	values  map[string][]any
+}
+
+type columnarColumn struct {
+	Name  string `json:"name"`
+	Field string `json:"field"`
+	Type  string `json:"type"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+func newColumnarWriter(w io.Writer, columns []model.Column) *columnarWriter {
+	c := &columnarWriter{w: w, columns: columns, values: make(map[string][]any, len(columns))}
+	for _, col := range columns {
+		c.values[col.Field] = []any{}
+	}
+	return c
+}
+
+func (c *columnarWriter) write(data *model.WeatherData) error {
+	c.dates = append(c.dates, data.Date)
+	c.station = append(c.station, data.Station)
+	for _, col := range c.columns {
+		c.values[col.Field] = append(c.values[col.Field], data.Values[col.Field])
+	}
+	return nil
+}
+
+func (c *columnarWriter) flush() error { return nil }
+
+func (c *columnarWriter) close() error {
+	schema := []columnarColumn{{Name: "Date", Field: "date", Type: string(model.ColumnDate)}, {Name: "Station", Field: "station", Type: string(model.ColumnString)}}
+	data := map[string]any{"date": c.dates, "station": c.station}
+	if c.dates == nil {
+		data["date"], data["station"] = []time.Time{}, []string{}
+	}
+	for _, col := range c.columns {
+		schema = append(schema, columnarColumn{Name: col.Name, Field: col.Field, Type: string(col.Type), Unit: col.Unit})
+		data[col.Field] = c.values[col.Field]
+	}
+
+	return json.NewEncoder(c.w).Encode(map[string]any{
+		"rows":    len(c.dates),
+		"columns": schema,
+		"data":    data,
+	})
+}
+
+// respondWithRecords writes a buffered result in the negotiated format
+// JSON keeps the original encoding of respondWithJSON
+func respondWithRecords(w http.ResponseWriter, format exportFormat, data []*model.WeatherData, fields []string) error {
+	if format == formatJSON {
+		if fields != nil {
+			respondWithJSON(w, http.StatusOK, filterFields(data, fields))
+		} else {
+			respondWithJSON(w, http.StatusOK, data)
+		}
+		return nil
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+
+	rw, err := newRecordWriter(w, format, projectedFields(fields), multipleStations(data))
+	if err != nil {
+		return err
+	}
+	for _, item := range data {
+		if err := rw.write(item); err != nil {
+			return err
+		}
+	}
+	return rw.close()
+}
+
+// multipleStations reports whether the readings come from more than one station
+func multipleStations(data []*model.WeatherData) bool {
+	for _, item := range data {
+		if item.Station != data[0].Station {
+			return true
+		}
+	}
+	return false
+}
+
+// projectedFields turns the raw ?fields= list into schema fields, nil when no projection was asked for
+func projectedFields(fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	return projectableFields(fields)
+}
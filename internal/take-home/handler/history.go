@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/gorilla/mux"
+)
+
+// getWeatherHistory lists the recorded changes of the readings of a day, oldest first per reading
+func (h *HTTPHandler) getWeatherHistory(w http.ResponseWriter, r *http.Request) {
+	date, err := time.Parse("2006-01-02", mux.Vars(r)["date"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid date format (YYYY-MM-DD)")
+		return
+	}
+
+	// station-scoped routes take precedence over the query parameter
+	station := r.URL.Query().Get("station")
+	if id := mux.Vars(r)["id"]; id != "" {
+		station = id
+	}
+
+	revisions, err := h.querySvc.History(r.Context(), date, date.Add(24*time.Hour-time.Nanosecond), station)
+	if err != nil {
+		h.respondWithQueryError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, revisions)
+}
+
+// applyAsOf reads the asOf parameter, an RFC 3339 timestamp the data is returned as it was known at
+func applyAsOf(r *http.Request, opts *service.QueryOptions) error {
+	v := r.URL.Query().Get("asOf")
+	if v == "" {
+		return nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return fmt.Errorf("invalid 'asOf' timestamp, expected RFC 3339 like 2023-01-02T15:04:05Z")
+	}
+	opts.AsOf = asOf
+	return nil
+}
@@ -1,59 +1,174 @@
 package handler
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
+const (
+	// upper bound for batch request bodies
+	maxBatchBodyBytes = 32 << 20
+	// page size used when a continuation token is sent without a limit
+	defaultPageLimit = 100
+	// tells what the conflict policy did with a single reading, see storage.OutcomeInserted
+	ingestOutcomeHeader = "Ingest-Outcome"
+)
+
 type HTTPHandler struct {
-	ingestSvc service.IngestServiceInterface
-	querySvc  service.QueryServiceInterface
-	wsHub     WebSocketHub
-	logger    *zap.Logger
+	ingestSvc  service.IngestServiceInterface
+	querySvc   service.QueryServiceInterface
+	stationSvc service.StationServiceInterface
+	wsHub      WebSocketHub
+	logger     *zap.Logger
+	// single readings are queued instead of written synchronously when set
+	pipeline service.IngestPipelineInterface
+	// ingest requests with an Idempotency-Key are replayed when set
+	idempotency service.IdempotencyServiceInterface
+	// routes require an API key with their scope when set
+	apiKeys service.APIKeyServiceInterface
+}
+
+// HandlerOption customises an HTTPHandler at construction time
+type HandlerOption func(*HTTPHandler)
+
+// WithIngestPipeline answers POST /weather with 202 Accepted and writes readings through the pipeline
+// the pipeline writes through the ingest service, so stored readings are announced like any other, see service.WithEvents
+func WithIngestPipeline(pipeline service.IngestPipelineInterface) HandlerOption {
+	return func(h *HTTPHandler) {
+		h.pipeline = pipeline
+	}
+}
+
+// WithIdempotency honours the Idempotency-Key header on ingest requests
+func WithIdempotency(idempotency service.IdempotencyServiceInterface) HandlerOption {
+	return func(h *HTTPHandler) {
+		h.idempotency = idempotency
+	}
 }
 
 func NewHTTPHandler(
 	ingestSvc service.IngestServiceInterface,
 	querySvc service.QueryServiceInterface,
+	stationSvc service.StationServiceInterface,
 	wsHub WebSocketHub,
 	logger *zap.Logger,
+	opts ...HandlerOption,
 ) *HTTPHandler {
-	return &HTTPHandler{
-		ingestSvc: ingestSvc,
-		querySvc:  querySvc,
-		wsHub:     wsHub,
-		logger:    logger.Named("http_handler"),
+	h := &HTTPHandler{
+		ingestSvc:  ingestSvc,
+		querySvc:   querySvc,
+		stationSvc: stationSvc,
+		wsHub:      wsHub,
+		logger:     logger.Named("http_handler"),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *HTTPHandler) RegisterRoutes(router *mux.Router) {
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 
 	// API endpoints
-	apiRouter.HandleFunc("/weather", h.ingestWeatherData).
+	h.registerWeatherRoutes(apiRouter.PathPrefix("/weather").Subrouter(), h.authorize)
+
+	// station metadata
+	apiRouter.HandleFunc("/stations", h.authorize(model.ScopeRead, h.listStations)).
+		Methods("GET")
+
+	apiRouter.HandleFunc("/stations/{id}", h.authorize(model.ScopeRead, h.getStation)).
+		Methods("GET")
+
+	apiRouter.HandleFunc("/stations/{id}", h.authorize(model.ScopeAdmin, h.registerStation)).
+		Methods("PUT")
+
+	// state of the asynchronous ingest pipeline and of single queued readings
+	apiRouter.HandleFunc("/ingest", h.authorize(model.ScopeIngest, h.getIngestStats)).
+		Methods("GET")
+
+	apiRouter.HandleFunc("/ingest/{ticket}", h.authorize(model.ScopeIngest, h.getIngestTicket)).
+		Methods("GET")
+
+	// connected WebSocket and SSE clients and the messages they missed
+	apiRouter.HandleFunc("/ws/stats", h.authorize(model.ScopeAdmin, h.getWebSocketStats)).
+		Methods("GET")
+
+	// API key management
+	h.registerAdminRoutes(apiRouter.PathPrefix("/admin").Subrouter())
+
+	// station-scoped weather endpoints mirror the global ones
+	// the key is checked before the station, so unauthenticated requests can neither probe for stations nor reach the database
+	h.registerWeatherRoutes(apiRouter.PathPrefix("/stations/{id}/weather").Subrouter(), func(scope string, next http.HandlerFunc) http.HandlerFunc {
+		return h.authorize(scope, h.requireStation(next))
+	})
+}
+
+// registerWeatherRoutes registers the weather endpoints relative to a /weather prefix
+// handlers pick up the station from the {id} route variable when mounted below /stations/{id}
+// reads need the read scope and writes the ingest scope once API keys are enabled, authorize guards every route
+func (h *HTTPHandler) registerWeatherRoutes(weatherRouter *mux.Router, authorize func(scope string, next http.HandlerFunc) http.HandlerFunc) {
+	weatherRouter.HandleFunc("", authorize(model.ScopeIngest, h.idempotent(h.ingestWeatherData))).
 		Methods("POST").
 		Headers("Content-Type", "application/json")
 
-	apiRouter.HandleFunc("/weather/{date}", h.getWeatherByDate).
+	weatherRouter.HandleFunc("/batch", authorize(model.ScopeIngest, h.idempotent(h.ingestWeatherBatch))).
+		Methods("POST")
+
+	// WebSocket and SSE endpoints, registered before /{date} which would otherwise match "ws" and "stream"
+	weatherRouter.HandleFunc("/ws", withKeyParam(authorize(model.ScopeRead, h.wsHub.HandleConnection)))
+
+	weatherRouter.HandleFunc("/stream", withKeyParam(authorize(model.ScopeRead, h.wsHub.HandleStream))).
+		Methods("GET")
+
+	weatherRouter.HandleFunc("/aggregate", authorize(model.ScopeRead, h.getWeatherAggregate)).
 		Methods("GET")
 
-	apiRouter.HandleFunc("/weather", h.getWeatherByDateRange).
+	weatherRouter.HandleFunc("/{date}/history", authorize(model.ScopeRead, h.getWeatherHistory)).
+		Methods("GET")
+
+	weatherRouter.HandleFunc("/{date}", authorize(model.ScopeRead, h.getWeatherByDate)).
+		Methods("GET")
+
+	weatherRouter.HandleFunc("", authorize(model.ScopeRead, h.getWeatherByDateRange)).
 		Methods("GET").
 		Queries(
 			"from", "{from:[0-9]{4}-[0-9]{2}-[0-9]{2}}",
 			"to", "{to:[0-9]{4}-[0-9]{2}-[0-9]{2}}",
 		)
 
-	// WebSocket endpoint
-	apiRouter.HandleFunc("/weather/ws", h.wsHub.HandleConnection)
+	// corrections by operators, {date} is a day or the timestamp of a single reading
+	weatherRouter.HandleFunc("/{date}", authorize(model.ScopeIngest, h.replaceWeatherData)).
+		Methods("PUT")
+
+	weatherRouter.HandleFunc("/{date}", authorize(model.ScopeIngest, h.patchWeatherData)).
+		Methods("PATCH")
+
+	weatherRouter.HandleFunc("/{date}", authorize(model.ScopeIngest, h.deleteWeatherByDate)).
+		Methods("DELETE")
+
+	weatherRouter.HandleFunc("", authorize(model.ScopeIngest, h.deleteWeatherByDateRange)).
+		Methods("DELETE").
+		Queries(
+			"from", "{from:[0-9]{4}-[0-9]{2}-[0-9]{2}}",
+			"to", "{to:[0-9]{4}-[0-9]{2}-[0-9]{2}}",
+		)
 }
 
 func (h *HTTPHandler) ingestWeatherData(w http.ResponseWriter, r *http.Request) {
@@ -73,48 +188,208 @@ func (h *HTTPHandler) ingestWeatherData(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.ingestSvc.IngestSingle(ctx, &data); err != nil {
+	if err := applyRouteStation(r, &data); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if h.pipeline != nil {
+		h.enqueueWeatherData(w, r, &data)
+		return
+	}
+
+	outcome, err := h.ingestSvc.IngestSingle(ctx, &data)
+	if outcome != "" {
+		w.Header().Set(ingestOutcomeHeader, outcome)
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
 		h.logger.Error("Ingestion failed", zap.Error(err))
 		respondWithError(w, http.StatusInternalServerError, "Failed to ingest data")
 		return
 	}
 
-	// broadcast to WebSocket clients
-	h.wsHub.Broadcast(&data)
+	// a reading that was stored already is not created again
+	status := http.StatusCreated
+	if outcome != "" && outcome != storage.OutcomeInserted {
+		status = http.StatusOK
+	}
+	respondWithJSON(w, status, data)
+}
+
+func (h *HTTPHandler) ingestWeatherBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-	respondWithJSON(w, http.StatusCreated, data)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)
+
+	var (
+		records  []*model.WeatherData
+		inputIdx []int // position of each decoded record in the request body
+		results  []service.RecordResult
+		err      error
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		records, inputIdx, results, err = decodeJSONBatch(r.Body)
+	case "application/x-ndjson":
+		records, inputIdx, results, err = decodeNDJSONBatch(r.Body)
+	default:
+		h.logger.Warn("Invalid content type", zap.String("contentType", mediaType))
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json or application/x-ndjson")
+		return
+	}
+	if err != nil {
+		h.logger.Warn("Invalid batch payload", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, "Invalid batch payload")
+		return
+	}
+
+	if len(records) == 0 && len(results) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Batch is empty")
+		return
+	}
+
+	// readings posted below /stations/{id} belong to that station
+	for i := 0; i < len(records); i++ {
+		if err := applyRouteStation(r, records[i]); err != nil {
+			results = append(results, service.RecordResult{Index: inputIdx[i], Status: service.RecordRejected, Reason: err.Error()})
+			records = append(records[:i], records[i+1:]...)
+			inputIdx = append(inputIdx[:i], inputIdx[i+1:]...)
+			i--
+		}
+	}
+
+	batchResult := &service.BatchResult{}
+	if len(records) > 0 {
+		batchResult, err = h.ingestSvc.IngestBatch(ctx, records)
+		if err != nil {
+			h.logger.Error("Batch ingestion failed", zap.Error(err))
+			respondWithError(w, http.StatusInternalServerError, "Failed to ingest data")
+			return
+		}
+	}
+
+	// translate service indexes back to positions in the request body and merge with decode failures
+	for _, res := range batchResult.Results {
+		res.Index = inputIdx[res.Index]
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	response := service.BatchResult{Results: results}
+	for _, res := range results {
+		if res.Status == service.RecordAccepted {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+
+	status := http.StatusCreated
+	if response.Rejected > 0 {
+		status = http.StatusMultiStatus
+	}
+	respondWithJSON(w, status, response)
 }
 
-// create a new slice with only the requested fields included
-func filterFields(data []*model.WeatherData, fields []string) []map[string]any {
-	fieldMap := make(map[string]bool)
-	for _, f := range fields {
-		fieldMap[f] = true
+// decode a JSON array element by element so a malformed record only rejects itself
+func decodeJSONBatch(body io.Reader) ([]*model.WeatherData, []int, []service.RecordResult, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, nil, nil, err
 	}
 
-	// always include date field
-	fieldMap["date"] = true
+	records := make([]*model.WeatherData, 0, len(raw))
+	inputIdx := make([]int, 0, len(raw))
+	var rejected []service.RecordResult
+	for i, item := range raw {
+		var data model.WeatherData
+		if err := json.Unmarshal(item, &data); err != nil {
+			rejected = append(rejected, service.RecordResult{Index: i, Status: service.RecordRejected, Reason: "invalid JSON: " + err.Error()})
+			continue
+		}
+		records = append(records, &data)
+		inputIdx = append(inputIdx, i)
+	}
+	return records, inputIdx, rejected, nil
+}
+
+// decode one record per line, the index reported for NDJSON is the zero-based line number
+func decodeNDJSONBatch(body io.Reader) ([]*model.WeatherData, []int, []service.RecordResult, error) {
+	var (
+		records  []*model.WeatherData
+		inputIdx []int
+		rejected []service.RecordResult
+	)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var data model.WeatherData
+		if err := json.Unmarshal([]byte(text), &data); err != nil {
+			rejected = append(rejected, service.RecordResult{Index: line, Status: service.RecordRejected, Reason: "invalid JSON: " + err.Error()})
+			continue
+		}
+		records = append(records, &data)
+		inputIdx = append(inputIdx, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return records, inputIdx, rejected, nil
+}
+
+// create a new slice with only the requested fields included
+// only fields declared in the schema are honoured
+func filterFields(data []*model.WeatherData, fields []string) []map[string]any {
+	requested := projectableFields(fields)
 
 	result := make([]map[string]any, len(data))
 	for i, item := range data {
-		filtered := make(map[string]any)
+		result[i] = projectRecord(item, requested)
+	}
 
-		filtered["date"] = item.Date
+	return result
+}
 
-		// only include requested fields
-		if fieldMap["temperature"] {
-			filtered["temperature"] = item.Temperature
-		}
-		if fieldMap["humidity"] {
-			filtered["humidity"] = item.Humidity
+// projectableFields keeps the requested value fields that exist in the schema
+func projectableFields(fields []string) []string {
+	schema := model.ActiveSchema()
+	requested := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f != "date" && f != "station" && schema.HasField(f) {
+			requested = append(requested, f)
 		}
-		// here more fields would appear as the data model grows
-		// projection would be espcially useful for large documents with many fields
+	}
+	return requested
+}
+
+func projectRecord(item *model.WeatherData, requested []string) map[string]any {
+	filtered := make(map[string]any, len(requested)+2)
 
-		result[i] = filtered
+	// always include date field
+	filtered["date"] = item.Date
+	if item.Station != "" {
+		filtered["station"] = item.Station
 	}
 
-	return result
+	// only include requested fields
+	for _, f := range requested {
+		if v, ok := item.Values[f]; ok {
+			filtered[f] = v
+		}
+	}
+
+	return filtered
 }
 
 func (h *HTTPHandler) getWeatherByDate(w http.ResponseWriter, r *http.Request) {
@@ -128,31 +403,39 @@ func (h *HTTPHandler) getWeatherByDate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format, err := negotiateFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
 	// build query options from request
 	opts := buildQueryOptionsFromRequest(r)
+	if err := applyAsOf(r, opts); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	data, err := h.querySvc.GetByDate(ctx, date, opts)
 	if err != nil {
-		h.logger.Error("Query failed", zap.Error(err))
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve data")
+		h.respondWithQueryError(w, err)
 		return
 	}
 
-	if len(data) == 0 {
-		respondWithError(w, http.StatusNotFound, "No data found for specified date")
+	if err := h.setPaginationHeaders(w, r, data, opts, date, date.Add(24*time.Hour-time.Nanosecond)); err != nil {
+		h.respondWithQueryError(w, err)
 		return
 	}
 
-	// check if filtering fields is needed
-	fieldsParam := r.URL.Query().Get("fields")
-	if fieldsParam != "" {
-		fields := splitCommaSeparated(fieldsParam)
-		filteredData := filterFields(data, fields)
-		respondWithJSON(w, http.StatusOK, filteredData)
+	// an empty page after a continuation token is the end of the results, not a missing day
+	if len(data) == 0 && opts.Pagination.After == "" {
+		respondWithError(w, http.StatusNotFound, "No data found for specified date")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, data)
+	if err := respondWithRecords(w, format, data, opts.Fields); err != nil {
+		h.logger.Warn("Failed to write response", zap.Error(err))
+	}
 }
 
 func (h *HTTPHandler) getWeatherByDateRange(w http.ResponseWriter, r *http.Request) {
@@ -172,37 +455,150 @@ func (h *HTTPHandler) getWeatherByDateRange(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusBadRequest, "Invalid 'to' date format")
 		return
 	}
+	// the whole "to" day is included, like in aggregations and deletions
+	to = to.Add(24*time.Hour - time.Nanosecond)
+
+	format, err := negotiateFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
 
 	// build query options
 	opts := buildQueryOptionsFromRequest(r)
+	if err := applyAsOf(r, opts); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// streamed responses are written while the cursor is read instead of being buffered
+	if stream, _ := strconv.ParseBool(r.URL.Query().Get("stream")); stream {
+		h.streamWeatherRange(w, r, format, from, to, opts)
+		return
+	}
 
 	data, err := h.querySvc.GetByDateRange(ctx, from, to, opts)
 	if err != nil {
-		h.logger.Error("Query failed", zap.Error(err))
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve data")
+		h.respondWithQueryError(w, err)
+		return
+	}
+
+	if err := h.setPaginationHeaders(w, r, data, opts, from, to); err != nil {
+		h.respondWithQueryError(w, err)
 		return
 	}
 
-	if len(data) == 0 {
+	if len(data) == 0 && opts.Pagination.After == "" {
 		respondWithError(w, http.StatusNotFound, "No data found for specified range")
 		return
 	}
 
-	// check if filtering fields is needed
-	fieldsParam := r.URL.Query().Get("fields")
-	if fieldsParam != "" {
-		fields := splitCommaSeparated(fieldsParam)
-		filteredData := filterFields(data, fields)
-		respondWithJSON(w, http.StatusOK, filteredData)
+	if err := respondWithRecords(w, format, data, opts.Fields); err != nil {
+		h.logger.Warn("Failed to write response", zap.Error(err))
+	}
+}
+
+func (h *HTTPHandler) getWeatherAggregate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	from, err := time.Parse("2006-01-02", query.Get("from"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid 'from' date format")
+		return
+	}
+
+	to, err := time.Parse("2006-01-02", query.Get("to"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid 'to' date format")
+		return
+	}
+
+	metrics, err := parseMetrics(rawQueryParam(r, "metrics"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, data)
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		bucket = storage.BucketDay
+	}
+
+	opts := service.AggregateOptions{
+		Station: buildQueryOptionsFromRequest(r).Station,
+		Bucket:  bucket,
+		Metrics: metrics,
+	}
+
+	// the whole "to" day is included
+	buckets, err := h.querySvc.Aggregate(ctx, from, to.Add(24*time.Hour-time.Nanosecond), opts)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAggregate) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("Aggregation failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to aggregate data")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, buckets)
+}
+
+func (h *HTTPHandler) getWebSocketStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.wsHub.Stats())
+}
+
+// rawQueryParam reads a parameter whose value may contain unescaped semicolons,
+// which url.ParseQuery rejects and drops
+func rawQueryParam(r *http.Request, key string) string {
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		k, v, _ := strings.Cut(pair, "=")
+		if k != key {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			return unescaped
+		}
+		return v
+	}
+	return ""
+}
+
+// parseMetrics parses "temperature:avg,max;humidity:min" into field -> operations
+func parseMetrics(s string) (map[string][]string, error) {
+	metrics := make(map[string][]string)
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		field, ops, ok := strings.Cut(spec, ":")
+		field = strings.TrimSpace(field)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("invalid metric %q, expected field:op[,op]", spec)
+		}
+		for _, op := range splitCommaSeparated(ops) {
+			if op != "" {
+				metrics[field] = append(metrics[field], op)
+			}
+		}
+		if len(metrics[field]) == 0 {
+			return nil, fmt.Errorf("no operations given for metric %q", field)
+		}
+	}
+	return metrics, nil
 }
 
 func buildQueryOptionsFromRequest(r *http.Request) *service.QueryOptions {
 	opts := &service.QueryOptions{
 		ExcludeID: true, // exclude ID by default
+		Station:   r.URL.Query().Get("station"),
+	}
+
+	// station-scoped routes take precedence over the query parameter
+	if id := mux.Vars(r)["id"]; id != "" {
+		opts.Station = id
 	}
 
 	// field projection
@@ -219,9 +615,28 @@ func buildQueryOptionsFromRequest(r *http.Request) *service.QueryOptions {
 		opts.Pagination.Limit = limit
 	}
 
+	// keyset pagination token from a previous response
+	opts.Pagination.After = r.URL.Query().Get("after")
+	if opts.Pagination.After != "" && opts.Pagination.Limit == 0 {
+		opts.Pagination.Limit = defaultPageLimit
+	}
+
 	return opts
 }
 
+// applyRouteStation assigns the {id} route variable to a reading, rejecting readings that name another station
+func applyRouteStation(r *http.Request, data *model.WeatherData) error {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		return nil
+	}
+	if data.Station != "" && data.Station != id {
+		return fmt.Errorf("station %q does not match route station %q", data.Station, id)
+	}
+	data.Station = id
+	return nil
+}
+
 // helper function to split a comma-separated string and trim spaces
 // important for performance when documents grow large and many fields are requested
 
@@ -238,6 +653,24 @@ func splitCommaSeparated(s string) []string {
 	return parts
 }
 
+// respondWithQueryError maps query errors to a status, bad continuation tokens and date ranges are client errors
+func (h *HTTPHandler) respondWithQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid 'after' token")
+		return
+	}
+	if errors.Is(err, service.ErrInvalidRange) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrHistoryUnavailable) {
+		respondWithError(w, http.StatusNotImplemented, "Revision history is not available")
+		return
+	}
+	h.logger.Error("Query failed", zap.Error(err))
+	respondWithError(w, http.StatusInternalServerError, "Failed to retrieve data")
+}
+
 func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"go.uber.org/zap"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// set on responses that were replayed from an earlier request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// response headers stored with an idempotent response and replayed with it
+var replayedHeaders = []string{"Content-Type", "Location", ingestOutcomeHeader}
+
+// idempotent makes next safe to retry with an Idempotency-Key header
+// the first response for a key is stored and replayed for retries with the same request,
+// a different request with the same key gets 422, server errors release the key so a retry runs again
+func (h *HTTPHandler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || h.idempotency == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key must not be longer than 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+		if err != nil {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := hashRequest(r, body)
+
+		ctx := r.Context()
+		stored, err := h.idempotency.Begin(ctx, key, requestHash)
+		switch {
+		case errors.Is(err, service.ErrIdempotencyKeyMismatch):
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		case errors.Is(err, service.ErrIdempotencyKeyInUse):
+			w.Header().Set("Retry-After", "1")
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			h.logger.Error("Idempotency key lookup failed", zap.Error(err))
+			respondWithError(w, http.StatusServiceUnavailable, "Failed to check Idempotency-Key")
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &responseCapture{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// the outcome is stored even if the client went away, that is exactly when it retries
+		saveCtx := context.WithoutCancel(ctx)
+		if rec.status >= http.StatusInternalServerError {
+			if err := h.idempotency.Abandon(saveCtx, key); err != nil {
+				h.logger.Error("Failed to release idempotency key", zap.Error(err))
+			}
+			return
+		}
+
+		headers := make(map[string]string, len(replayedHeaders))
+		for _, name := range replayedHeaders {
+			if value := rec.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		if err := h.idempotency.Complete(saveCtx, key, requestHash, rec.status, headers, rec.body.Bytes()); err != nil {
+			h.logger.Error("Failed to store idempotent response", zap.Error(err))
+		}
+	}
+}
+
+// hashRequest identifies a request by method, path, body and the API key that sent it,
+// so a key cannot replay the response to another key
+func hashRequest(r *http.Request, body []byte) string {
+	hasher := sha256.New()
+	io.WriteString(hasher, r.Method+" "+r.URL.Path+"\n")
+	if source := service.SourceFromContext(r.Context(), ""); source != "" {
+		io.WriteString(hasher, source+"\n")
+	}
+	hasher.Write(body)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// responseCapture passes a response through and keeps a copy of its status and body
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+)
+
+// setPaginationHeaders announces the next page of a keyset-paginated response
+// a full page gets an X-Next-Cursor token and a Link rel="next" header
+// ?count=true adds X-Total-Count for the whole [start, end] range
+func (h *HTTPHandler) setPaginationHeaders(
+	w http.ResponseWriter,
+	r *http.Request,
+	data []*model.WeatherData,
+	opts *service.QueryOptions,
+	start, end time.Time,
+) error {
+	if count, _ := strconv.ParseBool(r.URL.Query().Get("count")); count {
+		total, err := h.querySvc.CountByDateRange(r.Context(), start, end, opts)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	}
+
+	limit := opts.Pagination.Limit
+	if limit <= 0 || int64(len(data)) < limit {
+		return nil
+	}
+
+	token := service.EncodeCursor(data[len(data)-1])
+	w.Header().Set("X-Next-Cursor", token)
+
+	next := *r.URL
+	query := next.Query()
+	query.Del("page")
+	query.Set("after", token)
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// seconds a client should wait before retrying a reading turned away by a full queue
+const queueFullRetryAfter = "1"
+
+// enqueueWeatherData queues a reading and answers 202 with a ticket that can be polled at Location
+func (h *HTTPHandler) enqueueWeatherData(w http.ResponseWriter, r *http.Request, data *model.WeatherData) {
+	ticket, err := h.pipeline.Enqueue(r.Context(), data)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrInvalidData):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, service.ErrQueueFull), errors.Is(err, service.ErrPipelineClosed):
+		w.Header().Set("Retry-After", queueFullRetryAfter)
+		respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	default:
+		h.logger.Error("Enqueue failed", zap.Error(err))
+		respondWithError(w, http.StatusServiceUnavailable, "Failed to queue data")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/ingest/"+ticket.ID)
+	respondWithJSON(w, http.StatusAccepted, ticket)
+}
+
+func (h *HTTPHandler) getIngestStats(w http.ResponseWriter, r *http.Request) {
+	if h.pipeline == nil {
+		respondWithError(w, http.StatusNotFound, "Asynchronous ingestion is disabled")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h.pipeline.Stats())
+}
+
+func (h *HTTPHandler) getIngestTicket(w http.ResponseWriter, r *http.Request) {
+	if h.pipeline == nil {
+		respondWithError(w, http.StatusNotFound, "Asynchronous ingestion is disabled")
+		return
+	}
+	ticket, ok := h.pipeline.Ticket(mux.Vars(r)["ticket"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown or expired ticket")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ticket)
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultKeepalive = 15 * time.Second
+
+// WithStreamKeepalive sets how often idle SSE streams get a comment, so proxies do not close them
+func WithStreamKeepalive(d time.Duration) HubOption {
+	return func(h *WebSocketHubImpl) {
+		if d > 0 {
+			h.keepalive = d
+		}
+	}
+}
+
+// HandleStream serves broadcasts as text/event-stream to clients that cannot use WebSockets
+// it takes the subscription parameters of HandleConnection, a reconnecting client resumes after its Last-Event-ID
+// readings are sent as unnamed events, deletions, snapshots and resets as named ones, each with the sequence number as id
+func (h *WebSocketHubImpl) HandleStream(w http.ResponseWriter, r *http.Request) {
+	sub, err := subscriptionFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// EventSource sends Last-Event-ID on reconnects only, ?since= works for the first connection
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("since")
+	}
+	since, err := parseSince(lastEventID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(writeWait))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses unless told otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.logger.Warn("Streaming not supported", zap.Error(err))
+		return
+	}
+
+	client := &hubClient{
+		transport:   transportSSE,
+		subs:        map[string]*wsSubscription{sub.id: sub},
+		ready:       make(chan struct{}),
+		since:       since,
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now().UTC(),
+	}
+	select {
+	case h.register <- client:
+	case <-h.done:
+		return
+	}
+	// the hub loop never stops between registering a client and making it ready
+	<-client.ready
+	defer func() {
+		select {
+		case h.unregister <- client:
+		case <-h.done:
+		}
+	}()
+
+	ticker := time.NewTicker(h.keepalive)
+	defer ticker.Stop()
+
+	var buf bytes.Buffer
+	for {
+		buf.Reset()
+		event := false
+		select {
+		case frame, ok := <-client.send:
+			if !ok {
+				return
+			}
+			writeEvent(&buf, frame)
+			event = true
+		case <-ticker.C:
+			buf.WriteString(": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			h.logger.Warn("Write failed", zap.Error(err))
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if event {
+			client.sent.Add(1)
+		}
+	}
+}
+
+// writeEvent formats a frame as a server-sent event, the JSON payload never contains newlines
+func writeEvent(buf *bytes.Buffer, frame hubFrame) {
+	if frame.event != "" {
+		buf.WriteString("event: " + frame.event + "\n")
+	}
+	if frame.id != "" {
+		buf.WriteString("id: " + frame.id + "\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(frame.data)
+	buf.WriteString("\n\n")
+}
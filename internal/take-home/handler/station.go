@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// requireStation rejects station-scoped requests for stations that were never registered
+func (h *HTTPHandler) requireStation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := h.stationSvc.GetStation(r.Context(), id); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				respondWithError(w, http.StatusNotFound, "Unknown station")
+				return
+			}
+			h.logger.Error("Station lookup failed", zap.String("station", id), zap.Error(err))
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve station")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *HTTPHandler) listStations(w http.ResponseWriter, r *http.Request) {
+	stations, err := h.stationSvc.ListStations(r.Context())
+	if err != nil {
+		h.logger.Error("Query failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve stations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, stations)
+}
+
+func (h *HTTPHandler) getStation(w http.ResponseWriter, r *http.Request) {
+	station, err := h.stationSvc.GetStation(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Unknown station")
+			return
+		}
+		h.logger.Error("Query failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve station")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, station)
+}
+
+// registerStation creates or replaces the metadata of the station named in the path
+func (h *HTTPHandler) registerStation(w http.ResponseWriter, r *http.Request) {
+	var station model.Station
+	if err := json.NewDecoder(r.Body).Decode(&station); err != nil {
+		h.logger.Warn("Invalid request payload", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if station.ID != "" && station.ID != id {
+		respondWithError(w, http.StatusBadRequest, "Station id in body does not match path")
+		return
+	}
+	station.ID = id
+
+	if err := station.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.stationSvc.RegisterStation(r.Context(), &station); err != nil {
+		h.logger.Error("Station registration failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to register station")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, station)
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"iter"
+	"net/http"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"go.uber.org/zap"
+)
+
+const (
+	// records written between two flushes of a streamed response
+	streamFlushEvery = 500
+	// write deadline granted to each chunk, the server wide WriteTimeout would cut long streams
+	streamChunkDeadline = 15 * time.Second
+)
+
+// streamWeatherRange writes a range query as it is read from the database
+// the body is written in chunks in the negotiated format, a JSON array unless the client asks for another one
+// the first record is read before the status is sent so that a failing query is still answered with 500
+// errors after the first byte cannot change the status anymore, the response is then cut short
+func (h *HTTPHandler) streamWeatherRange(w http.ResponseWriter, r *http.Request, format exportFormat, from, to time.Time, opts *service.QueryOptions) {
+	ctx := r.Context()
+
+	records, err := h.querySvc.StreamByDateRange(ctx, from, to, opts)
+	if err != nil {
+		h.logger.Warn("Invalid stream request", zap.Error(err))
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	next, stop := iter.Pull2(records)
+	defer stop()
+
+	data, err, ok := next()
+	if err != nil {
+		h.logger.Error("Stream failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve data")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(streamChunkDeadline))
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+
+	// the stations of a stream are not known upfront, unscoped dat streams always carry the station column
+	rw, err := newRecordWriter(w, format, projectedFields(opts.Fields), opts.Station == "")
+	if err != nil {
+		h.logger.Warn("Stream write failed", zap.Error(err))
+		return
+	}
+
+	count := 0
+	for ; ok; data, err, ok = next() {
+		if err != nil {
+			h.logger.Error("Stream aborted", zap.Int("written", count), zap.Error(err))
+			return
+		}
+
+		if err := rw.write(data); err != nil {
+			h.logger.Warn("Stream write failed", zap.Int("written", count), zap.Error(err))
+			return
+		}
+
+		count++
+		if count%streamFlushEvery == 0 {
+			rw.flush()
+			rc.Flush()
+			rc.SetWriteDeadline(time.Now().Add(streamChunkDeadline))
+		}
+	}
+
+	if err := rw.close(); err != nil {
+		h.logger.Warn("Stream write failed", zap.Int("written", count), zap.Error(err))
+		return
+	}
+	rc.Flush()
+}
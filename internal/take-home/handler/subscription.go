@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/gorilla/mux"
+)
+
+// control messages a WebSocket client can send
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+)
+
+// id of the subscription made from the connection URL, also used for control messages without an id
+const defaultSubscriptionID = "default"
+
+// wsRequest is a control message sent by a client, e.g.
+// {"type":"subscribe","id":"hot","stations":["berlin"],"fields":["temperature"],"where":["temperature > 30"]}
+type wsRequest struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
+	Stations []string `json:"stations,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Where    []string `json:"where,omitempty"`
+}
+
+// wsReply acknowledges a control message, or rejects it with an error
+type wsReply struct {
+	Event string `json:"event"`
+	Type  string `json:"type,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// wsSubscription selects the readings sent to a client and the fields they are projected to
+// empty stations match every station, nil fields send readings in full
+type wsSubscription struct {
+	id       string
+	stations map[string]struct{}
+	fields   []string
+	where    []predicate
+}
+
+// SubscriptionStats describes a subscription of a client
+type SubscriptionStats struct {
+	ID       string   `json:"id"`
+	Stations []string `json:"stations,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Where    []string `json:"where,omitempty"`
+}
+
+// newSubscription validates a subscription, fields follow the ?fields= semantics of the REST API
+// so fields outside the schema are ignored, predicates on unknown fields are an error
+func newSubscription(id string, stations, fields, where []string) (*wsSubscription, error) {
+	if id == "" {
+		id = defaultSubscriptionID
+	}
+	sub := &wsSubscription{id: id, stations: make(map[string]struct{})}
+	for _, station := range stations {
+		if station = strings.TrimSpace(station); station != "" {
+			sub.stations[station] = struct{}{}
+		}
+	}
+	if len(fields) > 0 {
+		sub.fields = projectableFields(fields)
+	}
+	for _, expr := range where {
+		p, err := parsePredicate(expr)
+		if err != nil {
+			return nil, err
+		}
+		sub.where = append(sub.where, p)
+	}
+	return sub, nil
+}
+
+// subscriptionFromRequest builds the initial subscription of a connection from the {id} route variable
+// and the ?stations=, ?fields= and repeated ?where= parameters
+func subscriptionFromRequest(r *http.Request) (*wsSubscription, error) {
+	query := r.URL.Query()
+	stations := splitCommaSeparated(query.Get("stations"))
+	if id := mux.Vars(r)["id"]; id != "" {
+		stations = append(stations, id)
+	}
+	var fields []string
+	if raw := query.Get("fields"); raw != "" {
+		fields = splitCommaSeparated(raw)
+	}
+	return newSubscription(defaultSubscriptionID, stations, fields, query["where"])
+}
+
+// matches reports whether a reading is selected, deleted readings are matched by station only
+func (s *wsSubscription) matches(data *model.WeatherData, deleted bool) bool {
+	if len(s.stations) > 0 {
+		if _, ok := s.stations[data.Station]; !ok {
+			return false
+		}
+	}
+	if deleted {
+		return true
+	}
+	for _, p := range s.where {
+		if !p.matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *wsSubscription) stats() SubscriptionStats {
+	stats := SubscriptionStats{ID: s.id, Fields: s.fields}
+	for station := range s.stations {
+		stats.Stations = append(stats.Stations, station)
+	}
+	slices.Sort(stats.Stations)
+	for _, p := range s.where {
+		stats.Where = append(stats.Where, p.expr)
+	}
+	return stats
+}
+
+// predicateExpr matches "<field> <operator> <value>", e.g. "temperature > 30" or "station = berlin"
+var predicateExpr = regexp.MustCompile(`^\s*([A-Za-z_][\w.-]*)\s*(==|!=|<=|>=|=|<|>)\s*(.+?)\s*$`)
+
+// predicate compares a field of a reading with a value of the column's type
+type predicate struct {
+	expr   string
+	field  string
+	op     string
+	column *model.Column // nil for the station
+	value  any
+}
+
+func parsePredicate(expr string) (predicate, error) {
+	m := predicateExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return predicate{}, fmt.Errorf("invalid predicate %q, expected e.g. \"temperature > 30\"", expr)
+	}
+	p := predicate{expr: expr, field: m[1], op: m[2]}
+	raw := strings.Trim(m[3], `"'`)
+
+	if p.field == "station" {
+		p.value = raw
+		return p, nil
+	}
+	col, ok := model.ActiveSchema().Column(p.field)
+	if !ok || col.Type == model.ColumnDate {
+		return predicate{}, fmt.Errorf("cannot filter on unknown field %q", p.field)
+	}
+	value, err := col.ParseValue(raw)
+	if err != nil || value == nil {
+		return predicate{}, fmt.Errorf("invalid value %q for %s", raw, p.field)
+	}
+	p.column, p.value = &col, value
+	return p, nil
+}
+
+// matches reports whether the reading satisfies the predicate, missing and null values never do
+func (p predicate) matches(data *model.WeatherData) bool {
+	var v any = data.Station
+	if p.column != nil {
+		coerced, err := p.column.Coerce(data.Values[p.field])
+		if err != nil || coerced == nil {
+			return false
+		}
+		v = coerced
+	}
+
+	var c int
+	switch x := v.(type) {
+	case float64:
+		c = cmp.Compare(x, p.value.(float64))
+	case int64:
+		c = cmp.Compare(x, p.value.(int64))
+	case string:
+		c = cmp.Compare(x, p.value.(string))
+	default:
+		return false
+	}
+
+	switch p.op {
+	case "=", "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// parseRequest decodes a control message, the returned subscription is nil for unsubscribe
+func parseRequest(msg []byte) (wsRequest, *wsSubscription, error) {
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return req, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if req.ID == "" {
+		req.ID = defaultSubscriptionID
+	}
+
+	switch req.Type {
+	case wsSubscribe:
+		sub, err := newSubscription(req.ID, req.Stations, req.Fields, req.Where)
+		return req, sub, err
+	case wsUnsubscribe:
+		return req, nil, nil
+	}
+	return req, nil, fmt.Errorf("unknown message type %q, expected subscribe or unsubscribe", req.Type)
+}
+
+// projection returns the fields to send a reading with, nil when any matching subscription wants it in full
+// the fields of several matching subscriptions are merged
+func projection(subs map[string]*wsSubscription, data *model.WeatherData, deleted bool) ([]string, bool) {
+	fields := make([]string, 0)
+	matched, full := false, false
+	for _, sub := range subs {
+		if !sub.matches(data, deleted) {
+			continue
+		}
+		matched = true
+		if sub.fields == nil {
+			full = true
+			continue
+		}
+		fields = append(fields, sub.fields...)
+	}
+	if !matched || full || deleted {
+		return nil, matched
+	}
+	slices.Sort(fields)
+	return slices.Compact(fields), true
+}
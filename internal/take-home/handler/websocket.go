@@ -2,8 +2,13 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
@@ -17,9 +22,30 @@ const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 4096 // control messages with a few predicates
 )
 
+// what happens to a message for a client whose send queue is full
+const (
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowDisconnect = "disconnect"
+)
+
+const (
+	defaultClientQueueSize = 64
+	broadcastBufferSize    = 256
+)
+
+// ValidOverflowPolicy reports whether policy names a known overflow policy
+func ValidOverflowPolicy(policy string) bool {
+	switch policy {
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect:
+		return true
+	}
+	return false
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -48,42 +74,210 @@ var upgrader = websocket.Upgrader{
 	*/
 }
 
+// transports a client can be connected with
+const (
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+)
+
+// hubClient is a WebSocket or SSE connection together with its subscriptions, see subscription.go
+type hubClient struct {
+	transport string
+	// nil for SSE clients, their handler writes the stream
+	conn *websocket.Conn
+	// by id, only changed by the hub loop
+	subs map[string]*wsSubscription
+	// messages waiting for the writer goroutine, made on registration and closed by the hub when the client is removed
+	send chan hubFrame
+	// closed by the hub once send is made
+	ready chan struct{}
+	// position the client resumes after, nil for a snapshot
+	since       *position
+	remoteAddr  string
+	connectedAt time.Time
+	sent        atomic.Int64
+	dropped     atomic.Int64
+}
+
+// hubFrame is a message queued for a client
+// WebSocket clients get data as it is, SSE clients get the event name and id as well
+type hubFrame struct {
+	event string // empty for readings
+	id    string // position of the event, empty for frames outside the broadcast sequence
+	data  []byte
+}
+
+// enqueue hands frame to the writer goroutine without blocking, a full queue is handled by overflow
+// false means the client falls too far behind and has to be disconnected
+func (c *hubClient) enqueue(frame hubFrame, overflow string) bool {
+	select {
+	case c.send <- frame:
+		return true
+	default:
+	}
+
+	switch overflow {
+	case OverflowDropNewest:
+		c.dropped.Add(1)
+		return true
+	case OverflowDisconnect:
+		c.dropped.Add(1)
+		return false
+	}
+	// make room by dropping the oldest message, the writer may have taken one in the meantime
+	select {
+	case <-c.send:
+		c.dropped.Add(1)
+	default:
+	}
+	select {
+	case c.send <- frame:
+	default:
+		c.dropped.Add(1)
+	}
+	return true
+}
+
+// wsEvent is a change of a reading queued for broadcast, numbered by the hub loop
+type wsEvent struct {
+	seq     int64
+	data    *model.WeatherData
+	deleted bool
+}
+
+// wsControl is a control message of a client on its way to the hub loop
+// err is answered with an error frame, sub is nil for unsubscribe
+type wsControl struct {
+	client *hubClient
+	req    wsRequest
+	sub    *wsSubscription
+	err    error
+}
+
+// wsDeleted is sent for a removed reading, stored readings are sent as they are
+type wsDeleted struct {
+	Event   string    `json:"event"`
+	Station string    `json:"station,omitempty"`
+	Date    time.Time `json:"date"`
+}
+
+// HubStats describes the connected clients and the messages they missed
+type HubStats struct {
+	// epoch of the sequence numbers, the prefix of every seq
+	Epoch     string `json:"epoch"`
+	Clients   int    `json:"clients"`
+	QueueSize int    `json:"queueSize"`
+	Overflow  string `json:"overflow"`
+	// broadcasts dropped before the hub loop picked them up
+	Dropped int64 `json:"dropped"`
+	// clients disconnected by the disconnect overflow policy
+	Disconnected int64         `json:"disconnected"`
+	Connections  []ClientStats `json:"connections"`
+}
+
+// ClientStats describes one connection, Dropped counts messages lost to its overflow policy
+type ClientStats struct {
+	Transport     string              `json:"transport"`
+	RemoteAddr    string              `json:"remoteAddr"`
+	ConnectedAt   time.Time           `json:"connectedAt"`
+	Subscriptions []SubscriptionStats `json:"subscriptions"`
+	Queued        int                 `json:"queued"`
+	Sent          int64               `json:"sent"`
+	Dropped       int64               `json:"dropped"`
+}
+
+// WebSocketHubImpl fans broadcasts out to the send queues of its clients
+// every client has a writer goroutine, so the hub loop never waits for the network
 type WebSocketHubImpl struct {
-	clients    map[*websocket.Conn]struct{}
+	clients    map[*hubClient]struct{}
 	clientsMu  sync.RWMutex
-	broadcast  chan *model.WeatherData
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	logger     *zap.Logger
-}
-
-func NewWebSocketHub(logger *zap.Logger) WebSocketHub {
-	return &WebSocketHubImpl{
-		broadcast:  make(chan *model.WeatherData, 256),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
-		clients:    make(map[*websocket.Conn]struct{}),
+	broadcast  chan wsEvent
+	register   chan *hubClient
+	unregister chan *hubClient
+	control    chan wsControl
+	// closed when Run returns, so connections do not wait for a stopped hub
+	done   chan struct{}
+	logger *zap.Logger
+
+	queueSize int
+	overflow  string
+	// interval of keepalive comments on SSE streams
+	keepalive time.Duration
+	// recent broadcasts and the newest reading per station, only used by the hub loop
+	// broadcasts are numbered under epoch, which is new for every hub
+	epoch  string
+	replay *eventRing
+	latest map[string]*model.WeatherData
+
+	// numbers broadcasts before the handoff, so broadcasts dropped on a full channel leave a gap in the sequence
+	seqMu sync.Mutex
+	seq   int64
+
+	dropped      atomic.Int64
+	disconnected atomic.Int64
+}
+
+// HubOption customises a WebSocketHubImpl at construction time
+type HubOption func(*WebSocketHubImpl)
+
+// WithClientQueueSize sets how many messages may wait for a slow client
+func WithClientQueueSize(n int) HubOption {
+	return func(h *WebSocketHubImpl) {
+		if n > 0 {
+			h.queueSize = n
+		}
+	}
+}
+
+// WithOverflowPolicy sets what happens when the queue of a client is full, see OverflowDropOldest
+func WithOverflowPolicy(policy string) HubOption {
+	return func(h *WebSocketHubImpl) {
+		if ValidOverflowPolicy(policy) {
+			h.overflow = policy
+		}
+	}
+}
+
+func NewWebSocketHub(logger *zap.Logger, opts ...HubOption) WebSocketHub {
+	h := &WebSocketHubImpl{
+		broadcast:  make(chan wsEvent, broadcastBufferSize),
+		register:   make(chan *hubClient),
+		unregister: make(chan *hubClient),
+		control:    make(chan wsControl),
+		done:       make(chan struct{}),
+		clients:    make(map[*hubClient]struct{}),
 		logger:     logger.Named("websocket_hub"),
+		queueSize:  defaultClientQueueSize,
+		overflow:   OverflowDropOldest,
+		keepalive:  defaultKeepalive,
+		epoch:      newEpoch(),
+		replay:     newEventRing(defaultReplaySize),
+		latest:     make(map[string]*model.WeatherData),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *WebSocketHubImpl) Run(ctx context.Context) {
-	h.logger.Info("Starting WebSocket hub")
+	h.logger.Info("Starting WebSocket hub", zap.Int("queueSize", h.queueSize), zap.String("overflow", h.overflow))
 	defer h.logger.Info("WebSocket hub stopped")
+	defer close(h.done)
 
 	for {
 		select {
 		case client := <-h.register:
-			h.clientsMu.Lock()
-			h.clients[client] = struct{}{}
-			h.clientsMu.Unlock()
-			h.logger.Debug("Client registered", zap.Int("count", len(h.clients)))
+			h.addClient(client)
 
 		case client := <-h.unregister:
-			h.safeRemoveClient(client)
+			h.removeClient(client)
+
+		case event := <-h.broadcast:
+			h.broadcastToClients(event)
 
-		case data := <-h.broadcast:
-			h.broadcastToClients(data)
+		case c := <-h.control:
+			h.applyControl(c)
 
 		case <-ctx.Done():
 			h.cleanup()
@@ -92,36 +286,145 @@ func (h *WebSocketHubImpl) Run(ctx context.Context) {
 	}
 }
 
-func (h *WebSocketHubImpl) safeRemoveClient(conn *websocket.Conn) {
+// addClient queues the backlog of a client ahead of live broadcasts and starts the writer of WebSocket clients
+// the queue has room for the whole backlog, overflow only applies to live broadcasts
+func (h *WebSocketHubImpl) addClient(client *hubClient) {
+	backlog, err := h.backlog(client)
+	if err != nil {
+		h.logger.Warn("Failed to encode backlog", zap.Error(err))
+	}
+	client.send = make(chan hubFrame, h.queueSize+len(backlog))
+	for _, frame := range backlog {
+		client.send <- frame
+	}
+	close(client.ready)
+
+	h.clientsMu.Lock()
+	h.clients[client] = struct{}{}
+	h.clientsMu.Unlock()
+	h.logger.Debug("Client registered",
+		zap.String("transport", client.transport),
+		zap.Int("count", len(h.clients)),
+		zap.Int("backlog", len(backlog)),
+	)
+
+	// the writer owns all writes to the connection, including pings
+	if client.conn != nil {
+		go h.writePump(client)
+	}
+}
+
+// removeClient closes the send queue of a client, its writer then ends the connection
+// only the hub loop removes clients, so nothing is sent on a closed queue
+func (h *WebSocketHubImpl) removeClient(client *hubClient) {
 	h.clientsMu.Lock()
 	defer h.clientsMu.Unlock()
 
-	if _, exists := h.clients[conn]; exists {
-		conn.Close()
-		delete(h.clients, conn)
-		h.logger.Debug("Client unregistered", zap.Int("count", len(h.clients)))
+	if _, exists := h.clients[client]; exists {
+		close(client.send)
+		delete(h.clients, client)
+		h.logger.Debug("Client unregistered",
+			zap.Int("count", len(h.clients)),
+			zap.Int64("sent", client.sent.Load()),
+			zap.Int64("dropped", client.dropped.Load()),
+		)
 	}
 }
 
-func (h *WebSocketHubImpl) broadcastToClients(data *model.WeatherData) {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
+func (h *WebSocketHubImpl) broadcastToClients(event wsEvent) {
+	h.replay.add(event)
+	h.remember(event)
+	id := h.position(event.seq).String()
 
-	if len(h.clients) == 0 {
-		return
+	// encoded once per projection
+	encoded := make(map[string][]byte)
+	encode := func(fields []string) ([]byte, error) {
+		key := "*"
+		if fields != nil {
+			key = strings.Join(fields, ",")
+		}
+		if msg, ok := encoded[key]; ok {
+			return msg, nil
+		}
+		msg, err := encodeEvent(id, event, fields)
+		if err != nil {
+			return nil, err
+		}
+		encoded[key] = msg
+		return msg, nil
+	}
+
+	frameEvent := ""
+	if event.deleted {
+		frameEvent = "deleted"
 	}
 
+	var overflowed []*hubClient
+	h.clientsMu.RLock()
 	for client := range h.clients {
-		if err := h.writeData(client, data); err != nil {
-			h.logger.Warn("Write failed", zap.Error(err))
-			go func(c *websocket.Conn) { h.unregister <- c }(client)
+		fields, ok := projection(client.subs, event.data, event.deleted)
+		if !ok {
+			continue
 		}
+		msg, err := encode(fields)
+		if err != nil {
+			h.logger.Warn("Failed to encode broadcast", zap.Error(err))
+			break
+		}
+		if !client.enqueue(hubFrame{event: frameEvent, id: id, data: msg}, h.overflow) {
+			overflowed = append(overflowed, client)
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	for _, client := range overflowed {
+		h.disconnect(client)
 	}
 }
 
-func (h *WebSocketHubImpl) writeData(conn *websocket.Conn, data *model.WeatherData) error {
-	conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return conn.WriteJSON(data)
+// disconnect removes a client that fell too far behind
+func (h *WebSocketHubImpl) disconnect(client *hubClient) {
+	h.logger.Warn("Disconnecting slow client", zap.String("transport", client.transport), zap.String("remoteAddr", client.remoteAddr))
+	h.disconnected.Add(1)
+	h.removeClient(client)
+	// the writer may be stuck on the full socket, closing the connection lets it return
+	if client.conn != nil {
+		client.conn.Close()
+	}
+}
+
+// applyControl changes the subscriptions of a client and answers with an ack or error frame
+// replies go through the send queue like broadcasts, so the writer goroutine stays the only writer
+func (h *WebSocketHubImpl) applyControl(c wsControl) {
+	reply := wsReply{Event: "ack", Type: c.req.Type, ID: c.req.ID}
+
+	h.clientsMu.Lock()
+	if _, ok := h.clients[c.client]; !ok {
+		// already removed, its send queue is closed
+		h.clientsMu.Unlock()
+		return
+	}
+	switch {
+	case c.err != nil:
+		reply.Event, reply.Error = "error", c.err.Error()
+	case c.sub != nil:
+		c.client.subs[c.sub.id] = c.sub
+	default:
+		if _, ok := c.client.subs[c.req.ID]; !ok {
+			reply.Event, reply.Error = "error", fmt.Sprintf("unknown subscription %q", c.req.ID)
+		}
+		delete(c.client.subs, c.req.ID)
+	}
+	h.clientsMu.Unlock()
+
+	msg, err := json.Marshal(reply)
+	if err != nil {
+		h.logger.Warn("Failed to encode reply", zap.Error(err))
+		return
+	}
+	if !c.client.enqueue(hubFrame{event: reply.Event, data: msg}, h.overflow) {
+		h.disconnect(c.client)
+	}
 }
 
 func (h *WebSocketHubImpl) cleanup() {
@@ -129,12 +432,36 @@ func (h *WebSocketHubImpl) cleanup() {
 	defer h.clientsMu.Unlock()
 
 	for client := range h.clients {
-		client.Close()
+		close(client.send)
+		delete(h.clients, client)
 	}
-	h.logger.Info("Cleaned up all WebSocket connections")
+	h.logger.Info("Cleaned up all connections")
 }
 
+// HandleConnection upgrades the request and subscribes the client to the stations
+// given by the {id} route variable or the comma-separated ?stations= parameter
+// the subscription is narrowed with ?fields= and ?where=, or replaced by control messages later
+// the client first gets a snapshot of the latest readings, or the broadcasts after ?since=<seq>
 func (h *WebSocketHubImpl) HandleConnection(w http.ResponseWriter, r *http.Request) {
+	sub, err := subscriptionFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	client := &hubClient{
+		transport:   transportWebSocket,
+		subs:        map[string]*wsSubscription{sub.id: sub},
+		ready:       make(chan struct{}),
+		since:       since,
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now().UTC(),
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		h.logger.Error("Upgrade failed", zap.Error(err))
@@ -149,37 +476,119 @@ func (h *WebSocketHubImpl) HandleConnection(w http.ResponseWriter, r *http.Reque
 		return nil
 	})
 
-	// register client
-	h.register <- conn
-	defer func() { h.unregister <- conn }()
-
-	// start heartbeat goroutine
-	go h.heartbeat(conn)
+	// register client, the hub starts its writer
+	client.conn = conn
+	select {
+	case h.register <- client:
+	case <-h.done:
+		conn.Close()
+		return
+	}
+	defer func() {
+		select {
+		case h.unregister <- client:
+		case <-h.done:
+		}
+	}()
 
-	// blocking to keep connection alive
+	// read control messages until the connection closes
 	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
 			break
 		}
+		req, sub, err := parseRequest(msg)
+		select {
+		case h.control <- wsControl{client: client, req: req, sub: sub, err: err}:
+		case <-h.done:
+			return
+		}
 	}
 }
 
-func (h *WebSocketHubImpl) heartbeat(conn *websocket.Conn) {
+// writePump writes queued messages and pings until the hub closes the send queue or a write fails
+func (h *WebSocketHubImpl) writePump(client *hubClient) {
 	ticker := time.NewTicker(pingPeriod)
 	defer ticker.Stop() // immediately release resources rather than waiting for the GC to operate through the NewTicker() instance
+	defer client.conn.Close()
 
-	for range ticker.C {
-		conn.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-			return
+	for {
+		select {
+		case frame, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, frame.data); err != nil {
+				h.logger.Warn("Write failed", zap.Error(err))
+				return
+			}
+			client.sent.Add(1)
+
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
 func (h *WebSocketHubImpl) Broadcast(data *model.WeatherData) {
+	h.enqueue(wsEvent{data: data})
+}
+
+// BroadcastDeleted tells subscribers of the station that the reading was removed
+func (h *WebSocketHubImpl) BroadcastDeleted(data *model.WeatherData) {
+	h.enqueue(wsEvent{data: data, deleted: true})
+}
+
+// enqueue numbers a broadcast and hands it to the hub loop without blocking
+// the lock keeps numbers in channel order, a dropped broadcast still uses up its number
+func (h *WebSocketHubImpl) enqueue(event wsEvent) {
+	h.seqMu.Lock()
+	defer h.seqMu.Unlock()
+
+	h.seq++
+	event.seq = h.seq
 	select {
-	case h.broadcast <- data:
+	case h.broadcast <- event:
 	default:
-		h.logger.Warn("Broadcast channel full - dropping message")
+		h.dropped.Add(1)
+		h.logger.Warn("Broadcast channel full - dropping message", zap.Int64("seq", event.seq))
+	}
+}
+
+func (h *WebSocketHubImpl) Stats() HubStats {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	stats := HubStats{
+		Epoch:        h.epoch,
+		Clients:      len(h.clients),
+		QueueSize:    h.queueSize,
+		Overflow:     h.overflow,
+		Dropped:      h.dropped.Load(),
+		Disconnected: h.disconnected.Load(),
+		Connections:  make([]ClientStats, 0, len(h.clients)),
+	}
+	for client := range h.clients {
+		subs := make([]SubscriptionStats, 0, len(client.subs))
+		for _, sub := range client.subs {
+			subs = append(subs, sub.stats())
+		}
+		slices.SortFunc(subs, func(a, b SubscriptionStats) int { return strings.Compare(a.ID, b.ID) })
+		stats.Connections = append(stats.Connections, ClientStats{
+			Transport:     client.transport,
+			RemoteAddr:    client.remoteAddr,
+			ConnectedAt:   client.connectedAt,
+			Subscriptions: subs,
+			Queued:        len(client.send),
+			Sent:          client.sent.Load(),
+			Dropped:       client.dropped.Load(),
+		})
 	}
+	slices.SortFunc(stats.Connections, func(a, b ClientStats) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	return stats
 }
@@ -12,5 +12,14 @@ type WebSocketHub interface {
 
 	HandleConnection(w http.ResponseWriter, r *http.Request)
 
+	// HandleStream serves the same broadcasts as server-sent events
+	HandleStream(w http.ResponseWriter, r *http.Request)
+
 	Broadcast(data *model.WeatherData)
+
+	// BroadcastDeleted announces a removed reading as {"event":"deleted","station":...,"date":...}
+	BroadcastDeleted(data *model.WeatherData)
+
+	// Stats reports the connected clients and how many messages they missed
+	Stats() HubStats
 }
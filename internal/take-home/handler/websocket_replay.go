@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const defaultReplaySize = 1024
+
+// wsSnapshot carries the latest reading of every subscribed station, sent on connect
+// Seq is the position the snapshot is current at, ?since=<seq> resumes from there
+type wsSnapshot struct {
+	Event    string            `json:"event"`
+	Seq      string            `json:"seq"`
+	Readings []json.RawMessage `json:"readings"`
+}
+
+// wsReset tells a client resuming with ?since= that the events it missed are no longer buffered,
+// or were numbered by another instance or an earlier run of this one
+type wsReset struct {
+	Event string `json:"event"`
+	Seq   string `json:"seq"`
+}
+
+// position is where a client resumes: the sequence number of an event and the epoch of the hub that numbered it
+// every hub numbers its broadcasts from 1 under a random epoch, so positions of other instances never match
+type position struct {
+	epoch string
+	seq   int64
+}
+
+// String formats the position as sent to clients, "<epoch>-<seq>"
+func (p position) String() string {
+	return p.epoch + "-" + strconv.FormatInt(p.seq, 10)
+}
+
+// newEpoch returns a random id for the sequence of a hub
+func newEpoch() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithReplayBuffer sets how many broadcasts are kept for clients resuming with ?since=
+func WithReplayBuffer(n int) HubOption {
+	return func(h *WebSocketHubImpl) {
+		if n > 0 {
+			h.replay = newEventRing(n)
+		}
+	}
+}
+
+// eventRing keeps the last broadcasts, the event with sequence number seq sits at (seq-1) % len(events)
+type eventRing struct {
+	events []wsEvent
+	last   int64 // sequence number of the newest event, 0 before the first
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]wsEvent, size)}
+}
+
+// add stores event, the slots of numbers skipped since the last event are cleared,
+// they belong to broadcasts that were dropped before the hub loop got them
+func (r *eventRing) add(event wsEvent) {
+	size := int64(len(r.events))
+	for s := max(r.last+1, event.seq-size+1); s < event.seq; s++ {
+		r.events[(s-1)%size] = wsEvent{}
+	}
+	r.last = event.seq
+	r.events[(event.seq-1)%size] = event
+}
+
+// since returns the events after seq, false when some of them were overwritten or dropped
+// or seq lies ahead of the ring, e.g. because the server restarted since
+func (r *eventRing) since(seq int64) ([]wsEvent, bool) {
+	oldest := max(r.last-int64(len(r.events))+1, 1)
+	if seq > r.last || seq < oldest-1 {
+		return nil, false
+	}
+	events := make([]wsEvent, 0, r.last-seq)
+	for s := seq + 1; s <= r.last; s++ {
+		event := r.events[(s-1)%int64(len(r.events))]
+		if event.seq != s {
+			return nil, false
+		}
+		events = append(events, event)
+	}
+	return events, true
+}
+
+// parseSince parses the position a client resumes after, nil when the client does not resume
+// a bare sequence number has no epoch and always leads to a reset
+func parseSince(raw string) (*position, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var p position
+	rawSeq := raw
+	if i := strings.LastIndexByte(raw, '-'); i >= 0 {
+		p.epoch, rawSeq = raw[:i], raw[i+1:]
+	}
+	seq, err := strconv.ParseInt(rawSeq, 10, 64)
+	if err != nil || seq < 0 || p.epoch == "" && rawSeq != raw {
+		return nil, fmt.Errorf("since must be the seq of a received event")
+	}
+	p.seq = seq
+	return &p, nil
+}
+
+// remember keeps the newest reading of each station for snapshots, a deleted newest reading is forgotten
+func (h *WebSocketHubImpl) remember(event wsEvent) {
+	latest, ok := h.latest[event.data.Station]
+	switch {
+	case event.deleted:
+		if ok && latest.Date.Equal(event.data.Date) {
+			delete(h.latest, event.data.Station)
+		}
+	case !ok || !event.data.Date.Before(latest.Date):
+		h.latest[event.data.Station] = event.data
+	}
+}
+
+// backlog returns the frames a new client is sent before live broadcasts:
+// the events it missed when resuming, otherwise a reset if needed and a snapshot
+func (h *WebSocketHubImpl) backlog(client *hubClient) ([]hubFrame, error) {
+	var frames []hubFrame
+	last := h.position(h.replay.last).String()
+	if client.since != nil {
+		missed, ok := h.replay.since(client.since.seq)
+		if ok && client.since.epoch == h.epoch {
+			for _, event := range missed {
+				fields, match := projection(client.subs, event.data, event.deleted)
+				if !match {
+					continue
+				}
+				id := h.position(event.seq).String()
+				msg, err := encodeEvent(id, event, fields)
+				if err != nil {
+					return nil, err
+				}
+				frame := hubFrame{id: id, data: msg}
+				if event.deleted {
+					frame.event = "deleted"
+				}
+				frames = append(frames, frame)
+			}
+			return frames, nil
+		}
+		reset, err := json.Marshal(wsReset{Event: "reset", Seq: last})
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, hubFrame{event: "reset", id: last, data: reset})
+	}
+
+	snapshot := wsSnapshot{Event: "snapshot", Seq: last}
+	for _, station := range slices.Sorted(maps.Keys(h.latest)) {
+		data := h.latest[station]
+		fields, match := projection(client.subs, data, false)
+		if !match {
+			continue
+		}
+		var payload any = data
+		if fields != nil {
+			payload = projectRecord(data, fields)
+		}
+		reading, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Readings = append(snapshot.Readings, reading)
+	}
+	if len(snapshot.Readings) == 0 {
+		return frames, nil
+	}
+	msg, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return append(frames, hubFrame{event: "snapshot", id: last, data: msg}), nil
+}
+
+// position returns the position of the event with sequence number seq in the sequence of this hub
+func (h *WebSocketHubImpl) position(seq int64) position {
+	return position{epoch: h.epoch, seq: seq}
+}
+
+// encodeEvent encodes a broadcast projected to fields, with its position id added as "seq"
+func encodeEvent(id string, event wsEvent, fields []string) ([]byte, error) {
+	var payload any = event.data
+	switch {
+	case event.deleted:
+		payload = wsDeleted{Event: "deleted", Station: event.data.Station, Date: event.data.Date}
+	case fields != nil:
+		payload = projectRecord(event.data, fields)
+	}
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	// every payload is a non-empty object, so the position goes in front of its first key
+	seq := strconv.AppendQuote([]byte(`{"seq":`), id)
+	return append(append(seq, ','), msg[1:]...), nil
+}
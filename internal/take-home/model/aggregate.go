@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// AggregateBucket holds the statistics of all readings in [Start, End)
+// Metrics is keyed by field, then by operation, e.g. Metrics["temperature"]["avg"]
+// a nil value means the bucket had no non-null values for that field
+type AggregateBucket struct {
+	Start   time.Time                      `json:"start"`
+	End     time.Time                      `json:"end"`
+	Count   int64                          `json:"count"`
+	Metrics map[string]map[string]*float64 `json:"metrics"`
+}
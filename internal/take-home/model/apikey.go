@@ -0,0 +1,58 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// scopes an API key can be granted, admin implies every other scope
+const (
+	ScopeIngest = "ingest"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+// APIKey is a credential for the HTTP API, only the SHA-256 hash of its secret is stored
+type APIKey struct {
+	ID     string   `bson:"_id" json:"id"`
+	Name   string   `bson:"name" json:"name"`
+	Hash   string   `bson:"hash" json:"-"`
+	Scopes []string `bson:"scopes" json:"scopes"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	// set when the secret was replaced, the previous secret stops working at that moment
+	RotatedAt *time.Time `bson:"rotatedAt,omitempty" json:"rotatedAt,omitempty"`
+	// nil keys never expire
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the key is neither revoked nor expired at now
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// Allows reports whether the key grants scope
+func (k *APIKey) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// validate the scopes of an API key
+
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeIngest, ScopeRead, ScopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q, expected ingest, read or admin", scope)
+		}
+	}
+	return nil
+}
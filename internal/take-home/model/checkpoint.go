@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// states of an ingestion run
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// IngestCheckpoint tracks the progress of ingesting one file
+// a file is identified by its path and the hash of its content, a changed file starts a new run
+// Offset and Line point just past the last row whose batch was written, a restarted run continues there
+type IngestCheckpoint struct {
+	ID     string `bson:"_id" json:"id"`
+	Path   string `bson:"path" json:"path"`
+	Hash   string `bson:"hash" json:"hash"`
+	Status string `bson:"status" json:"status"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+
+	Offset int64 `bson:"offset" json:"offset"`
+	Line   int   `bson:"line" json:"line"`
+
+	// totals over every run of this file
+	Accepted int `bson:"accepted" json:"accepted"`
+	Rejected int `bson:"rejected" json:"rejected"`
+
+	StartedAt   time.Time  `bson:"startedAt" json:"startedAt"`
+	UpdatedAt   time.Time  `bson:"updatedAt" json:"updatedAt"`
+	CompletedAt *time.Time `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key
+// a record without Completed is a claim by a request still in flight, it expires quickly
+// so a crashed request does not block the key until the full TTL
+type IdempotencyRecord struct {
+	Key string `bson:"_id" json:"key"`
+	// hash of method, path and body, a retry has to send the same request
+	RequestHash string `bson:"requestHash" json:"requestHash"`
+	Completed   bool   `bson:"completed" json:"completed"`
+
+	// the stored response, Headers only holds the headers worth replaying such as Content-Type
+	Status  int               `bson:"status,omitempty" json:"status,omitempty"`
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body    []byte            `bson:"body,omitempty" json:"body,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// Expired reports whether the record no longer counts at now
+func (r *IdempotencyRecord) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
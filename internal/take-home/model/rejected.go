@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// RejectedLine is a row of an ingested file that was not stored, kept in the dead-letter sink for inspection
+type RejectedLine struct {
+	Source     string    `bson:"source" json:"source"`
+	Line       int       `bson:"line" json:"line"`
+	Raw        string    `bson:"raw" json:"raw"`
+	Reason     string    `bson:"reason" json:"reason"`
+	RejectedAt time.Time `bson:"rejectedAt" json:"rejectedAt"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// WeatherRevision records one change of a reading: the values before and after it, when it was ingested and by whom
+type WeatherRevision struct {
+	ID      any       `bson:"_id,omitempty" json:"-"`
+	Station string    `bson:"station" json:"station,omitempty"`
+	Date    time.Time `bson:"date" json:"date"`
+	// storage outcome of the change, e.g. inserted, updated or revised
+	Change string `bson:"change" json:"change"`
+	// values after the change
+	Values map[string]any `bson:"values" json:"values"`
+	// values before the change, nil when the change created the reading
+	Previous   map[string]any `bson:"previous,omitempty" json:"previous,omitempty"`
+	Source     string         `bson:"source,omitempty" json:"source,omitempty"`
+	IngestedAt time.Time      `bson:"ingestedAt" json:"ingestedAt"`
+}
@@ -0,0 +1,250 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ColumnType is the value type of a configured column
+type ColumnType string
+
+const (
+	ColumnDate   ColumnType = "date"
+	ColumnFloat  ColumnType = "float"
+	ColumnInt    ColumnType = "int"
+	ColumnString ColumnType = "string"
+)
+
+// Column describes one column of the weather data as declared in columns.yaml
+// Field is the key used in JSON, BSON and for field projection
+type Column struct {
+	Name        string
+	Field       string
+	Type        ColumnType
+	Unit        string
+	Description string
+	Min         *float64
+	Max         *float64
+	Nullable    bool
+}
+
+// Schema is the ordered set of columns a weather reading consists of
+// exactly one column has the date type and maps to WeatherData.Date,
+// every other column is stored in WeatherData.Values under its field name
+type Schema struct {
+	Columns []Column
+	byField map[string]int
+}
+
+// reserved keys that cannot be used as value fields
+var reservedFields = map[string]bool{"_id": true, "station": true, "date": true, "ingestedBy": true}
+
+func NewSchema(columns []Column) (*Schema, error) {
+	s := &Schema{Columns: columns, byField: make(map[string]int, len(columns))}
+
+	dateColumns := 0
+	for i, col := range columns {
+		switch col.Type {
+		case ColumnDate:
+			dateColumns++
+			if col.Field != "date" {
+				return nil, fmt.Errorf("column %s: the date column must use field \"date\"", col.Name)
+			}
+			if col.Nullable {
+				return nil, fmt.Errorf("column %s: the date column cannot be nullable", col.Name)
+			}
+		case ColumnFloat, ColumnInt, ColumnString:
+			if reservedFields[col.Field] {
+				return nil, fmt.Errorf("column %s: field %q is reserved", col.Name, col.Field)
+			}
+		default:
+			return nil, fmt.Errorf("column %s: unknown type %q", col.Name, col.Type)
+		}
+
+		if col.Field == "" {
+			return nil, fmt.Errorf("column %s: field name is required", col.Name)
+		}
+		if _, exists := s.byField[col.Field]; exists {
+			return nil, fmt.Errorf("column %s: duplicate field %q", col.Name, col.Field)
+		}
+		if col.Min != nil && col.Max != nil && *col.Min > *col.Max {
+			return nil, fmt.Errorf("column %s: min is greater than max", col.Name)
+		}
+		s.byField[col.Field] = i
+	}
+
+	if dateColumns != 1 {
+		return nil, fmt.Errorf("schema needs exactly one date column, found %d", dateColumns)
+	}
+
+	return s, nil
+}
+
+// DefaultSchema mirrors the columns of the original weather.dat layout
+func DefaultSchema() *Schema {
+	s, _ := NewSchema([]Column{
+		{Name: "Date", Field: "date", Type: ColumnDate, Unit: "YYYY-MM-DD", Description: "The date the data was recorded"},
+		{Name: "Temperature", Field: "temperature", Type: ColumnFloat, Unit: "°C", Description: "Ambient temperature", Min: ptr(-100.0), Max: ptr(100.0)},
+		{Name: "Humidity", Field: "humidity", Type: ColumnFloat, Unit: "%", Description: "Relative air humidity", Min: ptr(0.0), Max: ptr(100.0)},
+	})
+	return s
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var activeSchema atomic.Pointer[Schema]
+
+func init() {
+	activeSchema.Store(DefaultSchema())
+}
+
+// SetSchema replaces the schema used for parsing, validation and serialisation
+// it is meant to be called once at startup, before any data is handled
+func SetSchema(s *Schema) {
+	activeSchema.Store(s)
+}
+
+func ActiveSchema() *Schema {
+	return activeSchema.Load()
+}
+
+func (s *Schema) Column(field string) (Column, bool) {
+	i, ok := s.byField[field]
+	if !ok {
+		return Column{}, false
+	}
+	return s.Columns[i], true
+}
+
+// HasField reports whether the field can be projected, including the station metadata field
+func (s *Schema) HasField(field string) bool {
+	_, ok := s.byField[field]
+	return ok || field == "station"
+}
+
+// ValueColumns returns all columns except the date column, in declaration order
+func (s *Schema) ValueColumns() []Column {
+	cols := make([]Column, 0, len(s.Columns))
+	for _, col := range s.Columns {
+		if col.Type != ColumnDate {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// tokens treated as a missing value in text input
+var nullTokens = map[string]bool{"": true, "NA": true, "null": true, "-": true}
+
+// ParseValue converts a raw text value into the column's Go type, nil for a null token
+func (c Column) ParseValue(raw string) (any, error) {
+	if nullTokens[raw] {
+		return nil, nil
+	}
+	switch c.Type {
+	case ColumnFloat:
+		return strconv.ParseFloat(raw, 64)
+	case ColumnInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case ColumnString:
+		return raw, nil
+	}
+	return nil, fmt.Errorf("unsupported column type %q", c.Type)
+}
+
+// Coerce converts a decoded JSON/BSON value into the column's Go type
+func (c Column) Coerce(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case ColumnFloat:
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("expected a number")
+	case ColumnInt:
+		f, ok := toFloat(v)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return int64(f), nil
+	case ColumnString:
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+		return nil, fmt.Errorf("expected a string")
+	}
+	return nil, fmt.Errorf("unsupported column type %q", c.Type)
+}
+
+// Validate checks a reading against the schema: required columns, types and ranges
+func (s *Schema) Validate(w *WeatherData) error {
+	for _, col := range s.Columns {
+		if col.Type == ColumnDate {
+			if w.Date.IsZero() {
+				return fmt.Errorf("%s is required", col.Field)
+			}
+			continue
+		}
+
+		v, present := w.Values[col.Field]
+		if !present || v == nil {
+			if col.Nullable {
+				continue
+			}
+			return fmt.Errorf("%s is required", col.Field)
+		}
+
+		if _, err := col.Coerce(v); err != nil {
+			return fmt.Errorf("%s: %w", col.Field, err)
+		}
+		if f, ok := toFloat(v); ok && (col.Min != nil || col.Max != nil) {
+			if (col.Min != nil && f < *col.Min) || (col.Max != nil && f > *col.Max) {
+				return fmt.Errorf("%s outside valid range (%s)", col.Field, col.rangeText())
+			}
+		}
+	}
+
+	for field := range w.Values {
+		if _, ok := s.byField[field]; !ok {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return nil
+}
+
+func (c Column) rangeText() string {
+	var b strings.Builder
+	if c.Min != nil {
+		b.WriteString(strconv.FormatFloat(*c.Min, 'g', -1, 64))
+	} else {
+		b.WriteString("-inf")
+	}
+	b.WriteString(" to ")
+	if c.Max != nil {
+		b.WriteString(strconv.FormatFloat(*c.Max, 'g', -1, 64))
+	} else {
+		b.WriteString("inf")
+	}
+	return b.String()
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Station describes a sensor that weather readings are attributed to
+type Station struct {
+	ID        string  `bson:"_id" json:"id"`
+	Name      string  `bson:"name" json:"name"`
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+	Elevation float64 `bson:"elevation" json:"elevation"` // metres above sea level
+	Timezone  string  `bson:"timezone" json:"timezone"`   // IANA name, e.g. Europe/Berlin
+}
+
+// validate station metadata
+
+func (s *Station) Validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("station id is required")
+	}
+	if s.Latitude < -90 || s.Latitude > 90 {
+		return fmt.Errorf("latitude outside valid range (-90 to 90)")
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		return fmt.Errorf("longitude outside valid range (-180 to 180)")
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", s.Timezone)
+		}
+	}
+	return nil
+}
@@ -1,27 +1,152 @@
 package model
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 )
 
+// WeatherData is a single reading, its measured columns are defined by the active Schema
+// Values holds every non-date column keyed by field name and is stored inline in BSON
 type WeatherData struct {
-	Date        time.Time `bson:"date" json:"date"`
-	Temperature float64   `bson:"temperature" json:"temperature"`
-	Humidity    float64   `bson:"humidity" json:"humidity"`
+	ID      any            `bson:"_id,omitempty" json:"-"`
+	Station string         `bson:"station" json:"station,omitempty"`
+	Date    time.Time      `bson:"date" json:"date"`
+	Values  map[string]any `bson:",inline" json:"-"`
+	// source of the last write that changed the reading, e.g. key:<id> for a request with an API key
+	// set by the storage backend, a value sent by the client is replaced
+	IngestedBy string `bson:"ingestedBy,omitempty" json:"ingestedBy,omitempty"`
+}
+
+// TruncateToDay returns midnight UTC of the given day, used by feeds that report one reading per day
+func TruncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
 
 // validate weather data input
 
 func (w *WeatherData) Validate() error {
-	if w.Date.IsZero() {
-		return fmt.Errorf("date is required")
+	return ActiveSchema().Validate(w)
+}
+
+// Float returns a numeric column value as float64
+func (w *WeatherData) Float(field string) (float64, bool) {
+	return toFloat(w.Values[field])
+}
+
+// Clone returns a copy that does not share the Values map
+func (w *WeatherData) Clone() *WeatherData {
+	c := *w
+	c.Values = maps.Clone(w.Values)
+	return &c
+}
+
+// MarshalJSON flattens Values next to station and date, in schema column order
+func (w WeatherData) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	if w.Station != "" {
+		buf.WriteString(`"station":`)
+		station, _ := json.Marshal(w.Station)
+		buf.Write(station)
+		buf.WriteByte(',')
+	}
+
+	buf.WriteString(`"date":`)
+	date, err := json.Marshal(w.Date)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(date)
+
+	if w.IngestedBy != "" {
+		buf.WriteString(`,"ingestedBy":`)
+		source, _ := json.Marshal(w.IngestedBy)
+		buf.Write(source)
+	}
+
+	written := make(map[string]bool, len(w.Values))
+	writeField := func(field string, v any) error {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		key, _ := json.Marshal(field)
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(encoded)
+		written[field] = true
+		return nil
+	}
+
+	for _, col := range ActiveSchema().ValueColumns() {
+		if v, ok := w.Values[col.Field]; ok {
+			if err := writeField(col.Field, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// fields outside the schema (e.g. columns removed from the config) are kept, sorted for stable output
+	for _, field := range slices.Sorted(maps.Keys(w.Values)) {
+		if !written[field] {
+			if err := writeField(field, w.Values[field]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON reads station, date, ingestedBy and every schema column, other keys are ignored
+func (w *WeatherData) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*w = WeatherData{}
+	if v, ok := raw["station"]; ok {
+		if err := json.Unmarshal(v, &w.Station); err != nil {
+			return fmt.Errorf("station: %w", err)
+		}
 	}
-	if w.Temperature < -100 || w.Temperature > 100 {
-		return fmt.Errorf("temperature outside valid range (-100 to 100)")
+	if v, ok := raw["date"]; ok {
+		if err := json.Unmarshal(v, &w.Date); err != nil {
+			return fmt.Errorf("date: %w", err)
+		}
 	}
-	if w.Humidity < 0 || w.Humidity > 100 {
-		return fmt.Errorf("humidity outside valid range (0 to 100)")
+	if v, ok := raw["ingestedBy"]; ok {
+		if err := json.Unmarshal(v, &w.IngestedBy); err != nil {
+			return fmt.Errorf("ingestedBy: %w", err)
+		}
 	}
+
+	for _, col := range ActiveSchema().ValueColumns() {
+		v, ok := raw[col.Field]
+		if !ok {
+			continue
+		}
+		var decoded any
+		if err := json.Unmarshal(v, &decoded); err != nil {
+			return fmt.Errorf("%s: %w", col.Field, err)
+		}
+		value, err := col.Coerce(decoded)
+		if err != nil {
+			return fmt.Errorf("%s: %w", col.Field, err)
+		}
+		if w.Values == nil {
+			w.Values = make(map[string]any)
+		}
+		w.Values[col.Field] = value
+	}
+
 	return nil
 }
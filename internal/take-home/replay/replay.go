@@ -0,0 +1,258 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+)
+
+const (
+	ingestPath          = "/api/v1/weather"
+	defaultTimeout      = 10 * time.Second
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Replayer reads rows from a weather file and POSTs them one by one to the ingest endpoint
+// rows are sent as fast as possible, at a fixed rate or spaced like their timestamps
+type Replayer struct {
+	client       *http.Client
+	endpoint     string
+	parser       *service.WeatherParser
+	rate         float64
+	speedup      float64
+	concurrency  int
+	retries      int
+	retryBackoff time.Duration
+	// sent as a bearer token when set, for servers with API key authentication
+	apiKey string
+}
+
+// Option customises a Replayer at construction time
+type Option func(*Replayer)
+
+// WithRate sends rows at a fixed number of rows per second
+func WithRate(rowsPerSecond float64) Option {
+	return func(r *Replayer) {
+		r.rate = rowsPerSecond
+	}
+}
+
+// WithSpeedup spaces rows like their timestamps, compressed by factor
+// a factor of 86400 replays one day of readings per second
+func WithSpeedup(factor float64) Option {
+	return func(r *Replayer) {
+		r.speedup = factor
+	}
+}
+
+// WithConcurrency sets how many requests may be in flight at once
+func WithConcurrency(n int) Option {
+	return func(r *Replayer) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithRetries retries a row up to n times on network errors, 429 and 5xx responses
+func WithRetries(n int) Option {
+	return func(r *Replayer) {
+		if n >= 0 {
+			r.retries = n
+		}
+	}
+}
+
+// WithRetryBackoff sets the wait before the first retry, it doubles with every further attempt
+func WithRetryBackoff(d time.Duration) Option {
+	return func(r *Replayer) {
+		if d > 0 {
+			r.retryBackoff = d
+		}
+	}
+}
+
+// WithAPIKey authenticates every request with key, it needs the ingest scope
+func WithAPIKey(key string) Option {
+	return func(r *Replayer) {
+		r.apiKey = key
+	}
+}
+
+// WithHTTPClient replaces the default client, e.g. to change the request timeout
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Replayer) {
+		r.client = client
+	}
+}
+
+// New creates a Replayer that sends rows to the API at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) *Replayer {
+	r := &Replayer{
+		client:       &http.Client{Timeout: defaultTimeout},
+		endpoint:     strings.TrimSuffix(baseURL, "/") + ingestPath,
+		parser:       service.NewWeatherParser(),
+		concurrency:  1,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Replay sends every valid row of src and reports how it went
+// rows that fail to parse are counted and skipped, a cancelled context stops the replay
+// and returns the report of what was sent so far together with the context error
+func (r *Replayer) Replay(ctx context.Context, src io.Reader) (*Report, error) {
+	if r.rate < 0 || r.speedup < 0 {
+		return nil, fmt.Errorf("rate and speedup must not be negative")
+	}
+	if r.rate > 0 && r.speedup > 0 {
+		return nil, fmt.Errorf("rate and speedup are mutually exclusive")
+	}
+
+	report := newReport()
+	jobs := make(chan *model.WeatherData)
+
+	var wg sync.WaitGroup
+	for range r.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				r.send(ctx, data, report)
+			}
+		}()
+	}
+
+	start := time.Now()
+	var first time.Time
+	sent := 0
+	_, err := r.parser.ScanLines(ctx, src, func(line service.Line) error {
+		if line.Err != nil {
+			report.invalid()
+			return nil
+		}
+		if first.IsZero() {
+			first = line.Data.Date
+		}
+
+		if err := sleepUntil(ctx, r.schedule(start, first, sent, line.Data.Date)); err != nil {
+			return err
+		}
+		select {
+		case jobs <- line.Data:
+			sent++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+
+	report.Elapsed = time.Since(start)
+	if err != nil {
+		return report, fmt.Errorf("replay stopped: %w", err)
+	}
+	return report, nil
+}
+
+// schedule returns when the row with index i and timestamp date is due
+// rows whose timestamp goes back in time are due immediately
+func (r *Replayer) schedule(start, first time.Time, i int, date time.Time) time.Time {
+	switch {
+	case r.rate > 0:
+		return start.Add(time.Duration(float64(i) / r.rate * float64(time.Second)))
+	case r.speedup > 0:
+		return start.Add(time.Duration(float64(date.Sub(first)) / r.speedup))
+	default:
+		return start
+	}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send POSTs a single row, retrying transient failures
+func (r *Replayer) send(ctx context.Context, data *model.WeatherData, report *Report) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		report.failure(0, err)
+		return
+	}
+
+	backoff := r.retryBackoff
+	for attempt := 0; ; attempt++ {
+		status, err := r.post(ctx, body, report)
+		if err == nil && status < http.StatusBadRequest {
+			report.success(status)
+			return
+		}
+		if attempt == r.retries || !retryable(status, err) || ctx.Err() != nil {
+			report.failure(status, err)
+			return
+		}
+
+		report.retry()
+		if sleepUntil(ctx, time.Now().Add(backoff)) != nil {
+			report.failure(status, err)
+			return
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// post sends one attempt and records its latency, status is 0 when no response arrived
+func (r *Replayer) post(ctx context.Context, body []byte, report *Report) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+
+	began := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// drain the body so the connection is reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	report.latency(time.Since(began))
+	return resp.StatusCode, nil
+}
+
+func retryable(status int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
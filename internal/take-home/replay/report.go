@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report summarises a replay, latencies are measured per request attempt
+type Report struct {
+	mu sync.Mutex
+
+	// rows that were sent, succeeded or failed after all retries
+	Sent      int
+	Succeeded int
+	Failed    int
+	// lines that could not be parsed and were never sent
+	Invalid int
+	Retries int
+	// responses by status code, requests that got no response are counted as NetworkErrors
+	StatusCodes   map[int]int
+	NetworkErrors int
+	LastError     string
+	Elapsed       time.Duration
+
+	latencies []time.Duration
+}
+
+// LatencySummary describes the latency distribution of the requests of a replay
+type LatencySummary struct {
+	Min, Mean, P50, P90, P99, Max time.Duration
+}
+
+func newReport() *Report {
+	return &Report{StatusCodes: make(map[int]int)}
+}
+
+func (r *Report) invalid() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Invalid++
+}
+
+func (r *Report) retry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Retries++
+}
+
+func (r *Report) latency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, d)
+}
+
+func (r *Report) success(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sent++
+	r.Succeeded++
+	r.StatusCodes[status]++
+}
+
+func (r *Report) failure(status int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sent++
+	r.Failed++
+	if err != nil {
+		r.NetworkErrors++
+		r.LastError = err.Error()
+		return
+	}
+	r.StatusCodes[status]++
+	r.LastError = fmt.Sprintf("unexpected status %d", status)
+}
+
+// Throughput is the number of rows stored per second
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / r.Elapsed.Seconds()
+}
+
+// Latency summarises the recorded request latencies, all zero when nothing was sent
+func (r *Report) Latency() LatencySummary {
+	r.mu.Lock()
+	sorted := slices.Clone(r.latencies)
+	r.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return LatencySummary{}
+	}
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return LatencySummary{
+		Min:  sorted[0],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// Print writes a human readable report to w
+func (r *Report) Print(w io.Writer) error {
+	latency := r.Latency()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "rows sent\t%d\n", r.Sent)
+	fmt.Fprintf(tw, "succeeded\t%d\n", r.Succeeded)
+	fmt.Fprintf(tw, "failed\t%d\n", r.Failed)
+	fmt.Fprintf(tw, "invalid lines\t%d\n", r.Invalid)
+	fmt.Fprintf(tw, "retries\t%d\n", r.Retries)
+	for _, status := range slices.Sorted(maps.Keys(r.StatusCodes)) {
+		fmt.Fprintf(tw, "status %d\t%d\n", status, r.StatusCodes[status])
+	}
+	if r.NetworkErrors > 0 {
+		fmt.Fprintf(tw, "network errors\t%d\n", r.NetworkErrors)
+	}
+	if r.LastError != "" {
+		fmt.Fprintf(tw, "last error\t%s\n", r.LastError)
+	}
+	fmt.Fprintf(tw, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "throughput\t%.1f rows/s\n", r.Throughput())
+	fmt.Fprintf(tw, "latency min/mean/max\t%s / %s / %s\n", latency.Min, latency.Mean, latency.Max)
+	fmt.Fprintf(tw, "latency p50/p90/p99\t%s / %s / %s\n", latency.P50, latency.P90, latency.P99)
+	return tw.Flush()
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+var (
+	// ErrInvalidAPIKey is returned for unknown, revoked and expired keys alike
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrInvalidAPIKeySpec is wrapped by errors caused by bad scopes, names or expiry times
+	ErrInvalidAPIKeySpec = errors.New("invalid API key specification")
+	ErrAPIKeyRevoked     = errors.New("API key is revoked")
+)
+
+const (
+	// prefix of generated secrets, makes leaked keys easy to spot
+	apiKeySecretPrefix = "wk_"
+	// followed by the key id in the revision history
+	sourceKeyPrefix = "key:"
+)
+
+// APIKeySpec describes a key to create, a nil ExpiresAt never expires
+type APIKeySpec struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type APIKeyServiceInterface interface {
+	// Authenticate returns the active key with the given secret, ErrInvalidAPIKey otherwise
+	Authenticate(ctx context.Context, secret string) (*model.APIKey, error)
+	// CreateKey and RotateKey return the secret of the key, it is not stored and cannot be shown again
+	CreateKey(ctx context.Context, spec APIKeySpec) (*model.APIKey, string, error)
+	RotateKey(ctx context.Context, id string) (*model.APIKey, string, error)
+	RevokeKey(ctx context.Context, id string) (*model.APIKey, error)
+	ListKeys(ctx context.Context) ([]*model.APIKey, error)
+}
+
+type APIKeyService struct {
+	repo storage.APIKeyRepository
+}
+
+func NewAPIKeyService(repo storage.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// APIKeySource names a key as the source of writes, see ContextWithSource
+func APIKeySource(id string) string {
+	return sourceKeyPrefix + id
+}
+
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*model.APIKey, error) {
+	if secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.repo.FindAPIKeyByHash(ctx, hashSecret(secret))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if !key.Active(time.Now()) {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+func (s *APIKeyService) CreateKey(ctx context.Context, spec APIKeySpec) (*model.APIKey, string, error) {
+	if err := model.ValidateScopes(spec.Scopes); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidAPIKeySpec, err)
+	}
+	now := time.Now().UTC()
+	if spec.ExpiresAt != nil && !spec.ExpiresAt.After(now) {
+		return nil, "", fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidAPIKeySpec)
+	}
+
+	id, err := randomToken(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	key := &model.APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      spec.Name,
+		Hash:      hashSecret(secret),
+		Scopes:    spec.Scopes,
+		CreatedAt: now,
+		ExpiresAt: spec.ExpiresAt,
+	}
+	if err := s.repo.SaveAPIKey(ctx, key); err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return key, secret, nil
+}
+
+// RotateKey replaces the secret of a key, the old secret stops working immediately
+func (s *APIKeyService) RotateKey(ctx context.Context, id string) (*model.APIKey, string, error) {
+	key, err := s.repo.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.RevokedAt != nil {
+		return nil, "", ErrAPIKeyRevoked
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	key.Hash = hashSecret(secret)
+	key.RotatedAt = &now
+	if err := s.repo.SaveAPIKey(ctx, key); err != nil {
+		return nil, "", fmt.Errorf("failed to rotate API key: %w", err)
+	}
+	return key, secret, nil
+}
+
+// RevokeKey disables a key for good, revoking it again keeps the first revocation time
+func (s *APIKeyService) RevokeKey(ctx context.Context, id string) (*model.APIKey, error) {
+	key, err := s.repo.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	if err := s.repo.SaveAPIKey(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *APIKeyService) ListKeys(ctx context.Context) ([]*model.APIKey, error) {
+	keys, err := s.repo.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// EnsureKey stores a key with a secret chosen by the operator, e.g. the admin key given at startup
+// a changed secret replaces the stored one and lifts a revocation, scopes are set on every call
+func (s *APIKeyService) EnsureKey(ctx context.Context, id, secret string, scopes []string) error {
+	if err := model.ValidateScopes(scopes); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAPIKeySpec, err)
+	}
+
+	key, err := s.repo.GetAPIKey(ctx, id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		key = &model.APIKey{ID: id, Name: id, CreatedAt: time.Now().UTC()}
+	case err != nil:
+		return err
+	}
+	if hash := hashSecret(secret); key.Hash != hash {
+		key.Hash = hash
+		key.RevokedAt = nil
+	}
+	key.Scopes = scopes
+	if err := s.repo.SaveAPIKey(ctx, key); err != nil {
+		return fmt.Errorf("failed to store API key '%s': %w", id, err)
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	b, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return apiKeySecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func randomToken(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return b, nil
+}
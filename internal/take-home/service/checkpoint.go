@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+// fileRun keeps the checkpoint of one file up to date while it is ingested
+// a nil fileRun (no checkpoint repository configured) ignores every call
+type fileRun struct {
+	repo       storage.CheckpointRepository
+	checkpoint *model.IngestCheckpoint
+	size       int64
+	// totals of earlier runs, the summary only counts the current one
+	baseAccepted, baseRejected int
+}
+
+// beginRun identifies the file by path and content hash and loads or creates its checkpoint
+// the file is left positioned where ingestion has to continue
+func beginRun(ctx context.Context, repo storage.CheckpointRepository, file *os.File, path string, force bool) (*fileRun, error) {
+	if repo == nil {
+		return nil, nil
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	id := absPath + "@" + hash
+
+	checkpoint, err := repo.GetCheckpoint(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if checkpoint == nil || force {
+		checkpoint = &model.IngestCheckpoint{ID: id, Path: absPath, Hash: hash, StartedAt: now}
+	}
+	run := &fileRun{
+		repo:         repo,
+		checkpoint:   checkpoint,
+		size:         size,
+		baseAccepted: checkpoint.Accepted,
+		baseRejected: checkpoint.Rejected,
+	}
+	if checkpoint.Status == model.RunCompleted {
+		return run, nil
+	}
+
+	checkpoint.Status = model.RunRunning
+	checkpoint.Error = ""
+	checkpoint.UpdatedAt = now
+	if err := repo.SaveCheckpoint(ctx, checkpoint); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to checkpoint: %w", err)
+	}
+	return run, nil
+}
+
+// completed reports whether the file was fully ingested by an earlier run
+func (r *fileRun) completed() bool {
+	return r != nil && r.checkpoint.Status == model.RunCompleted
+}
+
+// position is where scanning starts, the start of the file for a fresh run
+func (r *fileRun) position() Position {
+	if r == nil {
+		return Position{}
+	}
+	return Position{Line: r.checkpoint.Line, Offset: r.checkpoint.Offset}
+}
+
+// advance records that every row up to pos has been written or dead-lettered
+func (r *fileRun) advance(ctx context.Context, pos Position, summary *FileSummary) error {
+	if r == nil {
+		return nil
+	}
+	r.checkpoint.Line = pos.Line
+	r.checkpoint.Offset = pos.Offset
+	return r.save(ctx, summary)
+}
+
+// finish marks the run completed or failed, a failed run resumes from its last checkpoint
+func (r *fileRun) finish(ctx context.Context, summary *FileSummary, runErr error) error {
+	if r == nil {
+		return runErr
+	}
+
+	if runErr != nil {
+		r.checkpoint.Status = model.RunFailed
+		r.checkpoint.Error = runErr.Error()
+	} else {
+		now := time.Now().UTC()
+		r.checkpoint.Status = model.RunCompleted
+		r.checkpoint.CompletedAt = &now
+		r.checkpoint.Offset = r.size
+	}
+
+	// the run may have been cancelled, its state is still worth keeping
+	if err := r.save(context.WithoutCancel(ctx), summary); err != nil && runErr == nil {
+		return err
+	}
+	return runErr
+}
+
+func (r *fileRun) save(ctx context.Context, summary *FileSummary) error {
+	r.checkpoint.Accepted = r.baseAccepted + summary.Accepted
+	r.checkpoint.Rejected = r.baseRejected + summary.Rejected
+	r.checkpoint.UpdatedAt = time.Now().UTC()
+	if err := r.repo.SaveCheckpoint(ctx, r.checkpoint); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+// ErrInvalidCursor is returned for continuation tokens that cannot be decoded
+var ErrInvalidCursor = errors.New("invalid pagination token")
+
+// cursorToken is the JSON payload of a continuation token, kept short since it travels in URLs
+type cursorToken struct {
+	Date    time.Time `json:"d"`
+	Station string    `json:"s,omitempty"`
+}
+
+// EncodeCursor returns the opaque continuation token pointing after the given reading
+func EncodeCursor(last *model.WeatherData) string {
+	payload, _ := json.Marshal(cursorToken{Date: last.Date, Station: last.Station})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeCursor(token string) (*storage.Cursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	var c cursorToken
+	if err := json.Unmarshal(payload, &c); err != nil || c.Date.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &storage.Cursor{Date: c.Date, Station: c.Station}, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+// ErrInvalidEdit is wrapped by errors caused by an invalid replacement, patch or delete range
+var ErrInvalidEdit = errors.New("invalid edit")
+
+// ReplaceReading stores data in place of the reading of its station at its timestamp
+// the conflict policy does not apply, operators overwrite readings on purpose
+func (s *IngestService) ReplaceReading(ctx context.Context, data *model.WeatherData) (string, error) {
+	if err := data.Validate(); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidEdit, err)
+	}
+
+	s.normalize(data)
+	outcome, err := s.repo.ReplaceReading(ctx, data, s.writeOptions(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to replace reading: %w", err)
+	}
+	return outcome, nil
+}
+
+// PatchReading changes single columns of a stored reading, nil values remove a column
+// the patched reading is validated as a whole, a missing reading fails with storage.ErrNotFound
+func (s *IngestService) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any) (*model.WeatherData, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidEdit)
+	}
+	target := &model.WeatherData{Station: station, Date: date}
+	s.normalize(target)
+
+	existing, err := s.findReading(ctx, target.Station, target.Date)
+	if err != nil {
+		return nil, err
+	}
+	patched := existing.Clone()
+	if patched.Values == nil {
+		patched.Values = make(map[string]any)
+	}
+	for field, v := range values {
+		if v == nil {
+			delete(patched.Values, field)
+			continue
+		}
+		patched.Values[field] = v
+	}
+	if err := patched.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEdit, err)
+	}
+
+	stored, err := s.repo.PatchReading(ctx, target.Station, target.Date, values, s.writeOptions(ctx))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch reading: %w", err)
+	}
+	return stored, nil
+}
+
+// findReading loads the reading of station at date, storage.ErrNotFound if there is none
+func (s *IngestService) findReading(ctx context.Context, station string, date time.Time) (*model.WeatherData, error) {
+	found, err := s.repo.GetByDateRange(ctx, date, date, &storage.QueryOptions{Station: station})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reading: %w", err)
+	}
+	// an empty station filter matches every station, only the unnamed one is meant
+	for _, data := range found {
+		if data.Station == station {
+			return data, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// DeleteReadings removes the readings in [start, end], of every station when station is empty
+// a dry run returns the readings that would be removed without touching them
+func (s *IngestService) DeleteReadings(ctx context.Context, start, end time.Time, station string, dryRun bool) ([]*model.WeatherData, error) {
+	if err := validateRange(start, end, maxRangeQuery); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEdit, err)
+	}
+
+	if dryRun {
+		data, err := s.repo.GetByDateRange(ctx, start, end, &storage.QueryOptions{Station: station, Sort: storage.DefaultSort()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load readings: %w", err)
+		}
+		return data, nil
+	}
+
+	deleted, err := s.repo.DeleteReadings(ctx, start, end, station, s.writeOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete readings: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"go.uber.org/zap"
+)
+
+// WithEvents announces every change of a reading on pub, whichever write path made it
+// writes that leave the stored reading as it was are not announced, failed publishes are logged to logger
+func WithEvents(pub events.Publisher, logger *zap.Logger) IngestOption {
+	return func(s *IngestService) {
+		if pub == nil {
+			return
+		}
+		if logger == nil {
+			logger = zap.NewNop()
+		}
+		s.repo = &publishingRepository{WeatherRepository: s.repo, pub: pub, logger: logger.Named("events")}
+	}
+}
+
+// publishingRepository publishes the changes of the wrapped repository once they are written
+// a failed publish does not fail the write, the reading is stored already
+type publishingRepository struct {
+	storage.WeatherRepository
+	pub    events.Publisher
+	logger *zap.Logger
+}
+
+func (r *publishingRepository) BulkUpsert(ctx context.Context, data []*model.WeatherData, opts storage.BulkOptions) (*storage.BulkResult, error) {
+	result, err := r.WeatherRepository.BulkUpsert(ctx, data, opts)
+	if err != nil {
+		return result, err
+	}
+	// ordered writes stop at the first error, the records after it were not written
+	written := len(data)
+	failed := make(map[int]struct{}, len(result.Errors))
+	for _, writeErr := range result.Errors {
+		failed[writeErr.Index] = struct{}{}
+		if opts.Ordered && writeErr.Index >= 0 {
+			written = min(written, writeErr.Index)
+		}
+	}
+	changes := make([]events.Event, 0, written)
+	for i, record := range data[:written] {
+		if _, ok := failed[i]; ok || !result.Changed(i) {
+			continue
+		}
+		changes = append(changes, events.Stored(record))
+	}
+	r.publish(ctx, changes...)
+	return result, nil
+}
+
+func (r *publishingRepository) ReplaceReading(ctx context.Context, data *model.WeatherData, opts storage.BulkOptions) (string, error) {
+	outcome, err := r.WeatherRepository.ReplaceReading(ctx, data, opts)
+	if err == nil && outcome != storage.OutcomeUnchanged {
+		r.publish(ctx, events.Stored(data))
+	}
+	return outcome, err
+}
+
+func (r *publishingRepository) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts storage.BulkOptions) (*model.WeatherData, error) {
+	patched, err := r.WeatherRepository.PatchReading(ctx, station, date, values, opts)
+	if err == nil {
+		r.publish(ctx, events.Stored(patched))
+	}
+	return patched, err
+}
+
+func (r *publishingRepository) DeleteReadings(ctx context.Context, start, end time.Time, station string, opts storage.BulkOptions) ([]*model.WeatherData, error) {
+	deleted, err := r.WeatherRepository.DeleteReadings(ctx, start, end, station, opts)
+	if err == nil {
+		changes := make([]events.Event, 0, len(deleted))
+		for _, data := range deleted {
+			changes = append(changes, events.Deleted(data))
+		}
+		r.publish(ctx, changes...)
+	}
+	return deleted, err
+}
+
+func (r *publishingRepository) publish(ctx context.Context, changes ...events.Event) {
+	if len(changes) == 0 {
+		return
+	}
+	// the write is done, a request cancelled meanwhile must not swallow its events
+	if err := r.pub.Publish(context.WithoutCancel(ctx), changes...); err != nil {
+		r.logger.Error("Failed to publish reading changes", zap.Int("changes", len(changes)), zap.Error(err))
+	}
+}
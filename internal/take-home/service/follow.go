@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	defaultFollowInterval = time.Second
+	// bytes read from a followed file at once
+	followReadSize = 64 << 10
+)
+
+// FollowFile ingests a growing file like tail -F: the rows already in the file first, then rows as they are appended
+// a row is only read once its line is terminated, a missing file is waited for,
+// rotation (the path now names another file) and truncation continue at the start of the new content
+// followed files are always ingested leniently, a live feed cannot be rejected as a whole
+// it returns ctx.Err() once ctx is cancelled
+func (s *IngestService) FollowFile(ctx context.Context, filePath string, opts ...*FileOptions) error {
+	fileOpts := &FileOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		fileOpts = opts[0]
+	}
+	interval := fileOpts.PollInterval
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+
+	ctx = ContextWithSource(ctx, SourceFromContext(ctx, sourceFilePrefix+filePath))
+	f := &follower{svc: s, path: filePath}
+	defer f.close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.poll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// follower is the state of one FollowFile call
+type follower struct {
+	svc  *IngestService
+	path string
+
+	file *os.File
+	// position after the last complete line, pending holds the bytes of an unterminated line read past it
+	pos     Position
+	pending []byte
+}
+
+func (f *follower) poll(ctx context.Context) error {
+	if f.file == nil {
+		file, err := os.Open(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		f.file, f.pos, f.pending = file, Position{}, nil
+	}
+
+	// drain the open file first, after a rotation it still holds the last rows of the old file
+	if err := f.readAppended(ctx); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// moved away without a replacement yet, keep reading the old file
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	current, err := f.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	switch {
+	case !os.SameFile(info, current):
+		// a rotated file will not be completed anymore, its last line counts even without a newline
+		if len(f.pending) > 0 {
+			if err := f.ingest(ctx, append(f.pending, '\n')); err != nil {
+				return err
+			}
+		}
+		f.close()
+		return f.poll(ctx)
+	case info.Size() < f.pos.Offset+int64(len(f.pending)):
+		// truncated in place, start over with the new content
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind truncated file: %w", err)
+		}
+		f.pos, f.pending = Position{}, nil
+		return f.readAppended(ctx)
+	}
+	return nil
+}
+
+// readAppended ingests every complete line between the read position and the end of the file
+func (f *follower) readAppended(ctx context.Context) error {
+	buf := make([]byte, followReadSize)
+	for {
+		n, err := f.file.Read(buf)
+		if n > 0 {
+			data := append(f.pending, buf[:n]...)
+			complete := bytes.LastIndexByte(data, '\n') + 1
+			if complete > 0 {
+				if err := f.ingest(ctx, data[:complete]); err != nil {
+					return err
+				}
+			}
+			f.pending = bytes.Clone(data[complete:])
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+}
+
+// ingest writes a chunk of complete lines that starts at f.pos
+func (f *follower) ingest(ctx context.Context, chunk []byte) error {
+	summary := &FileSummary{File: f.path}
+	if err := f.svc.ingestLines(ctx, bytes.NewReader(chunk), f.pos, summary, nil, true); err != nil {
+		return err
+	}
+	f.pos = Position{
+		Line:   f.pos.Line + bytes.Count(chunk, []byte{'\n'}),
+		Offset: f.pos.Offset + int64(len(chunk)),
+	}
+	return nil
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+// ErrHistoryUnavailable is returned by history and as-of queries of a QueryService without revisions
+var ErrHistoryUnavailable = errors.New("revision history is not available")
+
+// WithRevisions enables history and as-of queries on the revision history of repo
+func WithRevisions(repo storage.RevisionRepository) QueryOption {
+	return func(s *QueryService) {
+		s.revisions = repo
+	}
+}
+
+// History returns the recorded changes of readings in [start, end], the changes of a reading oldest first
+func (s *QueryService) History(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error) {
+	if s.revisions == nil {
+		return nil, ErrHistoryUnavailable
+	}
+	if err := validateRange(start, end, maxRangeQuery); err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.revisions.ListRevisions(ctx, start, end, station)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return revisions, nil
+}
+
+// asOf returns the readings in [start, end] as they were stored at opts.AsOf, paginated like a storage query
+// the current readings are rolled back with their history: the first change after AsOf holds the values
+// the reading had at that moment, a reading created after AsOf did not exist yet
+func (s *QueryService) asOf(ctx context.Context, start, end time.Time, opts *QueryOptions) ([]*model.WeatherData, error) {
+	if s.revisions == nil {
+		return nil, ErrHistoryUnavailable
+	}
+	// built before the reads so that an invalid continuation token fails fast
+	page, err := buildMongoQueryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetByDateRange(ctx, start, end, &storage.QueryOptions{Station: opts.Station, Sort: storage.DefaultSort()})
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	revisions, err := s.revisions.ListRevisions(ctx, start, end, opts.Station)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	type readingKey struct {
+		station string
+		millis  int64
+	}
+	readings := make(map[readingKey]*model.WeatherData, len(current))
+	for _, data := range current {
+		readings[readingKey{data.Station, data.Date.UnixMilli()}] = data
+	}
+	rolledBack := make(map[readingKey]bool)
+	for _, revision := range revisions {
+		key := readingKey{revision.Station, revision.Date.UnixMilli()}
+		if rolledBack[key] || !revision.IngestedAt.After(opts.AsOf) {
+			continue
+		}
+		rolledBack[key] = true
+		if revision.Previous == nil {
+			delete(readings, key)
+			continue
+		}
+		readings[key] = &model.WeatherData{Station: revision.Station, Date: revision.Date.UTC(), Values: revision.Previous}
+	}
+
+	snapshot := make([]*model.WeatherData, 0, len(readings))
+	for _, data := range readings {
+		snapshot = append(snapshot, data)
+	}
+	slices.SortFunc(snapshot, compareReadings)
+	return paginate(snapshot, page), nil
+}
+
+// paginate applies the keyset, skip and limit of a storage query to readings sorted by date and station
+func paginate(data []*model.WeatherData, opts *storage.QueryOptions) []*model.WeatherData {
+	if opts.After != nil {
+		after := &model.WeatherData{Date: opts.After.Date, Station: opts.After.Station}
+		i, _ := slices.BinarySearchFunc(data, after, compareReadings)
+		for i < len(data) && compareReadings(data[i], after) <= 0 {
+			i++
+		}
+		data = data[i:]
+	}
+	if opts.Skip != nil {
+		data = data[min(int(*opts.Skip), len(data)):]
+	}
+	if opts.Limit != nil && *opts.Limit > 0 {
+		data = data[:min(int(*opts.Limit), len(data))]
+	}
+	return data
+}
+
+// seqOf turns buffered readings into the iterator of a streamed query
+func seqOf(data []*model.WeatherData) iter.Seq2[*model.WeatherData, error] {
+	return func(yield func(*model.WeatherData, error) bool) {
+		for _, d := range data {
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+}
+
+func compareReadings(a, b *model.WeatherData) int {
+	if c := a.Date.Compare(b.Date); c != 0 {
+		return c
+	}
+	switch {
+	case a.Station < b.Station:
+		return -1
+	case a.Station > b.Station:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+var (
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInUse    = errors.New("a request with this idempotency key is still being processed")
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	// how long a request may hold a key before a retry can take it over
+	defaultIdempotencyLease = time.Minute
+)
+
+type IdempotencyServiceInterface interface {
+	// Begin claims key for a request, a completed earlier response is returned for replay
+	// nil means the caller owns the key and has to Complete or Abandon it
+	Begin(ctx context.Context, key, requestHash string) (*model.IdempotencyRecord, error)
+	// Complete stores the response so that retries replay it until the TTL expires
+	Complete(ctx context.Context, key, requestHash string, status int, headers map[string]string, body []byte) error
+	// Abandon releases a key whose request failed, so a retry is processed again
+	Abandon(ctx context.Context, key string) error
+}
+
+type IdempotencyService struct {
+	repo  storage.IdempotencyRepository
+	ttl   time.Duration
+	lease time.Duration
+}
+
+// IdempotencyOption customises an IdempotencyService at construction time
+type IdempotencyOption func(*IdempotencyService)
+
+// WithIdempotencyTTL sets how long a stored response is replayed
+func WithIdempotencyTTL(d time.Duration) IdempotencyOption {
+	return func(s *IdempotencyService) {
+		if d > 0 {
+			s.ttl = d
+		}
+	}
+}
+
+// WithIdempotencyLease sets how long an unfinished request blocks its key
+func WithIdempotencyLease(d time.Duration) IdempotencyOption {
+	return func(s *IdempotencyService) {
+		if d > 0 {
+			s.lease = d
+		}
+	}
+}
+
+func NewIdempotencyService(repo storage.IdempotencyRepository, opts ...IdempotencyOption) *IdempotencyService {
+	s := &IdempotencyService{
+		repo:  repo,
+		ttl:   defaultIdempotencyTTL,
+		lease: defaultIdempotencyLease,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *IdempotencyService) Begin(ctx context.Context, key, requestHash string) (*model.IdempotencyRecord, error) {
+	now := time.Now().UTC()
+	existing, err := s.repo.ClaimIdempotencyKey(ctx, &model.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.lease),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if !existing.Completed {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return existing, nil
+}
+
+func (s *IdempotencyService) Complete(ctx context.Context, key, requestHash string, status int, headers map[string]string, body []byte) error {
+	now := time.Now().UTC()
+	err := s.repo.SaveIdempotencyKey(ctx, &model.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		Completed:   true,
+		Status:      status,
+		Headers:     headers,
+		Body:        body,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store response: %w", err)
+	}
+	return nil
+}
+
+func (s *IdempotencyService) Abandon(ctx context.Context, key string) error {
+	return s.repo.DeleteIdempotencyKey(ctx, key)
+}
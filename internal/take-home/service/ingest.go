@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
+	"iter"
 	"os"
 	"time"
 
@@ -11,53 +13,385 @@ import (
 )
 
 type IngestServiceInterface interface {
-	IngestFile(ctx context.Context, filePath string) error
-	IngestSingle(ctx context.Context, data *model.WeatherData) error
+	IngestFile(ctx context.Context, filePath string, opts ...*FileOptions) (*FileSummary, error)
+	FollowFile(ctx context.Context, filePath string, opts ...*FileOptions) error
+	// IngestSingle returns the outcome of the write, see storage.OutcomeInserted
+	// readings refused by the reject conflict policy fail with storage.ErrConflict
+	IngestSingle(ctx context.Context, data *model.WeatherData) (string, error)
+	IngestBatch(ctx context.Context, data []*model.WeatherData) (*BatchResult, error)
+	// ReplaceReading, PatchReading and DeleteReadings edit stored readings, invalid edits fail with ErrInvalidEdit
+	ReplaceReading(ctx context.Context, data *model.WeatherData) (string, error)
+	PatchReading(ctx context.Context, station string, date time.Time, values map[string]any) (*model.WeatherData, error)
+	DeleteReadings(ctx context.Context, start, end time.Time, station string, dryRun bool) ([]*model.WeatherData, error)
 }
 
 type QueryServiceInterface interface {
 	GetByDate(ctx context.Context, date time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error)
 	GetByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error)
+	CountByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) (int64, error)
+	StreamByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) (iter.Seq2[*model.WeatherData, error], error)
+	Aggregate(ctx context.Context, start, end time.Time, opts AggregateOptions) ([]*model.AggregateBucket, error)
+	History(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error)
 }
 
+// per-record outcome of a batch ingestion, Index refers to the position in the submitted batch
+// Outcome tells what the conflict policy did with an accepted record, e.g. inserted or kept
+type RecordResult struct {
+	Index   int    `json:"index"`
+	Status  string `json:"status"`
+	Outcome string `json:"outcome,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+const (
+	RecordAccepted = "accepted"
+	RecordRejected = "rejected"
+)
+
+type BatchResult struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []RecordResult `json:"results"`
+}
+
+// FileSummary is the outcome of a file ingestion
+type FileSummary struct {
+	File     string `json:"file"`
+	Parsed   int    `json:"parsed"`   // rows that parsed and passed validation
+	Accepted int    `json:"accepted"` // rows written to storage
+	Rejected int    `json:"rejected"` // rows that failed to parse, validate or write
+	Skipped  int    `json:"skipped"`  // blank and comment lines
+	// the file was completely ingested before, nothing was read
+	AlreadyIngested bool `json:"alreadyIngested,omitempty"`
+	// line after which an interrupted run was resumed
+	ResumedFromLine int `json:"resumedFromLine,omitempty"`
+	// the first rejected rows, every rejected row goes to the dead-letter sink
+	Rejections []*model.RejectedLine `json:"rejections,omitempty"`
+}
+
+// FileOptions adjust a single IngestFile or FollowFile call
+type FileOptions struct {
+	// Force ingests a file again even if a completed run of the same content exists
+	Force bool
+	// PollInterval is how often FollowFile checks for appended rows, one second by default
+	PollInterval time.Duration
+}
+
+// rejected rows kept in a FileSummary, so a thoroughly broken file does not blow up the summary
+const maxSummaryRejections = 100
+
 type IngestService struct {
-	repo   storage.WeatherRepository
-	parser *WeatherParser
+	repo     storage.WeatherRepository
+	parser   *WeatherParser
+	bulkOpts storage.BulkOptions
+	// legacy feeds report one reading per day, keep upserting them at midnight UTC
+	dailyUpsert bool
+	// station assigned to readings that do not name one, e.g. rows of weather.dat
+	defaultStation string
+	// lenient file ingestion skips bad rows instead of aborting, they go to deadLetters when set
+	lenient     bool
+	deadLetters storage.DeadLetterSink
+	// progress of file ingestion, nil disables resuming and skipping completed files
+	checkpoints storage.CheckpointRepository
+	// what happens to readings that are stored already
+	conflict storage.ConflictPolicy
+}
+
+// IngestOption customises an IngestService at construction time
+type IngestOption func(*IngestService)
+
+// WithBulkOptions sets batch size and ordering used for file and batch ingestion
+func WithBulkOptions(opts storage.BulkOptions) IngestOption {
+	return func(s *IngestService) {
+		s.bulkOpts = opts
+	}
+}
+
+// WithDailyUpsert truncates every reading to midnight UTC so a later reading replaces the day
+func WithDailyUpsert(enabled bool) IngestOption {
+	return func(s *IngestService) {
+		s.dailyUpsert = enabled
+	}
+}
+
+// WithDefaultStation attributes readings without a station to the given station id
+func WithDefaultStation(id string) IngestOption {
+	return func(s *IngestService) {
+		s.defaultStation = id
+	}
 }
 
-func NewIngestService(repo storage.WeatherRepository) IngestServiceInterface {
-	return &IngestService{
-		repo:   repo,
-		parser: NewWeatherParser(),
+// WithLenientIngestion keeps ingesting a file past rows that cannot be parsed, validated or written
+// strict ingestion (the default) stops at the first bad row
+func WithLenientIngestion(enabled bool) IngestOption {
+	return func(s *IngestService) {
+		s.lenient = enabled
 	}
 }
 
-func (s *IngestService) IngestFile(ctx context.Context, filePath string) error {
+// WithDeadLetterSink stores the rows rejected by lenient file ingestion
+func WithDeadLetterSink(sink storage.DeadLetterSink) IngestOption {
+	return func(s *IngestService) {
+		s.deadLetters = sink
+	}
+}
+
+// WithCheckpoints persists file ingestion progress, interrupted files resume and completed ones are skipped
+func WithCheckpoints(repo storage.CheckpointRepository) IngestOption {
+	return func(s *IngestService) {
+		s.checkpoints = repo
+	}
+}
+
+// WithConflictPolicy decides per station whether a reading that is stored already is overwritten,
+// kept, refused or archived as a revision, last-write-wins by default
+func WithConflictPolicy(policy storage.ConflictPolicy) IngestOption {
+	return func(s *IngestService) {
+		s.conflict = policy
+	}
+}
+
+func NewIngestService(repo storage.WeatherRepository, opts ...IngestOption) IngestServiceInterface {
+	s := &IngestService{
+		repo:     repo,
+		parser:   NewWeatherParser(),
+		bulkOpts: storage.DefaultBulkOptions(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// IngestFile parses a data file and upserts its rows in bulk
+// in strict mode the first bad row fails the run, rows of earlier batches are already stored by then
+// in lenient mode bad rows are counted, sent to the dead-letter sink and the run continues
+// with checkpoints enabled progress is saved after every batch, see fileRun
+func (s *IngestService) IngestFile(ctx context.Context, filePath string, opts ...*FileOptions) (*FileSummary, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	return s.parser.ParseStream(ctx, file, func(data *model.WeatherData) error {
-		if err := s.repo.InsertWeatherData(ctx, data); err != nil {
-			return fmt.Errorf("failed to insert data: %w", err)
+	fileOpts := &FileOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		fileOpts = opts[0]
+	}
+
+	summary := &FileSummary{File: filePath}
+	run, err := beginRun(ctx, s.checkpoints, file, filePath, fileOpts.Force)
+	if err != nil {
+		return nil, err
+	}
+	if run.completed() {
+		summary.AlreadyIngested = true
+		return summary, nil
+	}
+	from := run.position()
+	summary.ResumedFromLine = from.Line
+
+	ctx = ContextWithSource(ctx, SourceFromContext(ctx, sourceFilePrefix+filePath))
+	err = s.ingestLines(ctx, file, from, summary, run, s.lenient)
+	return summary, run.finish(ctx, summary, err)
+}
+
+// ingestLines parses the rows of r, which starts at position from, and upserts them in batches
+// counts are added to summary, run (if any) is advanced after every batch
+func (s *IngestService) ingestLines(
+	ctx context.Context,
+	r io.Reader,
+	from Position,
+	summary *FileSummary,
+	run *fileRun,
+	lenient bool,
+) error {
+	batchSize := s.bulkOpts.BatchSize
+	if batchSize <= 0 {
+		batchSize = storage.DefaultBulkOptions().BatchSize
+	}
+
+	var deadLetters []*model.RejectedLine
+	reject := func(line Line, reason string) {
+		rejected := &model.RejectedLine{
+			Source:     summary.File,
+			Line:       line.Number,
+			Raw:        line.Raw,
+			Reason:     reason,
+			RejectedAt: time.Now().UTC(),
+		}
+		summary.Rejected++
+		if len(summary.Rejections) < maxSummaryRejections {
+			summary.Rejections = append(summary.Rejections, rejected)
+		}
+		deadLetters = append(deadLetters, rejected)
+	}
+
+	// buffer parsed records and flush them in bulk instead of one round trip per line
+	batch := make([]*model.WeatherData, 0, batchSize)
+	batchLines := make([]Line, 0, batchSize)
+	// last row handed over by the parser, everything up to it is stored once flush returns
+	pos := from
+	flush := func() error {
+		if len(batch) > 0 {
+			result, err := s.repo.BulkUpsert(ctx, batch, s.writeOptions(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to insert data: %w", err)
+			}
+			if len(result.Errors) > 0 && !lenient {
+				first := result.Errors[0]
+				return fmt.Errorf("failed to insert %d records, first error for %s: %s",
+					len(result.Errors), batch[first.Index].Date.Format("2006-01-02"), first.Message)
+			}
+
+			failed := make(map[int]bool, len(result.Errors))
+			for _, writeErr := range result.Errors {
+				reject(batchLines[writeErr.Index], writeErr.Message)
+				failed[writeErr.Index] = true
+			}
+			// ordered writes stop at the failing record, the rest of the batch was never sent
+			if s.bulkOpts.Ordered && len(result.Errors) > 0 {
+				for i := result.Errors[0].Index + 1; i < len(batch); i++ {
+					reject(batchLines[i], "not written, an earlier record of the batch failed")
+					failed[i] = true
+				}
+			}
+			summary.Accepted += len(batch) - len(failed)
+			batch, batchLines = batch[:0], batchLines[:0]
+		}
+
+		if s.deadLetters != nil && len(deadLetters) > 0 {
+			if err := s.deadLetters.WriteDeadLetters(ctx, deadLetters); err != nil {
+				return fmt.Errorf("failed to write dead letters: %w", err)
+			}
+		}
+		deadLetters = deadLetters[:0]
+		return run.advance(ctx, pos, summary)
+	}
+
+	skipped, err := s.parser.ScanLinesFrom(ctx, r, from, func(line Line) error {
+		pos = Position{Line: line.Number, Offset: line.Offset}
+		if line.Err != nil {
+			if !lenient {
+				return fmt.Errorf("line %d: %w", line.Number, line.Err)
+			}
+			reject(line, line.Err.Error())
+			return nil
+		}
+
+		summary.Parsed++
+		s.normalize(line.Data)
+		batch = append(batch, line.Data)
+		batchLines = append(batchLines, line)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("handler error line %d: %w", line.Number, err)
+			}
 		}
 		return nil
 	})
+	summary.Skipped += skipped
+	if err != nil {
+		return err
+	}
+	return flush()
 }
 
-func (s *IngestService) IngestSingle(ctx context.Context, data *model.WeatherData) error {
+func (s *IngestService) IngestSingle(ctx context.Context, data *model.WeatherData) (string, error) {
 	if err := data.Validate(); err != nil {
-		return fmt.Errorf("invalid data: %w", err)
+		return "", fmt.Errorf("invalid data: %w", err)
+	}
+
+	s.normalize(data)
+	result, err := s.repo.BulkUpsert(ctx, []*model.WeatherData{data}, s.writeOptions(ctx))
+	if err != nil {
+		return "", err
+	}
+	if len(result.Errors) > 0 {
+		writeErr := result.Errors[0]
+		if writeErr.Conflict {
+			return storage.OutcomeConflict, fmt.Errorf("%w: %s", storage.ErrConflict, writeErr.Message)
+		}
+		return "", fmt.Errorf("failed to insert data: %s", writeErr.Message)
+	}
+	return result.Outcome(0), nil
+}
+
+// writeOptions are the bulk options with the conflict policy and the source of ctx applied
+func (s *IngestService) writeOptions(ctx context.Context) storage.BulkOptions {
+	opts := s.bulkOpts
+	opts.Conflict = s.conflict
+	opts.Source = SourceFromContext(ctx, SourceAPI)
+	return opts
+}
+
+// normalize fills in the default station and stores timestamps in UTC,
+// truncated to the day when daily upserts are enabled
+func (s *IngestService) normalize(data *model.WeatherData) {
+	if data.Station == "" {
+		data.Station = s.defaultStation
+	}
+	if s.dailyUpsert {
+		data.Date = model.TruncateToDay(data.Date)
+		return
+	}
+	data.Date = data.Date.UTC()
+}
+
+// IngestBatch validates every record and writes the valid ones with a single bulk call
+// invalid records and per-record write errors are reported back instead of failing the whole batch
+func (s *IngestService) IngestBatch(ctx context.Context, data []*model.WeatherData) (*BatchResult, error) {
+	result := &BatchResult{Results: make([]RecordResult, len(data))}
+
+	valid := make([]*model.WeatherData, 0, len(data))
+	positions := make([]int, 0, len(data)) // maps index in valid back to index in data
+	for i, record := range data {
+		result.Results[i] = RecordResult{Index: i, Status: RecordAccepted}
+		if record == nil {
+			result.Results[i] = RecordResult{Index: i, Status: RecordRejected, Reason: "record is empty"}
+			continue
+		}
+		if err := record.Validate(); err != nil {
+			result.Results[i] = RecordResult{Index: i, Status: RecordRejected, Reason: err.Error()}
+			continue
+		}
+		s.normalize(record)
+		valid = append(valid, record)
+		positions = append(positions, i)
+	}
+
+	if len(valid) > 0 {
+		// batch requests always run unordered so every record gets its own verdict
+		opts := s.writeOptions(ctx)
+		opts.Ordered = false
+		bulkResult, err := s.repo.BulkUpsert(ctx, valid, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert batch: %w", err)
+		}
+		for _, writeErr := range bulkResult.Errors {
+			if writeErr.Index < 0 || writeErr.Index >= len(positions) {
+				continue
+			}
+			i := positions[writeErr.Index]
+			result.Results[i] = RecordResult{Index: i, Status: RecordRejected, Reason: writeErr.Message}
+			if writeErr.Conflict {
+				result.Results[i].Outcome = storage.OutcomeConflict
+			}
+		}
+		for j, i := range positions {
+			if result.Results[i].Status == RecordAccepted {
+				result.Results[i].Outcome = bulkResult.Outcome(j)
+			}
+		}
+	}
+
+	for _, r := range result.Results {
+		if r.Status == RecordAccepted {
+			result.Accepted++
+		} else {
+			result.Rejected++
+		}
 	}
 
-	data.Date = time.Date(
-		data.Date.Year(),
-		data.Date.Month(),
-		data.Date.Day(),
-		0, 0, 0, 0,
-		time.UTC,
-	)
-	return s.repo.InsertWeatherData(ctx, data)
+	return result, nil
 }
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
 	"time"
 
@@ -18,60 +17,113 @@ func NewWeatherParser() *WeatherParser {
 	return &WeatherParser{}
 }
 
+// ParseStream stops at the first line that cannot be parsed or fails validation
 func (p *WeatherParser) ParseStream(ctx context.Context, r io.Reader, handler func(data *model.WeatherData) error) error {
+	_, err := p.ScanLines(ctx, r, func(line Line) error {
+		if line.Err != nil {
+			return fmt.Errorf("line %d: %w", line.Number, line.Err)
+		}
+		if err := handler(line.Data); err != nil {
+			return fmt.Errorf("handler error line %d: %w", line.Number, err)
+		}
+		return nil
+	})
+	return err
+}
+
+// Line is a data row of an input file, Err is set when it could not be parsed or failed validation
+// Offset is the byte offset just past the row, where a resumed scan continues
+type Line struct {
+	Number int
+	Offset int64
+	Raw    string
+	Data   *model.WeatherData
+	Err    error
+}
+
+// Position is a place in an input file, the zero value is its start
+type Position struct {
+	Line   int
+	Offset int64
+}
+
+// ScanLines hands every data row to handler, including rows that failed to parse,
+// so callers decide whether a bad row aborts the run
+// blank and comment lines are not passed on, their count is returned as skipped
+func (p *WeatherParser) ScanLines(ctx context.Context, r io.Reader, handler func(line Line) error) (skipped int, err error) {
+	return p.ScanLinesFrom(ctx, r, Position{}, handler)
+}
+
+// ScanLinesFrom scans a reader that is already positioned at from, line numbers and offsets continue from there
+func (p *WeatherParser) ScanLinesFrom(ctx context.Context, r io.Reader, from Position, handler func(line Line) error) (skipped int, err error) {
 	scanner := bufio.NewScanner(r)
-	lineNum := 0
+	lineNum := from.Line
+
+	// track the bytes consumed so every row knows where the next one starts
+	offset := from.Offset
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		offset += int64(advance)
+		return advance, token, err
+	})
 
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return skipped, ctx.Err()
 		default:
 			lineNum++
 			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
+			// '#' lines are comments, e.g. the header of a weather.dat export
+			if line == "" || strings.HasPrefix(line, "#") {
+				skipped++
 				continue
 			}
 
 			data, err := p.parseLine(line)
-			if err != nil {
-				return fmt.Errorf("line %d: %w", lineNum, err)
-			}
-
-			if err := handler(data); err != nil {
-				return fmt.Errorf("handler error line %d: %w", lineNum, err)
+			if err := handler(Line{Number: lineNum, Offset: offset, Raw: scanner.Text(), Data: data, Err: err}); err != nil {
+				return skipped, err
 			}
 		}
 	}
 
-	return scanner.Err()
+	return skipped, scanner.Err()
 }
 
+// parseLine maps the columns of a row onto the active schema, in the order declared in columns.yaml
 func (p *WeatherParser) parseLine(line string) (*model.WeatherData, error) {
-	parts := strings.Fields(line)
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid format, expected 3 columns")
+	schema := model.ActiveSchema()
+
+	// tab-separated rows may contain empty (null) cells, otherwise split on any whitespace
+	var parts []string
+	if strings.Contains(line, "\t") {
+		parts = strings.Split(line, "\t")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+	} else {
+		parts = strings.Fields(line)
 	}
-
-	date, err := time.Parse("2006-01-02", parts[0])
-	if err != nil {
-		return nil, fmt.Errorf("invalid date: %w", err)
+	if len(parts) != len(schema.Columns) {
+		return nil, fmt.Errorf("invalid format, expected %d columns", len(schema.Columns))
 	}
 
-	temp, err := strconv.ParseFloat(parts[1], 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid temperature: %w", err)
-	}
-
-	humidity, err := strconv.ParseFloat(parts[2], 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid humidity: %w", err)
-	}
+	data := &model.WeatherData{Values: make(map[string]any, len(parts)-1)}
+	for i, col := range schema.Columns {
+		if col.Type == model.ColumnDate {
+			date, err := parseTimestamp(parts[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", col.Field, err)
+			}
+			data.Date = date
+			continue
+		}
 
-	data := &model.WeatherData{
-		Date:        date,
-		Temperature: temp,
-		Humidity:    humidity,
+		value, err := col.ParseValue(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", col.Field, err)
+		}
+		data.Values[col.Field] = value
 	}
 
 	if err := data.Validate(); err != nil {
@@ -80,3 +132,11 @@ func (p *WeatherParser) parseLine(line string) (*model.WeatherData, error) {
 
 	return data, nil
 }
+
+// accept plain dates from daily feeds as well as full RFC 3339 timestamps from higher frequency feeds
+func parseTimestamp(value string) (time.Time, error) {
+	if len(value) == len("2006-01-02") {
+		return time.Parse("2006-01-02", value)
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
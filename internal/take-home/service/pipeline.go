@@ -0,0 +1,582 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// backpressure modes of a full ingest queue
+const (
+	BackpressureBlock  = "block"  // wait for room, bounded by the request context
+	BackpressureReject = "reject" // fail with ErrQueueFull, the API answers 503
+	BackpressureSpill  = "spill"  // append to a spill file that is fed back once the queue drains
+)
+
+// status of an enqueued reading
+const (
+	TicketQueued  = "queued"
+	TicketSpilled = "spilled"
+	TicketStored  = "stored"
+	TicketFailed  = "failed"
+)
+
+var (
+	ErrInvalidData    = errors.New("invalid data")
+	ErrQueueFull      = errors.New("ingest queue is full")
+	ErrPipelineClosed = errors.New("ingest pipeline is shutting down")
+)
+
+const (
+	defaultQueueSize = 10000
+	defaultWorkers   = 4
+	defaultLinger    = 50 * time.Millisecond
+	// completed tickets kept for status lookups, older ones are forgotten
+	maxCompletedTickets = 100000
+	// attempts of a micro-batch whose write failed as a whole, e.g. while the database is unreachable
+	writeAttempts = 3
+	retryBackoff  = 200 * time.Millisecond
+)
+
+type IngestPipelineInterface interface {
+	// Enqueue validates a reading and queues it for writing, the ticket tracks its progress
+	Enqueue(ctx context.Context, data *model.WeatherData) (*IngestTicket, error)
+	Ticket(id string) (*IngestTicket, bool)
+	Stats() PipelineStats
+}
+
+// IngestTicket tracks a reading from the queue to storage
+type IngestTicket struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Outcome     string     `json:"outcome,omitempty"` // what the conflict policy did, see storage.OutcomeInserted
+	Error       string     `json:"error,omitempty"`
+	Station     string     `json:"station,omitempty"`
+	Date        time.Time  `json:"date"`
+	EnqueuedAt  time.Time  `json:"enqueuedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// PipelineStats is a snapshot of the queue and its workers
+type PipelineStats struct {
+	Backpressure  string         `json:"backpressure"`
+	QueueDepth    int            `json:"queueDepth"`
+	QueueCapacity int            `json:"queueCapacity"`
+	Spilled       int            `json:"spilled"` // readings waiting in the spill file
+	Enqueued      int64          `json:"enqueued"`
+	Stored        int64          `json:"stored"`
+	Failed        int64          `json:"failed"`
+	Rejected      int64          `json:"rejected"` // turned away by a full queue
+	LastError     string         `json:"lastError,omitempty"`
+	Workers       []WorkerStatus `json:"workers"`
+}
+
+// WorkerStatus describes what a worker is doing, Batch is the size of the micro-batch being written
+type WorkerStatus struct {
+	ID    int       `json:"id"`
+	State string    `json:"state"` // idle or writing
+	Batch int       `json:"batch,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// pipelineJob is a queued reading and the ticket it reports to
+// spilled jobs carry what is needed to track their ticket again once they are fed back
+type pipelineJob struct {
+	TicketID   string             `json:"ticket"`
+	Data       *model.WeatherData `json:"data"`
+	EnqueuedAt time.Time          `json:"enqueuedAt"`
+	// writer of the reading, see ContextWithSource
+	Source string `json:"source,omitempty"`
+}
+
+// ticket returns a new ticket of the job in status
+func (j *pipelineJob) ticket(status string) *IngestTicket {
+	return &IngestTicket{
+		ID:         j.TicketID,
+		Status:     status,
+		Station:    j.Data.Station,
+		Date:       j.Data.Date,
+		EnqueuedAt: j.EnqueuedAt,
+	}
+}
+
+// IngestPipeline decouples ingestion requests from storage writes
+// readings wait in a bounded queue and a pool of workers writes them in micro-batches through IngestBatch
+type IngestPipeline struct {
+	ingestSvc    IngestServiceInterface
+	queue        chan *pipelineJob
+	workers      int
+	batchSize    int
+	linger       time.Duration
+	backpressure string
+	spillPath    string
+
+	spill *spillFile
+
+	// closed stops Enqueue, enqueuing tracks the calls that got past the check
+	mu        sync.RWMutex
+	closed    bool
+	enqueuing sync.WaitGroup
+	stop      chan struct{}
+
+	// tickets of queued and completed readings, spilled ones are looked up in the spill file until they are fed back
+	ticketsMu sync.Mutex
+	tickets   map[string]*IngestTicket
+	completed []string
+
+	statsMu      sync.Mutex
+	workerStates []WorkerStatus
+	lastError    string
+	enqueued     atomic.Int64
+	stored       atomic.Int64
+	failed       atomic.Int64
+	rejected     atomic.Int64
+}
+
+// PipelineOption customises an IngestPipeline at construction time
+type PipelineOption func(*IngestPipeline)
+
+// WithQueueSize bounds the number of readings waiting in memory
+func WithQueueSize(n int) PipelineOption {
+	return func(p *IngestPipeline) {
+		if n > 0 {
+			p.queue = make(chan *pipelineJob, n)
+		}
+	}
+}
+
+// WithWorkers sets how many micro-batches are written concurrently
+func WithWorkers(n int) PipelineOption {
+	return func(p *IngestPipeline) {
+		if n > 0 {
+			p.workers = n
+		}
+	}
+}
+
+// WithMicroBatchSize caps the readings written by a single repository call
+func WithMicroBatchSize(n int) PipelineOption {
+	return func(p *IngestPipeline) {
+		if n > 0 {
+			p.batchSize = n
+		}
+	}
+}
+
+// WithLinger is how long a worker waits for more readings before writing a partial micro-batch
+func WithLinger(d time.Duration) PipelineOption {
+	return func(p *IngestPipeline) {
+		if d > 0 {
+			p.linger = d
+		}
+	}
+}
+
+// WithBackpressure selects what Enqueue does when the queue is full, spill needs WithSpillFile
+func WithBackpressure(mode string) PipelineOption {
+	return func(p *IngestPipeline) {
+		p.backpressure = mode
+	}
+}
+
+// WithSpillFile is where readings go when the queue is full in spill mode
+// readings left in it by an earlier process are ingested on start
+func WithSpillFile(path string) PipelineOption {
+	return func(p *IngestPipeline) {
+		p.spillPath = path
+	}
+}
+
+// NewIngestPipeline creates a pipeline writing through ingestSvc, Run starts its workers
+func NewIngestPipeline(ingestSvc IngestServiceInterface, opts ...PipelineOption) (*IngestPipeline, error) {
+	p := &IngestPipeline{
+		ingestSvc:    ingestSvc,
+		queue:        make(chan *pipelineJob, defaultQueueSize),
+		workers:      defaultWorkers,
+		batchSize:    500,
+		linger:       defaultLinger,
+		backpressure: BackpressureBlock,
+		stop:         make(chan struct{}),
+		tickets:      make(map[string]*IngestTicket),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	switch p.backpressure {
+	case BackpressureBlock, BackpressureReject:
+	case BackpressureSpill:
+		if p.spillPath == "" {
+			return nil, fmt.Errorf("spill backpressure needs a spill file")
+		}
+		spill, err := openSpillFile(p.spillPath)
+		if err != nil {
+			return nil, err
+		}
+		p.spill = spill
+	default:
+		return nil, fmt.Errorf("unknown backpressure mode %q", p.backpressure)
+	}
+
+	now := time.Now().UTC()
+	p.workerStates = make([]WorkerStatus, p.workers)
+	for i := range p.workerStates {
+		p.workerStates[i] = WorkerStatus{ID: i, State: "idle", Since: now}
+	}
+	return p, nil
+}
+
+// Run writes queued readings until ctx is cancelled
+// it then stops accepting readings, writes what is left in the queue and returns,
+// readings still in the spill file stay there for the next start
+func (p *IngestPipeline) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := range p.workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			p.work(ctx, i)
+		}()
+	}
+
+	var refill sync.WaitGroup
+	if p.spill != nil {
+		refill.Add(1)
+		go func() {
+			defer refill.Done()
+			p.refill()
+		}()
+	}
+
+	<-ctx.Done()
+
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	close(p.stop)
+
+	// nothing is sent to the queue once the refill loop and pending Enqueue calls are done
+	refill.Wait()
+	p.enqueuing.Wait()
+	close(p.queue)
+	workers.Wait()
+
+	if p.spill != nil {
+		p.spill.close()
+	}
+}
+
+func (p *IngestPipeline) Enqueue(ctx context.Context, data *model.WeatherData) (*IngestTicket, error) {
+	if err := data.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidData, err)
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return nil, ErrPipelineClosed
+	}
+	p.enqueuing.Add(1)
+	p.mu.RUnlock()
+	defer p.enqueuing.Done()
+
+	job := &pipelineJob{TicketID: newTicketID(), Data: data, EnqueuedAt: time.Now().UTC(), Source: SourceFromContext(ctx, "")}
+	ticket := job.ticket(TicketQueued)
+	p.track(ticket)
+
+	if err := p.push(ctx, job, ticket); err != nil {
+		p.forget(ticket.ID)
+		if errors.Is(err, ErrQueueFull) {
+			p.rejected.Add(1)
+		}
+		return nil, err
+	}
+	p.enqueued.Add(1)
+	return p.copyTicket(ticket), nil
+}
+
+// push hands a job to the queue according to the backpressure mode
+func (p *IngestPipeline) push(ctx context.Context, job *pipelineJob, ticket *IngestTicket) error {
+	switch p.backpressure {
+	case BackpressureReject:
+		select {
+		case p.queue <- job:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+
+	case BackpressureSpill:
+		// once anything is spilled new readings queue up behind it, so they are written in order
+		if p.spill.pending() == 0 {
+			select {
+			case p.queue <- job:
+				return nil
+			default:
+			}
+		}
+		if err := p.spill.write(job); err != nil {
+			return fmt.Errorf("failed to spill reading: %w", err)
+		}
+		// the spill file holds the ticket until the reading is fed back, so a long outage does not pile up tickets in memory
+		p.forget(ticket.ID)
+		ticket.Status = TicketSpilled
+		return nil
+
+	default:
+		select {
+		case p.queue <- job:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.stop:
+			return ErrPipelineClosed
+		}
+	}
+}
+
+// refill feeds spilled readings back into the queue as it drains
+func (p *IngestPipeline) refill() {
+	ticker := time.NewTicker(p.linger)
+	defer ticker.Stop()
+
+	for {
+		for {
+			job, err := p.spill.next()
+			if err != nil {
+				p.setLastError(fmt.Errorf("failed to read spill file: %w", err))
+				break
+			}
+			if job == nil {
+				break
+			}
+			p.track(job.ticket(TicketQueued))
+
+			select {
+			case p.queue <- job:
+				p.spill.ack()
+			case <-p.stop:
+				// not acknowledged, the reading is read again on the next start
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// work collects micro-batches from the queue until it is closed, ctx ends the retries of failed writes
+func (p *IngestPipeline) work(ctx context.Context, id int) {
+	for first := range p.queue {
+		batch := []*pipelineJob{first}
+		linger := time.NewTimer(p.linger)
+	collect:
+		for len(batch) < p.batchSize {
+			select {
+			case job, ok := <-p.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, job)
+			case <-linger.C:
+				break collect
+			}
+		}
+		linger.Stop()
+
+		p.setWorkerState(id, "writing", len(batch))
+		p.write(ctx, batch)
+		p.setWorkerState(id, "idle", 0)
+	}
+}
+
+// write stores a micro-batch, consecutive readings of the same source are written together
+// so that the revision history names the writer of each of them
+func (p *IngestPipeline) write(ctx context.Context, batch []*pipelineJob) {
+	for len(batch) > 0 {
+		n := 1
+		for n < len(batch) && batch[n].Source == batch[0].Source {
+			n++
+		}
+		p.writeSource(ContextWithSource(ctx, batch[0].Source), batch[:n])
+		batch = batch[n:]
+	}
+}
+
+// writeSource stores readings of one source, retrying with backoff when the write fails as a whole
+// writes outlive ctx so the queue can be drained on shutdown, once ctx is done a failed write is not retried
+func (p *IngestPipeline) writeSource(ctx context.Context, batch []*pipelineJob) {
+	records := make([]*model.WeatherData, len(batch))
+	for i, job := range batch {
+		records[i] = job.Data
+	}
+
+	var result *BatchResult
+	var err error
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err = p.ingestSvc.IngestBatch(context.WithoutCancel(ctx), records)
+		if err == nil || attempt == writeAttempts || !wait(ctx, backoff) {
+			break
+		}
+		backoff *= 2
+	}
+
+	if err != nil {
+		p.setLastError(err)
+		for _, job := range batch {
+			p.complete(job.TicketID, "", err.Error())
+		}
+		return
+	}
+
+	for i, job := range batch {
+		var res RecordResult
+		if i < len(result.Results) {
+			res = result.Results[i]
+		}
+		reason := ""
+		if res.Status == RecordRejected {
+			reason = res.Reason
+			p.setLastError(errors.New(reason))
+		}
+		p.complete(job.TicketID, res.Outcome, reason)
+	}
+}
+
+// wait sleeps for d, false if ctx is done first
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Ticket returns a copy of a tracked ticket, spilled readings are looked up in the spill file
+func (p *IngestPipeline) Ticket(id string) (*IngestTicket, bool) {
+	if ticket, ok := p.trackedTicket(id); ok {
+		return ticket, true
+	}
+	if p.spill == nil {
+		return nil, false
+	}
+	if job, ok := p.spill.lookup(id); ok {
+		return job.ticket(TicketSpilled), true
+	}
+	// refill tracks a reading before the spill file lets go of it, so one fed back in between is tracked by now
+	return p.trackedTicket(id)
+}
+
+func (p *IngestPipeline) trackedTicket(id string) (*IngestTicket, bool) {
+	p.ticketsMu.Lock()
+	defer p.ticketsMu.Unlock()
+	ticket, ok := p.tickets[id]
+	if !ok {
+		return nil, false
+	}
+	found := *ticket
+	return &found, true
+}
+
+func (p *IngestPipeline) Stats() PipelineStats {
+	stats := PipelineStats{
+		Backpressure:  p.backpressure,
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		Enqueued:      p.enqueued.Load(),
+		Stored:        p.stored.Load(),
+		Failed:        p.failed.Load(),
+		Rejected:      p.rejected.Load(),
+	}
+	if p.spill != nil {
+		stats.Spilled = p.spill.pending()
+	}
+
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	stats.LastError = p.lastError
+	stats.Workers = append([]WorkerStatus(nil), p.workerStates...)
+	return stats
+}
+
+func (p *IngestPipeline) track(ticket *IngestTicket) {
+	p.ticketsMu.Lock()
+	defer p.ticketsMu.Unlock()
+	p.tickets[ticket.ID] = ticket
+}
+
+func (p *IngestPipeline) forget(id string) {
+	p.ticketsMu.Lock()
+	defer p.ticketsMu.Unlock()
+	delete(p.tickets, id)
+}
+
+func (p *IngestPipeline) copyTicket(ticket *IngestTicket) *IngestTicket {
+	p.ticketsMu.Lock()
+	defer p.ticketsMu.Unlock()
+	c := *ticket
+	return &c
+}
+
+// complete marks a ticket stored, or failed when reason is set, and forgets the oldest completed tickets
+func (p *IngestPipeline) complete(id, outcome, reason string) {
+	if reason == "" {
+		p.stored.Add(1)
+	} else {
+		p.failed.Add(1)
+	}
+
+	p.ticketsMu.Lock()
+	defer p.ticketsMu.Unlock()
+	ticket, ok := p.tickets[id]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	ticket.CompletedAt = &now
+	ticket.Outcome = outcome
+	ticket.Status = TicketStored
+	if reason != "" {
+		ticket.Status = TicketFailed
+		ticket.Error = reason
+	}
+
+	p.completed = append(p.completed, id)
+	if len(p.completed) > maxCompletedTickets {
+		delete(p.tickets, p.completed[0])
+		p.completed = p.completed[1:]
+	}
+}
+
+func (p *IngestPipeline) setWorkerState(id int, state string, batch int) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.workerStates[id] = WorkerStatus{ID: id, State: state, Batch: batch, Since: time.Now().UTC()}
+}
+
+func (p *IngestPipeline) setLastError(err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.lastError = err.Error()
+}
+
+func newTicketID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -11,21 +14,54 @@ import (
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
 )
 
+// maximum range of buffered range queries, streamed queries have their own limit
+const maxRangeQuery = 365 * 24 * time.Hour
+
 type QueryService struct {
 	repo storage.WeatherRepository
+	// history of changes, nil disables history and as-of queries
+	revisions storage.RevisionRepository
+	// upper bound for streamed range queries, zero means unlimited
+	maxStreamRange time.Duration
+}
+
+// QueryOption customises a QueryService at construction time
+type QueryOption func(*QueryService)
+
+// WithMaxStreamRange limits the date range of streamed queries, zero lifts the limit
+func WithMaxStreamRange(d time.Duration) QueryOption {
+	return func(s *QueryService) {
+		s.maxStreamRange = d
+	}
 }
 
-func NewQueryService(repo storage.WeatherRepository) *QueryService {
-	return &QueryService{repo: repo}
+func NewQueryService(repo storage.WeatherRepository, opts ...QueryOption) *QueryService {
+	s := &QueryService{repo: repo}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 type QueryOptions struct {
+	Station    string   // restrict results to one station, empty queries all stations
 	Fields     []string // fields to be included/excluded
 	ExcludeID  bool     // exclude ID unless needed
 	Pagination struct {
 		Page  int64
 		Limit int64
+		After string // continuation token from a previous page, replaces Page
 	}
+	// return the readings as they were stored at this time, zero means now
+	AsOf time.Time
+}
+
+// asOfQuery returns the options of a query for past data, nil for a query of the current data
+func asOfQuery(opts []*QueryOptions) *QueryOptions {
+	if len(opts) == 0 || opts[0] == nil || opts[0].AsOf.IsZero() {
+		return nil
+	}
+	return opts[0]
 }
 
 func (s *QueryService) GetByDate(
@@ -36,9 +72,16 @@ func (s *QueryService) GetByDate(
 	if date.IsZero() {
 		return nil, fmt.Errorf("date cannot be zero")
 	}
+	if past := asOfQuery(opts); past != nil {
+		start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+		return s.asOf(ctx, start, start.Add(24*time.Hour-time.Nanosecond), past)
+	}
 
 	// convert service-level options to storage-level options
-	mongoOpts := buildMongoQueryOptions(opts...)
+	mongoOpts, err := buildMongoQueryOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
 
 	data, err := s.repo.GetByDate(ctx, date, mongoOpts)
 	if err != nil {
@@ -52,54 +95,242 @@ func (s *QueryService) GetByDateRange(
 	start, end time.Time,
 	opts ...*QueryOptions,
 ) ([]*model.WeatherData, error) {
+	if err := validateRange(start, end, maxRangeQuery); err != nil {
+		return nil, err
+	}
+	if past := asOfQuery(opts); past != nil {
+		return s.asOf(ctx, start, end, past)
+	}
+
+	mongoOpts, err := buildMongoQueryOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.repo.GetByDateRange(ctx, start, end, mongoOpts)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return data, nil
+}
+
+// StreamByDateRange validates the range and returns an iterator over the matching readings
+// records are read from the database while the caller consumes them
+func (s *QueryService) StreamByDateRange(
+	ctx context.Context,
+	start, end time.Time,
+	opts ...*QueryOptions,
+) (iter.Seq2[*model.WeatherData, error], error) {
+	if err := validateRange(start, end, s.maxStreamRange); err != nil {
+		return nil, err
+	}
+	// past data is rebuilt in memory, there is no cursor to stream from
+	if past := asOfQuery(opts); past != nil {
+		data, err := s.asOf(ctx, start, end, past)
+		if err != nil {
+			return nil, err
+		}
+		return seqOf(data), nil
+	}
+
+	mongoOpts, err := buildMongoQueryOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.StreamByDateRange(ctx, start, end, mongoOpts), nil
+}
+
+// CountByDateRange returns the number of readings in [start, end], regardless of pagination
+func (s *QueryService) CountByDateRange(
+	ctx context.Context,
+	start, end time.Time,
+	opts ...*QueryOptions,
+) (int64, error) {
+	if err := validateRange(start, end, 0); err != nil {
+		return 0, err
+	}
+	if past := asOfQuery(opts); past != nil {
+		all := &QueryOptions{Station: past.Station, AsOf: past.AsOf}
+		data, err := s.asOf(ctx, start, end, all)
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+
+	mongoOpts, err := buildMongoQueryOptions(opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.repo.CountByDateRange(ctx, start, end, mongoOpts)
+	if err != nil {
+		return 0, fmt.Errorf("count failed: %w", err)
+	}
+	return count, nil
+}
+
+// ErrInvalidRange is wrapped by errors caused by a bad date range
+var ErrInvalidRange = errors.New("invalid date range")
+
+// validateRange checks a date range, a zero maxRange disables the length check
+// the length is measured between the requested days, so an end extended to the last instant of its day still fits
+func validateRange(start, end time.Time, maxRange time.Duration) error {
 	switch {
 	case start.IsZero() || end.IsZero():
-		return nil, fmt.Errorf("both dates for the date range must be specified")
+		return fmt.Errorf("%w: both dates for the date range must be specified", ErrInvalidRange)
 	case end.Before(start):
-		return nil, fmt.Errorf("end date cannot be set prior to start date")
-	case end.Sub(start) > 365*24*time.Hour:
-		return nil, fmt.Errorf("date range may not exceed one year")
+		return fmt.Errorf("%w: end date cannot be set prior to start date", ErrInvalidRange)
+	case maxRange > 0 && model.TruncateToDay(end).Sub(model.TruncateToDay(start)) > maxRange:
+		return fmt.Errorf("%w: date range may not exceed %d days", ErrInvalidRange, int(maxRange.Hours()/24))
 	}
+	return nil
+}
 
-	mongoOpts := buildMongoQueryOptions(opts...)
+// AggregateOptions describe a statistics query, Metrics maps a field to its operations
+// an empty Metrics computes avg, min and max of every numeric column
+type AggregateOptions struct {
+	Station string
+	Bucket  string
+	Metrics map[string][]string
+}
 
-	data, err := s.repo.GetByDateRange(ctx, start, end, mongoOpts)
+var (
+	validBuckets = map[string]bool{
+		storage.BucketDay: true, storage.BucketWeek: true, storage.BucketMonth: true, storage.BucketYear: true,
+	}
+	validMetricOps = map[string]bool{
+		storage.MetricAvg: true, storage.MetricMin: true, storage.MetricMax: true, storage.MetricSum: true,
+	}
+)
+
+// ErrInvalidAggregate is wrapped by errors caused by bad aggregation parameters
+var ErrInvalidAggregate = errors.New("invalid aggregation")
+
+func (s *QueryService) Aggregate(
+	ctx context.Context,
+	start, end time.Time,
+	opts AggregateOptions,
+) ([]*model.AggregateBucket, error) {
+	switch {
+	case start.IsZero() || end.IsZero():
+		return nil, fmt.Errorf("%w: both dates for the date range must be specified", ErrInvalidAggregate)
+	case end.Before(start):
+		return nil, fmt.Errorf("%w: end date cannot be set prior to start date", ErrInvalidAggregate)
+	case !validBuckets[opts.Bucket]:
+		return nil, fmt.Errorf("%w: unknown bucket %q", ErrInvalidAggregate, opts.Bucket)
+	}
+
+	metrics, err := buildMetrics(opts.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.repo.Aggregate(ctx, start, end, storage.AggregateOptions{
+		Station: opts.Station,
+		Bucket:  opts.Bucket,
+		Metrics: metrics,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 	return data, nil
 }
 
-func buildMongoQueryOptions(opts ...*QueryOptions) *storage.QueryOptions {
+// buildMetrics validates requested metrics against the numeric columns of the schema
+// an operation requested twice for a field is computed once, the result has a single key per metric anyway
+func buildMetrics(requested map[string][]string) ([]storage.Metric, error) {
+	schema := model.ActiveSchema()
+
+	if len(requested) == 0 {
+		var metrics []storage.Metric
+		for _, col := range schema.ValueColumns() {
+			if col.Type == model.ColumnFloat || col.Type == model.ColumnInt {
+				for _, op := range []string{storage.MetricAvg, storage.MetricMin, storage.MetricMax} {
+					metrics = append(metrics, storage.Metric{Field: col.Field, Op: op})
+				}
+			}
+		}
+		return metrics, nil
+	}
+
+	// iterate in schema order so the pipeline is deterministic
+	var metrics []storage.Metric
+	for _, col := range schema.ValueColumns() {
+		ops, ok := requested[col.Field]
+		if !ok {
+			continue
+		}
+		if col.Type != model.ColumnFloat && col.Type != model.ColumnInt {
+			return nil, fmt.Errorf("%w: field %q is not numeric", ErrInvalidAggregate, col.Field)
+		}
+		for _, op := range ops {
+			if !validMetricOps[op] {
+				return nil, fmt.Errorf("%w: unknown operation %q for field %q", ErrInvalidAggregate, op, col.Field)
+			}
+			metric := storage.Metric{Field: col.Field, Op: op}
+			if !slices.Contains(metrics, metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	for field := range requested {
+		if _, ok := schema.Column(field); !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidAggregate, field)
+		}
+	}
+
+	return metrics, nil
+}
+
+func buildMongoQueryOptions(opts ...*QueryOptions) (*storage.QueryOptions, error) {
 	if len(opts) == 0 || opts[0] == nil {
-		return storage.DefaultQueryOptions()
+		return storage.DefaultQueryOptions(), nil
 	}
 
 	serviceOpts := opts[0]
 	mongoOpts := &storage.QueryOptions{
-		Sort: bson.D{{Key: "date", Value: 1}},
+		Station: serviceOpts.Station,
+		Sort:    storage.DefaultSort(),
 	}
 
 	// handle field projection conservatively
 	// only include fields explicitly requested and if no fields are specified, default to nil (no projection)
+	// fields are whitelisted against the schema so arbitrary document keys cannot be requested
 	if len(serviceOpts.Fields) > 0 {
+		schema := model.ActiveSchema()
 		projection := bson.M{}
 		for _, field := range serviceOpts.Fields {
-			projection[field] = 1
+			if schema.HasField(field) {
+				projection[field] = 1
+			}
 		}
-		// always include the "date" field (and set _id exclusion explicitly)
+		// always include the "date" and "station" fields (and set _id exclusion explicitly)
 		projection["date"] = 1
+		projection["station"] = 1
 		projection["_id"] = 0
 
 		mongoOpts.Projection = projection
 	}
 
+	// keyset pagination continues after the token instead of skipping pages
+	if serviceOpts.Pagination.After != "" {
+		after, err := decodeCursor(serviceOpts.Pagination.After)
+		if err != nil {
+			return nil, err
+		}
+		mongoOpts.After = after
+	}
+
 	// pagination
 	if serviceOpts.Pagination.Limit > 0 {
 		mongoOpts.Limit = &serviceOpts.Pagination.Limit
-		skip := (serviceOpts.Pagination.Page - 1) * serviceOpts.Pagination.Limit
-		mongoOpts.Skip = &skip
+		if mongoOpts.After == nil && serviceOpts.Pagination.Page > 1 {
+			skip := (serviceOpts.Pagination.Page - 1) * serviceOpts.Pagination.Limit
+			mongoOpts.Skip = &skip
+		}
 	}
 
-	return mongoOpts
+	return mongoOpts, nil
 }
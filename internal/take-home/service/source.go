@@ -0,0 +1,26 @@
+package service
+
+import "context"
+
+// default sources of writes, stored as the ingestedBy of readings and recorded in the revision history
+const (
+	SourceAPI = "api"
+	// followed by the path of the ingested file
+	sourceFilePrefix = "file:"
+)
+
+type sourceKey struct{}
+
+// ContextWithSource names who writes with ctx, e.g. the API key of a request
+// the name is stored on the readings it changes and recorded in the revision history
+func ContextWithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the source set by ContextWithSource, fallback if there is none
+func SourceFromContext(ctx context.Context, fallback string) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok && source != "" {
+		return source
+	}
+	return fallback
+}
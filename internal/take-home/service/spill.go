@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spillFile is an NDJSON queue on disk for readings that did not fit into the in-memory queue
+// readings are acknowledged once they are back in the queue, unacknowledged ones survive a restart
+type spillFile struct {
+	mu     sync.Mutex
+	path   string
+	writer *os.File
+	reader *os.File
+	buf    *bufio.Reader
+	count  int // readings written and not yet acknowledged
+	acked  int64
+	size   int64
+	// line of every unacknowledged ticket, so a ticket can be looked up without keeping it in memory
+	index map[string]spillLine
+	// length and ticket of the line handed out by next, removed by ack
+	nextLen    int64
+	nextTicket string
+}
+
+// spillLine is where a spilled reading is stored in the file
+type spillLine struct {
+	offset int64
+	length int
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	writer, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+
+	// readings left behind by an earlier process
+	count := 0
+	size := int64(0)
+	index := make(map[string]spillLine)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) > 0 {
+			count++
+			var job pipelineJob
+			// corrupt lines are dropped when they are read by next
+			if json.Unmarshal(line, &job) == nil {
+				index[job.TicketID] = spillLine{offset: size, length: len(line)}
+			}
+		}
+		size += int64(len(line)) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+
+	return &spillFile{path: path, writer: writer, reader: reader, buf: bufio.NewReader(reader), count: count, size: size, index: index}, nil
+}
+
+func (s *spillFile) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *spillFile) write(job *pipelineJob) error {
+	line, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.index[job.TicketID] = spillLine{offset: s.size, length: len(line)}
+	s.size += int64(len(line)) + 1
+	s.count++
+	return nil
+}
+
+// lookup reads the job of an unacknowledged ticket back from the file
+func (s *spillFile) lookup(ticketID string) (*pipelineJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.index[ticketID]
+	if !ok {
+		return nil, false
+	}
+	line := make([]byte, at.length)
+	if _, err := s.reader.ReadAt(line, at.offset); err != nil {
+		return nil, false
+	}
+	var job pipelineJob
+	if err := json.Unmarshal(line, &job); err != nil {
+		return nil, false
+	}
+	return &job, true
+}
+
+// next returns the oldest unacknowledged reading, nil when there is none
+// the file is emptied once every reading has been acknowledged
+func (s *spillFile) next() (*pipelineJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		if s.acked > 0 {
+			return nil, s.reset()
+		}
+		return nil, nil
+	}
+
+	for {
+		line, err := s.buf.ReadBytes('\n')
+		if err != nil {
+			// lines are written whole under the lock, a missing one means the file was changed underneath
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			s.acked += int64(len(line))
+			continue
+		}
+
+		s.nextLen = int64(len(line))
+		var job pipelineJob
+		if err := json.Unmarshal(line, &job); err != nil {
+			// a corrupt line would block the spill file forever, drop it
+			s.ackLocked()
+			return nil, fmt.Errorf("invalid spilled reading: %w", err)
+		}
+		s.nextTicket = job.TicketID
+		return &job, nil
+	}
+}
+
+// ack confirms that the reading returned by next is back in the queue
+func (s *spillFile) ack() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackLocked()
+}
+
+func (s *spillFile) ackLocked() {
+	delete(s.index, s.nextTicket)
+	s.acked += s.nextLen
+	s.nextLen, s.nextTicket = 0, ""
+	s.count--
+}
+
+func (s *spillFile) reset() error {
+	if err := s.writer.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.buf.Reset(s.reader)
+	s.acked, s.size = 0, 0
+	return nil
+}
+
+// close drops the acknowledged readings from the file so a restart only sees the rest
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.writer.Close()
+	defer s.reader.Close()
+
+	if s.count == 0 {
+		return s.writer.Truncate(0)
+	}
+	if s.acked == 0 {
+		return nil
+	}
+
+	if _, err := s.reader.Seek(s.acked, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, s.reader); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+)
+
+type StationServiceInterface interface {
+	RegisterStation(ctx context.Context, station *model.Station) error
+	GetStation(ctx context.Context, id string) (*model.Station, error)
+	ListStations(ctx context.Context) ([]*model.Station, error)
+}
+
+type StationService struct {
+	repo storage.StationRepository
+}
+
+func NewStationService(repo storage.StationRepository) *StationService {
+	return &StationService{repo: repo}
+}
+
+func (s *StationService) RegisterStation(ctx context.Context, station *model.Station) error {
+	if err := station.Validate(); err != nil {
+		return fmt.Errorf("invalid station: %w", err)
+	}
+	return s.repo.UpsertStation(ctx, station)
+}
+
+// GetStation returns storage.ErrNotFound (wrapped) for unknown stations
+func (s *StationService) GetStation(ctx context.Context, id string) (*model.Station, error) {
+	if id == "" {
+		return nil, fmt.Errorf("station id cannot be empty")
+	}
+	station, err := s.repo.GetStation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("station lookup failed: %w", err)
+	}
+	return station, nil
+}
+
+func (s *StationService) ListStations(ctx context.Context) ([]*model.Station, error) {
+	stations, err := s.repo.ListStations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return stations, nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// storage backends selectable through STORAGE_BACKEND
+const (
+	BackendMongo  = "mongo"
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// Repository is the full storage contract every backend implements
+type Repository interface {
+	WeatherRepository
+	StationRepository
+	RevisionRepository
+	CheckpointRepository
+	IdempotencyRepository
+	APIKeyRepository
+}
+
+var (
+	_ Repository = (*MongoDBRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+	_ Repository = (*BoltRepository)(nil)
+)
+
+// BackendConfig selects a backend and carries the settings it needs
+type BackendConfig struct {
+	Backend  string
+	MongoURI string // mongo only
+	Path     string // database file of the bolt backend
+}
+
+// Open connects to the configured backend, CloseConnection releases it
+func Open(ctx context.Context, cfg BackendConfig) (Repository, error) {
+	switch cfg.Backend {
+	case BackendMongo, "":
+		client, err := Connect(ctx, cfg.MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		return NewMongoDBRepository(client), nil
+	case BackendMemory:
+		return NewMemoryRepository(), nil
+	case BackendBolt:
+		return NewBoltRepository(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,615 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// bucket names of the embedded database
+var (
+	boltWeatherBucket  = []byte("weather_data")
+	boltStationsBucket = []byte("stations")
+	boltRunsBucket     = []byte("ingest_runs")
+	boltIdemBucket     = []byte("idempotency_keys")
+	boltRevsBucket     = []byte("weather_revisions")
+	boltKeysBucket     = []byte("api_keys")
+)
+
+// BoltRepository stores readings in a single bbolt file
+// keys are (date, station) so a cursor walks readings in the order of DefaultSort,
+// values are BSON documents, the same encoding MongoDB uses
+type BoltRepository struct {
+	sortedRepository
+
+	db *bbolt.DB
+	// last removal of expired idempotency records, only touched inside write transactions
+	lastSweep time.Time
+}
+
+// NewBoltRepository opens or creates the database file at path
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for '%s': %w", path, err)
+		}
+	}
+
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database '%s': %w", path, err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltWeatherBucket, boltStationsBucket, boltRunsBucket, boltIdemBucket, boltRevsBucket, boltKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets in '%s': %w", path, err)
+	}
+
+	r := &BoltRepository{db: db}
+	r.sortedRepository = sortedRepository{store: r}
+	return r, nil
+}
+
+// boltKey encodes (date, station) so that byte order equals (date, station) order
+// the sign bit is flipped so dates before 1970 sort before later ones
+func boltKey(date time.Time, station string) []byte {
+	key := make([]byte, 8, 8+len(station))
+	binary.BigEndian.PutUint64(key, uint64(storedDate(date).UnixMilli())^(1<<63))
+	return append(key, station...)
+}
+
+func (r *BoltRepository) scan(ctx context.Context, start, end time.Time, after *Cursor, station string, limit int) ([]*model.WeatherData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*model.WeatherData, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(boltWeatherBucket).Cursor()
+
+		seek := boltKey(start, "")
+		var afterKey []byte
+		if after != nil && !after.Date.Before(start) {
+			afterKey = boltKey(after.Date, after.Station)
+			seek = afterKey
+		}
+		// first key past end, readings are stored with millisecond precision
+		endKey := boltKey(end.Add(time.Millisecond), "")
+
+		for k, v := c.Seek(seek); k != nil && len(results) < limit; k, v = c.Next() {
+			if bytes.Compare(k, endKey) >= 0 {
+				break
+			}
+			if afterKey != nil && bytes.Equal(k, afterKey) {
+				continue
+			}
+			if station != "" && string(k[8:]) != station {
+				continue
+			}
+
+			var data model.WeatherData
+			if err := bson.Unmarshal(v, &data); err != nil {
+				return fmt.Errorf("failed to decode result: %w", err)
+			}
+			// keys have millisecond precision, a sub-millisecond start can match one reading too early
+			if data.Date.Before(start) {
+				continue
+			}
+			data.Date = data.Date.UTC()
+			results = append(results, &data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt scan failed: %w", err)
+	}
+	return results, nil
+}
+
+// BulkUpsert writes each batch in one transaction
+// a record that cannot be encoded or is refused by the reject policy is reported like a MongoDB write error,
+// ordered mode stops there
+func (r *BoltRepository) BulkUpsert(ctx context.Context, data []*model.WeatherData, opts BulkOptions) (*BulkResult, error) {
+	result := &BulkResult{Outcomes: make([]string, len(data))}
+	if len(data) == 0 {
+		return result, nil
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	for offset := 0; offset < len(data); offset += batchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := min(offset+batchSize, len(data))
+
+		failed := false
+		err := r.db.Update(func(tx *bbolt.Tx) error {
+			for i, weatherData := range data[offset:end] {
+				outcome, err := boltUpsert(tx, weatherData, opts)
+				switch {
+				case err != nil:
+					result.Errors = append(result.Errors, BulkWriteError{Index: offset + i, Message: err.Error()})
+				case outcome == OutcomeConflict:
+					result.Errors = append(result.Errors, conflictError(offset+i, weatherData))
+					result.Outcomes[offset+i] = outcome
+				default:
+					result.Outcomes[offset+i] = outcome
+					result.count(outcome)
+					continue
+				}
+				failed = true
+				if opts.Ordered {
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("bulk upsert failed: %w", err)
+		}
+		if failed && opts.Ordered {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// boltUpsert stores a reading according to the conflict policy and records the change in the history
+func boltUpsert(tx *bbolt.Tx, data *model.WeatherData, opts BulkOptions) (string, error) {
+	bucket := tx.Bucket(boltWeatherBucket)
+	key := boltKey(data.Date, data.Station)
+
+	var existing *model.WeatherData
+	if v := bucket.Get(key); v != nil {
+		existing = &model.WeatherData{}
+		if err := bson.Unmarshal(v, existing); err != nil {
+			return "", fmt.Errorf("failed to decode stored reading: %w", err)
+		}
+	}
+
+	store, outcome := resolveConflict(opts.Conflict.For(data.Station), existing, data)
+	opts.stamp(store)
+	if revision := opts.revision(outcome, existing, store, time.Now()); revision != nil {
+		if err := boltPutRevision(tx, key, revision); err != nil {
+			return "", err
+		}
+	}
+	if store == nil {
+		return outcome, nil
+	}
+
+	encoded, err := bson.Marshal(store)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode reading: %w", err)
+	}
+	if err := bucket.Put(key, encoded); err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
+
+func (r *BoltRepository) ReplaceReading(ctx context.Context, data *model.WeatherData, opts BulkOptions) (string, error) {
+	var outcome string
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		key := boltKey(data.Date, data.Station)
+		existing, err := boltGetReading(tx, key)
+		if err != nil {
+			return err
+		}
+		var store *model.WeatherData
+		store, outcome = replacedReading(existing, data)
+		if outcome == OutcomeUnchanged {
+			return nil
+		}
+		opts.stamp(store)
+		return boltPutReading(tx, key, store, opts.revision(outcome, existing, store, time.Now()))
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to replace reading: %w", err)
+	}
+	return outcome, nil
+}
+
+func (r *BoltRepository) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts BulkOptions) (*model.WeatherData, error) {
+	var patched *model.WeatherData
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		key := boltKey(date, station)
+		existing, err := boltGetReading(tx, key)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrNotFound
+		}
+		var changed bool
+		patched, changed = patchedReading(existing, values)
+		if !changed {
+			return nil
+		}
+		opts.stamp(patched)
+		return boltPutReading(tx, key, patched, opts.revision(OutcomeUpdated, existing, patched, time.Now()))
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch reading: %w", err)
+	}
+	patched.Date = patched.Date.UTC()
+	return patched, nil
+}
+
+func (r *BoltRepository) DeleteReadings(ctx context.Context, start, end time.Time, station string, opts BulkOptions) ([]*model.WeatherData, error) {
+	deleted := []*model.WeatherData{}
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(boltWeatherBucket).Cursor()
+		endKey := boltKey(end.Add(time.Millisecond), "")
+		for k, v := c.Seek(boltKey(start, "")); k != nil && bytes.Compare(k, endKey) < 0; {
+			if station != "" && string(k[8:]) != station {
+				k, v = c.Next()
+				continue
+			}
+			data := &model.WeatherData{}
+			if err := bson.Unmarshal(v, data); err != nil {
+				return fmt.Errorf("failed to decode stored reading: %w", err)
+			}
+			if data.Date.Before(start) {
+				k, v = c.Next()
+				continue
+			}
+			data.Date = data.Date.UTC()
+			if revision := opts.revision(OutcomeDeleted, data, nil, now); revision != nil {
+				if err := boltPutRevision(tx, k, revision); err != nil {
+					return err
+				}
+			}
+			// Delete moves the cursor to the next key
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted = append(deleted, data)
+			k, v = c.Seek(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete readings: %w", err)
+	}
+	return deleted, nil
+}
+
+// boltGetReading decodes the reading stored under key, nil if there is none
+func boltGetReading(tx *bbolt.Tx, key []byte) (*model.WeatherData, error) {
+	v := tx.Bucket(boltWeatherBucket).Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	data := &model.WeatherData{}
+	if err := bson.Unmarshal(v, data); err != nil {
+		return nil, fmt.Errorf("failed to decode stored reading: %w", err)
+	}
+	return data, nil
+}
+
+// boltPutReading stores a reading under key and records the change unless revision is nil
+func boltPutReading(tx *bbolt.Tx, key []byte, data *model.WeatherData, revision *model.WeatherRevision) error {
+	encoded, err := bson.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode reading: %w", err)
+	}
+	if err := tx.Bucket(boltWeatherBucket).Put(key, encoded); err != nil {
+		return err
+	}
+	if revision != nil {
+		return boltPutRevision(tx, key, revision)
+	}
+	return nil
+}
+
+// boltPutRevision appends a change to the history of the reading stored under readingKey
+// revisions are keyed by the reading key, a NUL separator and the bucket sequence,
+// so the changes of a reading follow each other in the order they were written
+func boltPutRevision(tx *bbolt.Tx, readingKey []byte, revision *model.WeatherRevision) error {
+	bucket := tx.Bucket(boltRevsBucket)
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	encoded, err := bson.Marshal(revision)
+	if err != nil {
+		return fmt.Errorf("failed to encode revision: %w", err)
+	}
+
+	key := make([]byte, 0, len(readingKey)+9)
+	key = append(key, readingKey...)
+	key = append(key, 0)
+	return bucket.Put(binary.BigEndian.AppendUint64(key, seq), encoded)
+}
+
+func (r *BoltRepository) ListRevisions(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error) {
+	revisions := []*model.WeatherRevision{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(boltRevsBucket).Cursor()
+		endKey := boltKey(end.Add(time.Millisecond), "")
+		for k, v := c.Seek(boltKey(start, "")); k != nil && bytes.Compare(k, endKey) < 0; k, v = c.Next() {
+			// the station sits between the date and the separator in front of the sequence
+			if station != "" && string(k[8:len(k)-9]) != station {
+				continue
+			}
+			revision := &model.WeatherRevision{}
+			if err := bson.Unmarshal(v, revision); err != nil {
+				return err
+			}
+			if revision.Date.Before(start) {
+				continue
+			}
+			revision.Date = revision.Date.UTC()
+			revision.IngestedAt = revision.IngestedAt.UTC()
+			revisions = append(revisions, revision)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+func (r *BoltRepository) UpsertStation(ctx context.Context, station *model.Station) error {
+	encoded, err := bson.Marshal(station)
+	if err != nil {
+		return fmt.Errorf("failed to encode station '%s': %w", station.ID, err)
+	}
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltStationsBucket).Put([]byte(station.ID), encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upsert station '%s': %w", station.ID, err)
+	}
+	return nil
+}
+
+func (r *BoltRepository) GetStation(ctx context.Context, id string) (*model.Station, error) {
+	var station *model.Station
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltStationsBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		station = &model.Station{}
+		return bson.Unmarshal(v, station)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find station '%s': %w", id, err)
+	}
+	return station, nil
+}
+
+// ListStations returns stations ordered by id, the key order of the bucket
+func (r *BoltRepository) ListStations(ctx context.Context) ([]*model.Station, error) {
+	stations := []*model.Station{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltStationsBucket).ForEach(func(k, v []byte) error {
+			var station model.Station
+			if err := bson.Unmarshal(v, &station); err != nil {
+				return err
+			}
+			stations = append(stations, &station)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stations: %w", err)
+	}
+	return stations, nil
+}
+
+func (r *BoltRepository) SaveAPIKey(ctx context.Context, key *model.APIKey) error {
+	encoded, err := bson.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode API key '%s': %w", key.ID, err)
+	}
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).Put([]byte(key.ID), encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save API key '%s': %w", key.ID, err)
+	}
+	return nil
+}
+
+func (r *BoltRepository) GetAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	var key *model.APIKey
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltKeysBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		key = &model.APIKey{}
+		return bson.Unmarshal(v, key)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find API key '%s': %w", id, err)
+	}
+	return key, nil
+}
+
+// FindAPIKeyByHash scans the bucket, there are few keys and no secondary index
+func (r *BoltRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	keys, err := r.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListAPIKeys returns keys ordered by id, the key order of the bucket
+func (r *BoltRepository) ListAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	keys := []*model.APIKey{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).ForEach(func(k, v []byte) error {
+			var key model.APIKey
+			if err := bson.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, &key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *BoltRepository) GetCheckpoint(ctx context.Context, id string) (*model.IngestCheckpoint, error) {
+	var checkpoint *model.IngestCheckpoint
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltRunsBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		checkpoint = &model.IngestCheckpoint{}
+		return bson.Unmarshal(v, checkpoint)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find checkpoint '%s': %w", id, err)
+	}
+	return checkpoint, nil
+}
+
+func (r *BoltRepository) SaveCheckpoint(ctx context.Context, checkpoint *model.IngestCheckpoint) error {
+	encoded, err := bson.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint '%s': %w", checkpoint.ID, err)
+	}
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltRunsBucket).Put([]byte(checkpoint.ID), encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint '%s': %w", checkpoint.ID, err)
+	}
+	return nil
+}
+
+func (r *BoltRepository) ClaimIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	encoded, err := bson.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency key '%s': %w", record.Key, err)
+	}
+
+	var existing *model.IdempotencyRecord
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltIdemBucket)
+		now := time.Now()
+		if now.Sub(r.lastSweep) > idempotencySweepInterval {
+			if err := boltSweepIdempotency(bucket, now); err != nil {
+				return err
+			}
+			r.lastSweep = now
+		}
+
+		if v := bucket.Get([]byte(record.Key)); v != nil {
+			stored := &model.IdempotencyRecord{}
+			if err := bson.Unmarshal(v, stored); err != nil {
+				return err
+			}
+			if !stored.Expired(now) {
+				existing = stored
+				return nil
+			}
+		}
+		return bucket.Put([]byte(record.Key), encoded)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key '%s': %w", record.Key, err)
+	}
+	return existing, nil
+}
+
+// boltSweepIdempotency deletes expired records, keys are collected first as a bucket must not change while it is iterated
+func boltSweepIdempotency(bucket *bbolt.Bucket, now time.Time) error {
+	var expired [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		var stored model.IdempotencyRecord
+		if err := bson.Unmarshal(v, &stored); err != nil || stored.Expired(now) {
+			expired = append(expired, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *BoltRepository) SaveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) error {
+	encoded, err := bson.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency key '%s': %w", record.Key, err)
+	}
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltIdemBucket).Put([]byte(record.Key), encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency key '%s': %w", record.Key, err)
+	}
+	return nil
+}
+
+func (r *BoltRepository) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltIdemBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key '%s': %w", key, err)
+	}
+	return nil
+}
+
+func (r *BoltRepository) CloseConnection(ctx context.Context) error {
+	return r.db.Close()
+}
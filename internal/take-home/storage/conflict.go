@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// conflict policies, they decide what happens when a reading for the same station and timestamp is stored already
+const (
+	ConflictLastWriteWins  = "last-write-wins"  // the new reading is merged into the stored one like $set
+	ConflictFirstWriteWins = "first-write-wins" // the stored reading is kept
+	ConflictReject         = "reject"           // the new reading is refused with ErrConflict
+	ConflictRevisions      = "revisions"        // the new reading wins, the stored one is archived as a revision
+)
+
+// outcome of a single record of a write, reported in BulkResult.Outcomes
+const (
+	OutcomeInserted  = "inserted"
+	OutcomeUpdated   = "updated"
+	OutcomeUnchanged = "unchanged" // the stored reading already had these values
+	OutcomeKept      = "kept"      // first-write-wins ignored the new values
+	OutcomeRevised   = "revised"   // the previous values were archived as a revision
+	OutcomeConflict  = "conflict"  // rejected, reported in BulkResult.Errors as well
+	OutcomeDeleted   = "deleted"
+)
+
+// ErrConflict marks readings refused by the reject policy because different values are stored already
+var ErrConflict = errors.New("conflicts with the stored reading")
+
+// ConflictPolicy selects a policy per station, stations without an entry use Default
+// the zero value is last-write-wins for every station
+type ConflictPolicy struct {
+	Default  string
+	Stations map[string]string
+}
+
+// For returns the policy of station
+func (p ConflictPolicy) For(station string) string {
+	if policy, ok := p.Stations[station]; ok {
+		return policy
+	}
+	if p.Default == "" {
+		return ConflictLastWriteWins
+	}
+	return p.Default
+}
+
+// ValidConflictPolicy reports whether name is one of the supported policies
+func ValidConflictPolicy(name string) bool {
+	switch name {
+	case ConflictLastWriteWins, ConflictFirstWriteWins, ConflictReject, ConflictRevisions:
+		return true
+	}
+	return false
+}
+
+// readingKey identifies a reading in maps, time.Time is not a reliable map key
+type readingKey struct {
+	station string
+	millis  int64
+}
+
+func newReadingKey(station string, date time.Time) readingKey {
+	return readingKey{station: station, millis: storedDate(date).UnixMilli()}
+}
+
+// resolveConflict applies policy to an incoming reading and the stored one, nil if there is none
+// store is the reading to write, nil leaves storage untouched
+func resolveConflict(policy string, existing, incoming *model.WeatherData) (store *model.WeatherData, outcome string) {
+	merged, changed := mergeReading(existing, incoming)
+	switch {
+	case existing == nil:
+		return merged, OutcomeInserted
+	case !changed:
+		return nil, OutcomeUnchanged
+	}
+
+	switch policy {
+	case ConflictFirstWriteWins:
+		return nil, OutcomeKept
+	case ConflictReject:
+		return nil, OutcomeConflict
+	case ConflictRevisions:
+		return merged, OutcomeRevised
+	default:
+		return merged, OutcomeUpdated
+	}
+}
+
+// stamp records the source of the write on the reading it stores, nil is left as it is
+func (o BulkOptions) stamp(store *model.WeatherData) *model.WeatherData {
+	if store != nil {
+		store.IngestedBy = o.Source
+	}
+	return store
+}
+
+// conflictError is the write error reported for a rejected reading
+func conflictError(index int, data *model.WeatherData) BulkWriteError {
+	return BulkWriteError{
+		Index:    index,
+		Message:  fmt.Sprintf("reading of station '%s' at %s %s", data.Station, storedDate(data.Date).Format(time.RFC3339Nano), ErrConflict),
+		Conflict: true,
+	}
+}
+
+// count adds a successful outcome to the Matched, Modified and Upserted totals
+func (r *BulkResult) count(outcome string) {
+	switch outcome {
+	case OutcomeInserted:
+		r.Upserted++
+	case OutcomeUpdated, OutcomeRevised:
+		r.Matched++
+		r.Modified++
+	case OutcomeUnchanged, OutcomeKept:
+		r.Matched++
+	}
+}
+
+// Outcome returns the outcome of the record at index i, empty if it is unknown
+func (r *BulkResult) Outcome(i int) string {
+	if i < 0 || i >= len(r.Outcomes) {
+		return ""
+	}
+	return r.Outcomes[i]
+}
+
+// Changed reports whether the record at index i changed the stored data,
+// records of backends that do not report outcomes count as changed
+func (r *BulkResult) Changed(i int) bool {
+	switch r.Outcome(i) {
+	case OutcomeUnchanged, OutcomeKept, OutcomeConflict:
+		return false
+	}
+	return true
+}
+
+// revision returns the history entry of a write with outcome, nil if the write is not recorded
+// every change is recorded with History, without it only changes that supersede a reading
+// of a station under the revisions policy are
+func (o BulkOptions) revision(outcome string, existing, store *model.WeatherData, at time.Time) *model.WeatherRevision {
+	switch outcome {
+	case OutcomeInserted, OutcomeUpdated, OutcomeRevised, OutcomeDeleted:
+	default:
+		return nil
+	}
+	current := store
+	if current == nil {
+		current = existing
+	}
+	if !o.History && (existing == nil || o.Conflict.For(current.Station) != ConflictRevisions) {
+		return nil
+	}
+
+	revision := &model.WeatherRevision{
+		Station:    current.Station,
+		Date:       storedDate(current.Date),
+		Change:     outcome,
+		Source:     o.Source,
+		IngestedAt: storedDate(at),
+	}
+	if store != nil {
+		revision.Values = maps.Clone(store.Values)
+	}
+	if existing != nil {
+		revision.Previous = maps.Clone(existing.Values)
+	}
+	return revision
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// DeadLetterSink keeps rows rejected during lenient file ingestion
+type DeadLetterSink interface {
+	WriteDeadLetters(ctx context.Context, lines []*model.RejectedLine) error
+}
+
+// FileDeadLetterSink appends rejected rows to a file, one JSON object per line
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileDeadLetterSink(path string) *FileDeadLetterSink {
+	return &FileDeadLetterSink{path: path}
+}
+
+func (s *FileDeadLetterSink) WriteDeadLetters(ctx context.Context, lines []*model.RejectedLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+
+	enc := json.NewEncoder(file)
+	for _, line := range lines {
+		if err := enc.Encode(line); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write dead letter: %w", err)
+		}
+	}
+	return file.Close()
+}
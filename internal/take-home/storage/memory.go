@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// MemoryRepository keeps readings in a slice sorted by (date, station)
+// it is safe for concurrent use and meant for development, tests and CI, data is lost on exit
+type MemoryRepository struct {
+	sortedRepository
+
+	mu          sync.RWMutex
+	readings    []*model.WeatherData
+	stations    map[string]*model.Station
+	checkpoints map[string]*model.IngestCheckpoint
+	idempotency map[string]*model.IdempotencyRecord
+	apiKeys     map[string]*model.APIKey
+	// revision history in the order it was written
+	revisions []*model.WeatherRevision
+	// expired idempotency records are removed at most once per sweep interval
+	lastSweep time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	r := &MemoryRepository{
+		stations:    make(map[string]*model.Station),
+		checkpoints: make(map[string]*model.IngestCheckpoint),
+		idempotency: make(map[string]*model.IdempotencyRecord),
+		apiKeys:     make(map[string]*model.APIKey),
+	}
+	r.sortedRepository = sortedRepository{store: r}
+	return r
+}
+
+// search returns the position of the first reading at or after the given key
+func (r *MemoryRepository) search(key Cursor) (int, bool) {
+	return slices.BinarySearchFunc(r.readings, key, func(data *model.WeatherData, key Cursor) int {
+		return compareKey(data.Date, data.Station, key)
+	})
+}
+
+func (r *MemoryRepository) scan(ctx context.Context, start, end time.Time, after *Cursor, station string, limit int) ([]*model.WeatherData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, _ := r.search(Cursor{Date: start})
+	if after != nil && !after.Date.Before(start) {
+		var found bool
+		if i, found = r.search(*after); found {
+			i++
+		}
+	}
+
+	results := make([]*model.WeatherData, 0, min(limit, len(r.readings)-i))
+	for ; i < len(r.readings) && len(results) < limit; i++ {
+		data := r.readings[i]
+		if data.Date.After(end) {
+			break
+		}
+		if station != "" && data.Station != station {
+			continue
+		}
+		// stored readings are never mutated, callers get their own copy
+		results = append(results, data.Clone())
+	}
+	return results, nil
+}
+
+// BulkUpsert writes all records under a single lock
+// the in-memory store cannot fail a record, only the reject policy refuses one
+func (r *MemoryRepository) BulkUpsert(ctx context.Context, data []*model.WeatherData, opts BulkOptions) (*BulkResult, error) {
+	result := &BulkResult{Outcomes: make([]string, len(data))}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, weatherData := range data {
+		outcome := r.upsert(weatherData, opts)
+		if outcome == OutcomeConflict {
+			result.Errors = append(result.Errors, conflictError(i, weatherData))
+			result.Outcomes[i] = outcome
+			if opts.Ordered {
+				break
+			}
+			continue
+		}
+		result.Outcomes[i] = outcome
+		result.count(outcome)
+	}
+	return result, nil
+}
+
+// upsert stores a reading according to the conflict policy and records it in the history, the caller holds the write lock
+// readings are replaced rather than modified so that copies handed out by scan stay consistent
+func (r *MemoryRepository) upsert(data *model.WeatherData, opts BulkOptions) string {
+	key := Cursor{Date: storedDate(data.Date), Station: data.Station}
+	i, found := r.search(key)
+
+	var existing *model.WeatherData
+	if found {
+		existing = r.readings[i]
+	}
+	store, outcome := resolveConflict(opts.Conflict.For(data.Station), existing, data)
+	opts.stamp(store)
+	r.record(opts.revision(outcome, existing, store, time.Now()))
+	switch {
+	case store == nil:
+	case found:
+		r.readings[i] = store
+	default:
+		r.readings = slices.Insert(r.readings, i, store)
+	}
+	return outcome
+}
+
+func (r *MemoryRepository) ListRevisions(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []*model.WeatherRevision{}
+	for _, revision := range r.revisions {
+		if revision.Date.Before(start) || revision.Date.After(end) || (station != "" && revision.Station != station) {
+			continue
+		}
+		c := *revision
+		c.Values = maps.Clone(revision.Values)
+		c.Previous = maps.Clone(revision.Previous)
+		revisions = append(revisions, &c)
+	}
+	// the stable sort keeps the changes of a reading in the order they were written
+	slices.SortStableFunc(revisions, func(a, b *model.WeatherRevision) int {
+		return compareKey(a.Date, a.Station, Cursor{Date: b.Date, Station: b.Station})
+	})
+	return revisions, nil
+}
+
+func (r *MemoryRepository) ReplaceReading(ctx context.Context, data *model.WeatherData, opts BulkOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, found := r.search(Cursor{Date: storedDate(data.Date), Station: data.Station})
+	var existing *model.WeatherData
+	if found {
+		existing = r.readings[i]
+	}
+	store, outcome := replacedReading(existing, data)
+	opts.stamp(store)
+	switch {
+	case outcome == OutcomeUnchanged:
+		return outcome, nil
+	case found:
+		r.readings[i] = store
+	default:
+		r.readings = slices.Insert(r.readings, i, store)
+	}
+	r.record(opts.revision(outcome, existing, store, time.Now()))
+	return outcome, nil
+}
+
+func (r *MemoryRepository) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts BulkOptions) (*model.WeatherData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, found := r.search(Cursor{Date: storedDate(date), Station: station})
+	if !found {
+		return nil, ErrNotFound
+	}
+	existing := r.readings[i]
+	patched, changed := patchedReading(existing, values)
+	if changed {
+		opts.stamp(patched)
+		r.readings[i] = patched
+		r.record(opts.revision(OutcomeUpdated, existing, patched, time.Now()))
+	}
+	return patched.Clone(), nil
+}
+
+func (r *MemoryRepository) DeleteReadings(ctx context.Context, start, end time.Time, station string, opts BulkOptions) ([]*model.WeatherData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	deleted := []*model.WeatherData{}
+	r.readings = slices.DeleteFunc(r.readings, func(data *model.WeatherData) bool {
+		if data.Date.Before(start) || data.Date.After(end) || (station != "" && data.Station != station) {
+			return false
+		}
+		r.record(opts.revision(OutcomeDeleted, data, nil, now))
+		deleted = append(deleted, data.Clone())
+		return true
+	})
+	return deleted, nil
+}
+
+// record appends a change to the history, nil revisions are ignored, the caller holds the write lock
+func (r *MemoryRepository) record(revision *model.WeatherRevision) {
+	if revision != nil {
+		r.revisions = append(r.revisions, revision)
+	}
+}
+
+func (r *MemoryRepository) UpsertStation(ctx context.Context, station *model.Station) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *station
+	r.stations[station.ID] = &stored
+	return nil
+}
+
+func (r *MemoryRepository) GetStation(ctx context.Context, id string) (*model.Station, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	station, ok := r.stations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *station
+	return &found, nil
+}
+
+func (r *MemoryRepository) ListStations(ctx context.Context) ([]*model.Station, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stations := make([]*model.Station, 0, len(r.stations))
+	for _, station := range r.stations {
+		s := *station
+		stations = append(stations, &s)
+	}
+	slices.SortFunc(stations, func(a, b *model.Station) int { return cmp.Compare(a.ID, b.ID) })
+	return stations, nil
+}
+
+func (r *MemoryRepository) GetCheckpoint(ctx context.Context, id string) (*model.IngestCheckpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	checkpoint, ok := r.checkpoints[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *checkpoint
+	return &found, nil
+}
+
+func (r *MemoryRepository) SaveCheckpoint(ctx context.Context, checkpoint *model.IngestCheckpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *checkpoint
+	r.checkpoints[checkpoint.ID] = &stored
+	return nil
+}
+
+func (r *MemoryRepository) ClaimIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) > idempotencySweepInterval {
+		maps.DeleteFunc(r.idempotency, func(_ string, stored *model.IdempotencyRecord) bool {
+			return stored.Expired(now)
+		})
+		r.lastSweep = now
+	}
+
+	if existing, ok := r.idempotency[record.Key]; ok && !existing.Expired(now) {
+		found := *existing
+		return &found, nil
+	}
+	stored := *record
+	r.idempotency[record.Key] = &stored
+	return nil, nil
+}
+
+func (r *MemoryRepository) SaveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *record
+	r.idempotency[record.Key] = &stored
+	return nil
+}
+
+func (r *MemoryRepository) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.idempotency, key)
+	return nil
+}
+
+func (r *MemoryRepository) CloseConnection(ctx context.Context) error {
+	return nil
+}
+
+func (r *MemoryRepository) SaveAPIKey(ctx context.Context, key *model.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apiKeys[key.ID] = cloneAPIKey(key)
+	return nil
+}
+
+func (r *MemoryRepository) GetAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneAPIKey(key), nil
+}
+
+func (r *MemoryRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.apiKeys {
+		if key.Hash == hash {
+			return cloneAPIKey(key), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) ListAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*model.APIKey, 0, len(r.apiKeys))
+	for _, key := range r.apiKeys {
+		keys = append(keys, cloneAPIKey(key))
+	}
+	slices.SortFunc(keys, func(a, b *model.APIKey) int { return cmp.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+// cloneAPIKey copies a key so that callers cannot change the stored one
+func cloneAPIKey(key *model.APIKey) *model.APIKey {
+	c := *key
+	c.Scopes = slices.Clone(key.Scopes)
+	return &c
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -11,19 +13,35 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 
 	"maps"
+	"slices"
 
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
 )
 
 // Index metadata constants
 const (
-	dateIndexName = "date_1"
+	// legacy unique index allowing a single reading per day, dropped on startup
+	legacyDateIndexName  = "date_1"
+	stationDateIndexName = "station_1_date_1"
+	// serves unscoped range queries and keyset pagination ordered by (date, station)
+	dateStationIndexName = "date_1_station_1"
 )
 
 type MongoDBRepository struct {
 	client     *mongo.Client
 	database   *mongo.Database
 	collection *mongo.Collection
+	stations   *mongo.Collection
+	// rows rejected by lenient file ingestion
+	deadLetters *mongo.Collection
+	// progress of file ingestion runs
+	checkpoints *mongo.Collection
+	// responses of requests sent with an Idempotency-Key, removed by a TTL index
+	idempotency *mongo.Collection
+	// revision history of readings, see BulkOptions.History
+	revisions *mongo.Collection
+	// hashed API keys
+	apiKeys *mongo.Collection
 }
 
 func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
@@ -49,8 +67,29 @@ func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
 	return client, nil
 }
 
-func NewMongoDBRepository(client *mongo.Client) *MongoDBRepository {
-	db := client.Database("oofone-se-take-home")
+// default database of the service, WithDatabase selects another one
+const defaultDatabase = "oofone-se-take-home"
+
+// MongoOption customises a MongoDBRepository at construction time
+type MongoOption func(*mongoSettings)
+
+type mongoSettings struct {
+	database string
+}
+
+// WithDatabase stores everything in the named database, e.g. a throwaway database of a test
+func WithDatabase(name string) MongoOption {
+	return func(s *mongoSettings) {
+		s.database = name
+	}
+}
+
+func NewMongoDBRepository(client *mongo.Client, opts ...MongoOption) *MongoDBRepository {
+	settings := mongoSettings{database: defaultDatabase}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	db := client.Database(settings.database)
 	col := db.Collection("weather_data")
 
 	// ensure indexes with existence check to improve performance in case of large datasets
@@ -60,10 +99,49 @@ func NewMongoDBRepository(client *mongo.Client) *MongoDBRepository {
 
 	ensureIndexes(ctx, col)
 
+	idempotency := db.Collection("idempotency_keys")
+	ensureTTLIndex(ctx, idempotency, "expiresAt")
+
+	// history lookups and as-of queries read the changes of a date range
+	revisions := db.Collection("weather_revisions")
+	if _, err := revisions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "date", Value: 1}, {Key: "station", Value: 1}, {Key: "ingestedAt", Value: 1}},
+	}); err != nil {
+		fmt.Printf("Failed to create index on %s: %v\n", revisions.Name(), err)
+	}
+
+	// keys are looked up by the hash of the presented secret
+	apiKeys := db.Collection("api_keys")
+	if _, err := apiKeys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		fmt.Printf("Failed to create index on %s: %v\n", apiKeys.Name(), err)
+	}
+
 	return &MongoDBRepository{
 		client:     client,
 		database:   db,
 		collection: col,
+		stations:   db.Collection("stations"),
+
+		deadLetters: db.Collection("dead_letters"),
+		checkpoints: db.Collection("ingest_runs"),
+		idempotency: idempotency,
+		revisions:   revisions,
+		apiKeys:     apiKeys,
+	}
+}
+
+// ensureTTLIndex lets MongoDB delete documents once the time in field has passed
+// creating an index that already exists with the same options is a no-op
+func ensureTTLIndex(ctx context.Context, col *mongo.Collection, field string) {
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		fmt.Printf("Failed to create TTL index on %s.%s: %v\n", col.Name(), field, err)
 	}
 }
 
@@ -77,7 +155,7 @@ func ensureIndexes(ctx context.Context, col *mongo.Collection) {
 	}
 	defer cursor.Close(ctx)
 
-	var indexExists bool
+	existing := make(map[string]bool)
 	for cursor.Next(ctx) {
 		var index bson.M
 		if err := cursor.Decode(&index); err != nil {
@@ -85,18 +163,25 @@ func ensureIndexes(ctx context.Context, col *mongo.Collection) {
 			continue
 		}
 
-		if name, ok := index["name"].(string); ok && name == dateIndexName {
-			indexExists = true
-			break
+		if name, ok := index["name"].(string); ok {
+			existing[name] = true
+		}
+	}
+
+	// the old unique date index only allows one reading per day and would reject sub-daily data
+	if existing[legacyDateIndexName] {
+		if err := indexView.DropOne(ctx, legacyDateIndexName); err != nil {
+			fmt.Printf("Failed to drop legacy index: %v\n", err)
 		}
 	}
 
 	// create index if it doesn't exist and set unique constraint
-	if !indexExists {
+	// station-scoped queries use the full key, unscoped date queries still filter on it
+	if !existing[stationDateIndexName] {
 		_, err := indexView.CreateOne(ctx, mongo.IndexModel{
-			Keys: bson.D{{Key: "date", Value: 1}},
+			Keys: bson.D{{Key: "station", Value: 1}, {Key: "date", Value: 1}},
 			Options: options.Index().
-				SetName(dateIndexName).
+				SetName(stationDateIndexName).
 				SetUnique(true),
 		})
 
@@ -104,11 +189,24 @@ func ensureIndexes(ctx context.Context, col *mongo.Collection) {
 			fmt.Printf("Failed to create index: %v\n", err)
 		}
 	}
+
+	if !existing[dateStationIndexName] {
+		_, err := indexView.CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "date", Value: 1}, {Key: "station", Value: 1}},
+			Options: options.Index().SetName(dateStationIndexName),
+		})
+
+		if err != nil {
+			fmt.Printf("Failed to create index: %v\n", err)
+		}
+	}
 }
 
 // QueryOptions to provide control over projection and pagination
 // projection capability added to future-proof for data model expansion
 type QueryOptions struct {
+	Station    string  // restrict results to a single station, empty means all stations
+	After      *Cursor // keyset pagination, only return readings sorted after this position
 	Projection bson.M
 	Skip       *int64
 	Limit      *int64
@@ -119,10 +217,22 @@ type QueryOptions struct {
 func DefaultQueryOptions() *QueryOptions {
 	return &QueryOptions{
 		Projection: nil, // default to nil, no projection
-		Sort:       bson.D{{Key: "date", Value: 1}},
+		Sort:       DefaultSort(),
 	}
 }
 
+// DefaultSort orders by date with the station as tie-breaker, matching the keyset of Cursor
+func DefaultSort() bson.D {
+	return bson.D{{Key: "date", Value: 1}, {Key: "station", Value: 1}}
+}
+
+// Cursor is the position of the last reading of a page in (date, station) order
+type Cursor struct {
+	Date    time.Time
+	Station string
+}
+
+// GetByDate returns every reading recorded within the given day
 func (r *MongoDBRepository) GetByDate(
 	ctx context.Context,
 	date time.Time,
@@ -135,7 +245,7 @@ func (r *MongoDBRepository) GetByDate(
 		"date": bson.M{"$gte": start, "$lt": end}, // precise date aggregation, preferrable over $eq for performance
 	}
 
-	return r.queryWeatherData(ctx, filter, opts...)
+	return r.queryWeatherData(ctx, withStationFilter(filter, opts...), opts...)
 }
 
 func (r *MongoDBRepository) GetByDateRange(
@@ -147,7 +257,54 @@ func (r *MongoDBRepository) GetByDateRange(
 		"date": bson.M{"$gte": start, "$lte": end}, // precise date range aggregation, $lte instead of $lt as above because we are working with a range
 	}
 
-	return r.queryWeatherData(ctx, filter, opts...)
+	return r.queryWeatherData(ctx, withStationFilter(filter, opts...), opts...)
+}
+
+// scope a filter to the station requested in the query options, if any,
+// and continue after the pagination cursor using the (date, station) key
+func withStationFilter(filter bson.M, opts ...*QueryOptions) bson.M {
+	if len(opts) == 0 || opts[0] == nil {
+		return filter
+	}
+
+	if opts[0].Station != "" {
+		filter["station"] = opts[0].Station
+	}
+
+	if after := opts[0].After; after != nil {
+		if opts[0].Station != "" {
+			// a single station has at most one reading per timestamp,
+			// $and keeps the existing date range condition intact
+			filter["$and"] = bson.A{bson.M{"date": bson.M{"$gt": after.Date}}}
+		} else {
+			filter["$or"] = bson.A{
+				bson.M{"date": bson.M{"$gt": after.Date}},
+				bson.M{"date": after.Date, "station": bson.M{"$gt": after.Station}},
+			}
+		}
+	}
+	return filter
+}
+
+// CountByDateRange counts the readings in [start, end], ignoring pagination
+func (r *MongoDBRepository) CountByDateRange(
+	ctx context.Context,
+	start, end time.Time,
+	opts ...*QueryOptions,
+) (int64, error) {
+	countCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"date": bson.M{"$gte": start, "$lte": end}}
+	if len(opts) > 0 && opts[0] != nil && opts[0].Station != "" {
+		filter["station"] = opts[0].Station
+	}
+
+	count, err := r.collection.CountDocuments(countCtx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("count operation failed: %w", err)
+	}
+	return count, nil
 }
 
 func (r *MongoDBRepository) queryWeatherData(
@@ -158,7 +315,66 @@ func (r *MongoDBRepository) queryWeatherData(
 	findCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	// apply options or fallback to default
+	cursor, err := r.collection.Find(findCtx, filter, buildFindOptions(opts...))
+	if err != nil {
+		return nil, fmt.Errorf("find operation failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []*model.WeatherData
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %w", err)
+	}
+
+	return results, nil
+}
+
+// StreamByDateRange yields readings one by one straight from the cursor, so memory use does not grow with the range
+// no overall timeout is applied, the caller bounds the stream through ctx
+// breaking out of the loop closes the cursor
+func (r *MongoDBRepository) StreamByDateRange(
+	ctx context.Context,
+	start, end time.Time,
+	opts ...*QueryOptions,
+) iter.Seq2[*model.WeatherData, error] {
+	filter := bson.M{
+		"date": bson.M{"$gte": start, "$lte": end},
+	}
+
+	return r.streamWeatherData(ctx, withStationFilter(filter, opts...), opts...)
+}
+
+func (r *MongoDBRepository) streamWeatherData(
+	ctx context.Context,
+	filter bson.M,
+	opts ...*QueryOptions,
+) iter.Seq2[*model.WeatherData, error] {
+	return func(yield func(*model.WeatherData, error) bool) {
+		cursor, err := r.collection.Find(ctx, filter, buildFindOptions(opts...))
+		if err != nil {
+			yield(nil, fmt.Errorf("find operation failed: %w", err))
+			return
+		}
+		defer cursor.Close(context.WithoutCancel(ctx))
+
+		for cursor.Next(ctx) {
+			var data model.WeatherData
+			if err := cursor.Decode(&data); err != nil {
+				yield(nil, fmt.Errorf("failed to decode result: %w", err))
+				return
+			}
+			if !yield(&data, nil) {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(nil, fmt.Errorf("cursor failed: %w", err))
+		}
+	}
+}
+
+// buildFindOptions applies query options or falls back to the defaults
+func buildFindOptions(opts ...*QueryOptions) *options.FindOptionsBuilder {
 	queryOpts := DefaultQueryOptions()
 	if len(opts) > 0 && opts[0] != nil {
 		queryOpts = opts[0]
@@ -172,8 +388,9 @@ func (r *MongoDBRepository) queryWeatherData(
 		// create copy of the projection map
 		proj := bson.M{}
 		maps.Copy(proj, queryOpts.Projection)
-		// forced date field inclusion
+		// forced date and station field inclusion
 		proj["date"] = 1
+		proj["station"] = 1
 		// _id is excluded unless explicitly included
 		if _, exists := proj["_id"]; !exists {
 			proj["_id"] = 0
@@ -188,42 +405,842 @@ func (r *MongoDBRepository) queryWeatherData(
 		findOptions.SetLimit(*queryOpts.Limit)
 	}
 
-	cursor, err := r.collection.Find(findCtx, filter, findOptions)
+	return findOptions
+}
+
+// Aggregate groups readings in [start, end] into calendar buckets (UTC, weeks start on Monday)
+// and computes the requested metrics per bucket with a single aggregation pipeline
+func (r *MongoDBRepository) Aggregate(
+	ctx context.Context,
+	start, end time.Time,
+	opts AggregateOptions,
+) ([]*model.AggregateBucket, error) {
+	aggCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	match := bson.M{"date": bson.M{"$gte": start, "$lte": end}}
+	if opts.Station != "" {
+		match["station"] = opts.Station
+	}
+
+	group := bson.D{
+		{Key: "_id", Value: bson.M{"$dateTrunc": bson.M{
+			"date":        "$date",
+			"unit":        opts.Bucket,
+			"startOfWeek": "monday",
+			"timezone":    "UTC",
+		}}},
+		{Key: "count", Value: bson.M{"$sum": 1}},
+	}
+	for _, m := range opts.Metrics {
+		group = append(group, bson.E{Key: metricKey(m), Value: bson.M{"$" + m.Op: "$" + m.Field}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(aggCtx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate operation failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	buckets := []*model.AggregateBucket{}
+	for cursor.Next(aggCtx) {
+		var row bson.M
+		if err := cursor.Decode(&row); err != nil {
+			return nil, fmt.Errorf("failed to decode aggregate: %w", err)
+		}
+
+		bucketStart, ok := row["_id"].(bson.DateTime)
+		if !ok {
+			continue
+		}
+		bucket := &model.AggregateBucket{
+			Start:   bucketStart.Time().UTC(),
+			Metrics: make(map[string]map[string]*float64),
+		}
+		bucket.End = BucketEnd(bucket.Start, opts.Bucket)
+		bucket.Count = toInt64(row["count"])
+
+		for _, m := range opts.Metrics {
+			if bucket.Metrics[m.Field] == nil {
+				bucket.Metrics[m.Field] = make(map[string]*float64)
+			}
+			bucket.Metrics[m.Field][m.Op] = toFloatPtr(row[metricKey(m)])
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("aggregate cursor failed: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// group keys cannot contain dots, so field and operation are joined with underscores
+func metricKey(m Metric) string {
+	return m.Field + "__" + m.Op
+}
+
+func toInt64(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func toFloatPtr(v any) *float64 {
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case int32:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	default:
+		return nil
+	}
+	return &f
+}
+
+// BulkUpsert writes records with one BulkWrite round trip per batch
+// write errors are reported per record instead of failing the whole call
+func (r *MongoDBRepository) BulkUpsert(ctx context.Context, data []*model.WeatherData, opts BulkOptions) (*BulkResult, error) {
+	result := &BulkResult{Outcomes: make([]string, len(data))}
+	if len(data) == 0 {
+		return result, nil
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	for offset := 0; offset < len(data); offset += batchSize {
+		end := min(offset+batchSize, len(data))
+
+		failed, err := r.bulkUpsertBatch(ctx, data[offset:end], offset, opts, result)
+		if err != nil {
+			return nil, err
+		}
+		// ordered writes stop at the first failing record, later batches are never sent
+		if failed && opts.Ordered {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// bulkUpsertBatch runs a single BulkWrite and accumulates its counts into result
+// offset translates the driver's batch-relative indexes into indexes of the full input
+// batches that record history or have a station under another policy than last-write-wins go through bulkResolveBatch
+func (r *MongoDBRepository) bulkUpsertBatch(
+	ctx context.Context,
+	batch []*model.WeatherData,
+	offset int,
+	opts BulkOptions,
+	result *BulkResult,
+) (bool, error) {
+	for _, weatherData := range batch {
+		if opts.History || opts.Conflict.For(weatherData.Station) != ConflictLastWriteWins {
+			return r.bulkResolveBatch(ctx, batch, offset, opts, result)
+		}
+	}
+
+	bulkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, len(batch))
+	for i, weatherData := range batch {
+		models[i] = lastWriteWinsModel(opts.stamp(weatherData.Clone()))
+	}
+
+	res, err := r.collection.BulkWrite(bulkCtx, models, options.BulkWrite().SetOrdered(opts.Ordered))
+	if res != nil {
+		result.Matched += res.MatchedCount
+		result.Upserted += res.UpsertedCount
+		result.Modified += res.ModifiedCount
+	}
+	writeErrs, err := r.bulkWriteErrors(err)
+	if err != nil {
+		return false, err
+	}
+
+	// the driver has no per-record counts, every matched record is reported as updated
+	for i := range batch {
+		if writeErrs.failed(i, opts.Ordered) {
+			continue
+		}
+		result.Outcomes[offset+i] = OutcomeUpdated
+		if res != nil {
+			if _, ok := res.UpsertedIDs[int64(i)]; ok {
+				result.Outcomes[offset+i] = OutcomeInserted
+			}
+		}
+	}
+	for _, writeErr := range writeErrs {
+		result.Errors = append(result.Errors, BulkWriteError{Index: offset + writeErr.Index, Message: writeErr.Message})
+	}
+	return len(writeErrs) > 0, nil
+}
+
+// bulkResolveBatch loads the stored readings of a batch and applies the conflict policy of each record
+// records of the same station and timestamp are folded, each is resolved against the value the previous one left,
+// so a reading is written once per batch and every step of the fold is recorded in the history
+// new readings are inserted with $setOnInsert and recorded changes replace the stored reading only if it is unchanged,
+// so a concurrent writer is detected instead of being overwritten or missing from the history
+// all writes of the batch go out in one BulkWrite
+func (r *MongoDBRepository) bulkResolveBatch(
+	ctx context.Context,
+	batch []*model.WeatherData,
+	offset int,
+	opts BulkOptions,
+	result *BulkResult,
+) (bool, error) {
+	bulkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	existing, err := r.findReadings(bulkCtx, batch)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		writes  []*foldedWrite // in order of the first record of each reading
+		pending = make(map[readingKey]*foldedWrite)
+		history []any
+		failed  bool
+	)
+	now := time.Now()
+	for i, weatherData := range batch {
+		key := newReadingKey(weatherData.Station, weatherData.Date)
+		write := pending[key]
+		current := existing[key]
+		if write != nil {
+			current = write.store
+		}
+
+		store, outcome := resolveConflict(opts.Conflict.For(weatherData.Station), current, weatherData)
+		opts.stamp(store)
+		switch {
+		case outcome == OutcomeConflict:
+			result.Errors = append(result.Errors, conflictError(offset+i, weatherData))
+			result.Outcomes[offset+i] = outcome
+			failed = true
+		case store == nil:
+			result.Outcomes[offset+i] = outcome
+			result.count(outcome)
+		default:
+			if write == nil {
+				write = &foldedWrite{stored: existing[key]}
+				pending[key] = write
+				writes = append(writes, write)
+			}
+			write.store = store
+			write.records = append(write.records, i)
+			write.outcomes = append(write.outcomes, outcome)
+			if revision := opts.revision(outcome, current, store, now); revision != nil {
+				write.revisions = append(write.revisions, revision)
+			}
+		}
+		// ordered writes stop at the first refused record
+		if failed && opts.Ordered {
+			break
+		}
+	}
+
+	var (
+		models   []mongo.WriteModel
+		modelIdx []*foldedWrite // write of every model
+	)
+	for _, write := range writes {
+		switch {
+		case write.stored == nil:
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"station": write.store.Station, "date": write.store.Date}).
+				SetUpdate(bson.M{"$setOnInsert": write.store}).
+				SetUpsert(true))
+		case len(write.revisions) == 0:
+			models = append(models, lastWriteWinsModel(write.store))
+		default:
+			models = append(models, compareAndSwapModel(write.stored, write.store))
+		}
+		modelIdx = append(modelIdx, write)
+	}
+
+	if len(models) > 0 {
+		res, err := r.collection.BulkWrite(bulkCtx, models, options.BulkWrite().SetOrdered(opts.Ordered))
+		writeErrs, err := r.bulkWriteErrors(err)
+		if err != nil {
+			return false, err
+		}
+		for _, writeErr := range writeErrs {
+			write := modelIdx[writeErr.Index]
+			message := writeErr.Message
+			if write.swapped() && writeErr.Code == duplicateKeyCode {
+				message = "reading was written concurrently, retry"
+			}
+			write.fail(result, offset, message)
+			failed = true
+		}
+
+		for m, write := range modelIdx {
+			if writeErrs.failed(m, opts.Ordered) {
+				continue
+			}
+			if write.stored == nil && (res == nil || res.UpsertedIDs[int64(m)] == nil) {
+				// another writer stored the reading between our read and write
+				if opts.Conflict.For(write.store.Station) != ConflictFirstWriteWins {
+					write.fail(result, offset, "reading was written concurrently, retry")
+					failed = true
+					continue
+				}
+				for _, i := range write.records {
+					result.Outcomes[offset+i] = OutcomeKept
+					result.count(OutcomeKept)
+				}
+				continue
+			}
+			write.succeed(result, offset)
+			history = append(history, write.history()...)
+		}
+	}
+
+	if len(history) > 0 {
+		if _, err := r.revisions.InsertMany(bulkCtx, history); err != nil {
+			return false, fmt.Errorf("failed to record revision history: %w", err)
+		}
+	}
+	return failed, nil
+}
+
+// foldedWrite is the single write of the records of a batch that share station and timestamp
+type foldedWrite struct {
+	stored    *model.WeatherData // the reading in the database, nil if there is none
+	store     *model.WeatherData // the value left by the last record
+	records   []int              // batch index and outcome of every record that changed the reading
+	outcomes  []string
+	revisions []*model.WeatherRevision
+}
+
+// swapped reports whether the write replaces the stored reading only if it is unchanged, see compareAndSwapModel
+func (w *foldedWrite) swapped() bool {
+	return w.stored != nil && len(w.revisions) > 0
+}
+
+func (w *foldedWrite) succeed(result *BulkResult, offset int) {
+	for j, i := range w.records {
+		result.Outcomes[offset+i] = w.outcomes[j]
+		result.count(w.outcomes[j])
+	}
+}
+
+func (w *foldedWrite) fail(result *BulkResult, offset int, message string) {
+	for _, i := range w.records {
+		result.Errors = append(result.Errors, BulkWriteError{Index: offset + i, Message: message})
+	}
+}
+
+func (w *foldedWrite) history() []any {
+	history := make([]any, len(w.revisions))
+	for j, revision := range w.revisions {
+		history[j] = revision
+	}
+	return history
+}
+
+// lastWriteWinsModel merges a reading into the stored one like $set
+func lastWriteWinsModel(data *model.WeatherData) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"station": data.Station, "date": data.Date}).
+		SetUpdate(bson.M{"$set": data}).
+		SetUpsert(true)
+}
+
+// findReadings loads the stored readings with the same station and timestamp as the records of batch
+func (r *MongoDBRepository) findReadings(ctx context.Context, batch []*model.WeatherData) (map[readingKey]*model.WeatherData, error) {
+	keys := make(bson.A, len(batch))
+	for i, weatherData := range batch {
+		keys[i] = bson.M{"station": weatherData.Station, "date": weatherData.Date}
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"$or": keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored readings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var stored []*model.WeatherData
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode stored readings: %w", err)
+	}
+	existing := make(map[readingKey]*model.WeatherData, len(stored))
+	for _, data := range stored {
+		existing[newReadingKey(data.Station, data.Date)] = data
+	}
+	return existing, nil
+}
+
+// duplicateKeyCode is the server error of a write that collides with a unique index
+const duplicateKeyCode = 11000
+
+// compareAndSwapModel replaces superseded with store if the stored document still has the values of superseded
+// the filter includes the _id, so if another writer changed the reading the upsert collides with it
+// and fails with duplicateKeyCode, which reports the swap per record within a BulkWrite
+// a reading deleted in the meantime is stored again
+func compareAndSwapModel(superseded, store *model.WeatherData) mongo.WriteModel {
+	filter := bson.M{"_id": superseded.ID}
+	for field, v := range superseded.Values {
+		filter[field] = v
+	}
+	return mongo.NewReplaceOneModel().
+		SetFilter(filter).
+		SetReplacement(store).
+		SetUpsert(true)
+}
+
+func (r *MongoDBRepository) ReplaceReading(ctx context.Context, data *model.WeatherData, opts BulkOptions) (string, error) {
+	writeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	existing, err := r.findReading(writeCtx, data.Station, storedDate(data.Date))
+	if err != nil {
+		return "", err
+	}
+	store, outcome := replacedReading(existing, data)
+	if outcome == OutcomeUnchanged {
+		return outcome, nil
+	}
+	opts.stamp(store)
+	filter := bson.M{"station": store.Station, "date": store.Date}
+	if _, err := r.collection.ReplaceOne(writeCtx, filter, store, options.Replace().SetUpsert(true)); err != nil {
+		return "", fmt.Errorf("failed to replace reading: %w", err)
+	}
+	if err := r.recordRevisions(writeCtx, opts.revision(outcome, existing, store, time.Now())); err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
+
+func (r *MongoDBRepository) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts BulkOptions) (*model.WeatherData, error) {
+	writeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	existing, err := r.findReading(writeCtx, station, storedDate(date))
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, ErrNotFound
+	}
+	patched, changed := patchedReading(existing, values)
+	if !changed {
+		patched.Date = patched.Date.UTC()
+		return patched, nil
+	}
+
+	opts.stamp(patched)
+	set, unset := bson.M{"ingestedBy": patched.IngestedBy}, bson.M{}
+	for field, v := range values {
+		if v == nil {
+			unset[field] = ""
+			continue
+		}
+		set[field] = v
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	res, err := r.collection.UpdateOne(writeCtx, bson.M{"_id": existing.ID}, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch reading: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrNotFound
+	}
+	if err := r.recordRevisions(writeCtx, opts.revision(OutcomeUpdated, existing, patched, time.Now())); err != nil {
+		return nil, err
+	}
+	patched.Date = patched.Date.UTC()
+	return patched, nil
+}
+
+func (r *MongoDBRepository) DeleteReadings(ctx context.Context, start, end time.Time, station string, opts BulkOptions) ([]*model.WeatherData, error) {
+	writeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"date": bson.M{"$gte": start, "$lte": end}}
+	if station != "" {
+		filter["station"] = station
+	}
+	cursor, err := r.collection.Find(writeCtx, filter, options.Find().SetSort(DefaultSort()))
+	if err != nil {
+		return nil, fmt.Errorf("find operation failed: %w", err)
+	}
+	deleted := []*model.WeatherData{}
+	if err := cursor.All(writeCtx, &deleted); err != nil {
+		return nil, fmt.Errorf("failed to decode readings: %w", err)
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+
+	ids := make(bson.A, len(deleted))
+	for i, data := range deleted {
+		ids[i] = data.ID
+	}
+	if _, err := r.collection.DeleteMany(writeCtx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return nil, fmt.Errorf("failed to delete readings: %w", err)
+	}
+
+	now := time.Now()
+	history := make([]*model.WeatherRevision, 0, len(deleted))
+	for _, data := range deleted {
+		data.Date = data.Date.UTC()
+		if revision := opts.revision(OutcomeDeleted, data, nil, now); revision != nil {
+			history = append(history, revision)
+		}
+	}
+	if err := r.recordRevisions(writeCtx, history...); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// findReading loads the stored reading of station at date, nil if there is none
+func (r *MongoDBRepository) findReading(ctx context.Context, station string, date time.Time) (*model.WeatherData, error) {
+	existing := &model.WeatherData{}
+	err := r.collection.FindOne(ctx, bson.M{"station": station, "date": date}).Decode(existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored reading: %w", err)
+	}
+	return existing, nil
+}
+
+// recordRevisions writes changes to the revision history, nil revisions are ignored
+func (r *MongoDBRepository) recordRevisions(ctx context.Context, revisions ...*model.WeatherRevision) error {
+	history := slices.DeleteFunc(revisions, func(revision *model.WeatherRevision) bool { return revision == nil })
+	if len(history) == 0 {
+		return nil
+	}
+	if _, err := r.revisions.InsertMany(ctx, history); err != nil {
+		return fmt.Errorf("failed to record revision history: %w", err)
+	}
+	return nil
+}
+
+// bulkWriteErrors extracts the per-record errors of a BulkWrite, any other error is returned as is
+func (r *MongoDBRepository) bulkWriteErrors(err error) (mongoWriteErrors, error) {
+	if err == nil {
+		return nil, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, fmt.Errorf("bulk upsert into collection '%s' failed: %w", r.collection.Name(), err)
+	}
+	return mongoWriteErrors(bulkErr.WriteErrors), nil
+}
+
+type mongoWriteErrors []mongo.BulkWriteError
+
+// failed reports whether the model at index i was not written, ordered writes skip everything after an error
+func (errs mongoWriteErrors) failed(i int, ordered bool) bool {
+	for _, writeErr := range errs {
+		if writeErr.Index == i || (ordered && i > writeErr.Index) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MongoDBRepository) ListRevisions(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"date": bson.M{"$gte": start, "$lte": end}}
+	if station != "" {
+		filter["station"] = station
+	}
+	sort := bson.D{{Key: "date", Value: 1}, {Key: "station", Value: 1}, {Key: "ingestedAt", Value: 1}, {Key: "_id", Value: 1}}
+	cursor, err := r.revisions.Find(findCtx, filter, options.Find().SetSort(sort))
 	if err != nil {
 		return nil, fmt.Errorf("find operation failed: %w", err)
 	}
 	defer cursor.Close(ctx)
 
-	var results []*model.WeatherData
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, fmt.Errorf("failed to decode results: %w", err)
+	revisions := []*model.WeatherRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, fmt.Errorf("failed to decode revisions: %w", err)
 	}
+	return revisions, nil
+}
 
-	return results, nil
+func (r *MongoDBRepository) UpsertStation(ctx context.Context, station *model.Station) error {
+	upsertCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.stations.ReplaceOne(upsertCtx, bson.M{"_id": station.ID}, station, opts); err != nil {
+		return fmt.Errorf("failed to upsert station '%s': %w", station.ID, err)
+	}
+	return nil
 }
 
-func (r *MongoDBRepository) InsertWeatherData(ctx context.Context, data any) error {
-	insertCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+func (r *MongoDBRepository) GetStation(ctx context.Context, id string) (*model.Station, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// mormalize date to midnight UTC for consistency
-	if weatherData, ok := data.(*model.WeatherData); ok {
-		weatherData.Date = time.Date(
-			weatherData.Date.Year(),
-			weatherData.Date.Month(),
-			weatherData.Date.Day(),
-			0, 0, 0, 0,
-			time.UTC,
-		)
-		filter := bson.M{"date": weatherData.Date}
-		update := bson.M{"$set": weatherData}
-		opts := options.UpdateOne().SetUpsert(true)
-		if _, err := r.collection.UpdateOne(insertCtx, filter, update, opts); err != nil {
-			return fmt.Errorf("failed to upsert data into collection '%s': %w", r.collection.Name(), err)
+	var station model.Station
+	if err := r.stations.FindOne(findCtx, bson.M{"_id": id}).Decode(&station); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
 		}
+		return nil, fmt.Errorf("failed to find station '%s': %w", id, err)
+	}
+	return &station, nil
+}
+
+func (r *MongoDBRepository) ListStations(ctx context.Context) ([]*model.Station, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	cursor, err := r.stations.Find(findCtx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("find operation failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	stations := []*model.Station{}
+	if err := cursor.All(ctx, &stations); err != nil {
+		return nil, fmt.Errorf("failed to decode stations: %w", err)
+	}
+	return stations, nil
+}
+
+func (r *MongoDBRepository) SaveAPIKey(ctx context.Context, key *model.APIKey) error {
+	saveCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.apiKeys.ReplaceOne(saveCtx, bson.M{"_id": key.ID}, key, opts); err != nil {
+		return fmt.Errorf("failed to save API key '%s': %w", key.ID, err)
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) GetAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	return r.findAPIKey(ctx, bson.M{"_id": id})
+}
+
+func (r *MongoDBRepository) FindAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	return r.findAPIKey(ctx, bson.M{"hash": hash})
+}
+
+func (r *MongoDBRepository) findAPIKey(ctx context.Context, filter bson.M) (*model.APIKey, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var key model.APIKey
+	if err := r.apiKeys.FindOne(findCtx, filter).Decode(&key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find API key: %w", err)
+	}
+	return &key, nil
+}
+
+func (r *MongoDBRepository) ListAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	cursor, err := r.apiKeys.Find(findCtx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("find operation failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*model.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode API keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *MongoDBRepository) WriteDeadLetters(ctx context.Context, lines []*model.RejectedLine) error {
+	if len(lines) == 0 {
 		return nil
 	}
-	return fmt.Errorf("invalid data type, expected *model.WeatherData")
+
+	insertCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	if _, err := r.deadLetters.InsertMany(insertCtx, lines); err != nil {
+		return fmt.Errorf("failed to insert into collection '%s': %w", r.deadLetters.Name(), err)
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) GetCheckpoint(ctx context.Context, id string) (*model.IngestCheckpoint, error) {
+	findCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var checkpoint model.IngestCheckpoint
+	if err := r.checkpoints.FindOne(findCtx, bson.M{"_id": id}).Decode(&checkpoint); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find checkpoint '%s': %w", id, err)
+	}
+	return &checkpoint, nil
+}
+
+func (r *MongoDBRepository) SaveCheckpoint(ctx context.Context, checkpoint *model.IngestCheckpoint) error {
+	saveCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.checkpoints.ReplaceOne(saveCtx, bson.M{"_id": checkpoint.ID}, checkpoint, opts); err != nil {
+		return fmt.Errorf("failed to save checkpoint '%s': %w", checkpoint.ID, err)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey inserts the record or replaces an expired one in a single upsert
+// an unexpired record makes the upsert collide on _id, that record is returned instead
+func (r *MongoDBRepository) ClaimIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": record.Key, "expiresAt": bson.M{"$lte": time.Now()}}
+	_, err := r.idempotency.ReplaceOne(claimCtx, filter, record, options.Replace().SetUpsert(true))
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to claim idempotency key '%s': %w", record.Key, err)
+	}
+
+	var existing model.IdempotencyRecord
+	if err := r.idempotency.FindOne(claimCtx, bson.M{"_id": record.Key}).Decode(&existing); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// removed by the TTL monitor in the meantime, try again
+			return r.ClaimIdempotencyKey(ctx, record)
+		}
+		return nil, fmt.Errorf("failed to find idempotency key '%s': %w", record.Key, err)
+	}
+	return &existing, nil
+}
+
+func (r *MongoDBRepository) SaveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) error {
+	saveCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.idempotency.ReplaceOne(saveCtx, bson.M{"_id": record.Key}, record, opts); err != nil {
+		return fmt.Errorf("failed to save idempotency key '%s': %w", record.Key, err)
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	deleteCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := r.idempotency.DeleteOne(deleteCtx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("failed to delete idempotency key '%s': %w", key, err)
+	}
+	return nil
+}
+
+// WatchReadings calls fn for every change of weather_data until ctx is done, whichever instance or tool made it
+// it needs a replica set, deletions are reported with the pre-image of the reading (MongoDB 6.0 and later)
+// and skipped where none is recorded; after a dropped connection the stream resumes where it stopped
+func (r *MongoDBRepository) WatchReadings(ctx context.Context, fn func(data *model.WeatherData, deleted bool)) error {
+	enable := bson.D{
+		{Key: "collMod", Value: r.collection.Name()},
+		{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+	}
+	if err := r.database.RunCommand(ctx, enable).Err(); err != nil {
+		fmt.Printf("Failed to enable pre-images on %s, deletions are not announced: %v\n", r.collection.Name(), err)
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+	}}}}
+	var resumeToken bson.Raw
+	for {
+		opts := options.ChangeStream().
+			SetFullDocument(options.UpdateLookup).
+			SetFullDocumentBeforeChange(options.WhenAvailable)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := r.collection.Watch(ctx, pipeline, opts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", r.collection.Name(), err)
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType string             `bson:"operationType"`
+				FullDocument  *model.WeatherData `bson:"fullDocument"`
+				PreImage      *model.WeatherData `bson:"fullDocumentBeforeChange"`
+			}
+			resumeToken = stream.ResumeToken()
+			if err := stream.Decode(&change); err != nil {
+				continue
+			}
+			switch {
+			case change.OperationType == "delete" && change.PreImage != nil:
+				fn(change.PreImage, true)
+			case change.OperationType != "delete" && change.FullDocument != nil:
+				fn(change.FullDocument, false)
+			}
+		}
+		err = stream.Err()
+		stream.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil
+		}
+		fmt.Printf("Change stream on %s interrupted, resuming: %v\n", r.collection.Name(), err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// CheckWatch opens and closes a change stream on weather_data, it fails on deployments without change streams
+func (r *MongoDBRepository) CheckWatch(ctx context.Context) error {
+	stream, err := r.collection.Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		return fmt.Errorf("failed to watch %s, change streams need a replica set: %w", r.collection.Name(), err)
+	}
+	return stream.Close(ctx)
 }
 
 func (r *MongoDBRepository) CloseConnection(ctx context.Context) error {
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"iter"
 	"time"
 
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
@@ -10,8 +12,149 @@ import (
 // interface defines the behaviour in relation to the database
 
 type WeatherRepository interface {
-	InsertWeatherData(ctx context.Context, data any) error
+	BulkUpsert(ctx context.Context, data []*model.WeatherData, opts BulkOptions) (*BulkResult, error)
 	GetByDate(ctx context.Context, date time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error)
 	GetByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error)
+	CountByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) (int64, error)
+	StreamByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) iter.Seq2[*model.WeatherData, error]
+	Aggregate(ctx context.Context, start, end time.Time, opts AggregateOptions) ([]*model.AggregateBucket, error)
+	// ReplaceReading stores data as the whole reading of its station and timestamp, stored fields missing from data are removed
+	// the conflict policy of opts does not apply, the outcome is inserted, updated or unchanged
+	ReplaceReading(ctx context.Context, data *model.WeatherData, opts BulkOptions) (string, error)
+	// PatchReading sets values on the reading of station at date, nil values remove a field
+	// it returns the patched reading, ErrNotFound if there is none
+	PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts BulkOptions) (*model.WeatherData, error)
+	// DeleteReadings removes the readings with start <= date <= end, of station unless it is empty, and returns them
+	DeleteReadings(ctx context.Context, start, end time.Time, station string, opts BulkOptions) ([]*model.WeatherData, error)
 	CloseConnection(ctx context.Context) error
 }
+
+// bucket sizes supported by Aggregate
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+	BucketYear  = "year"
+)
+
+// aggregation operations supported by Aggregate
+const (
+	MetricAvg = "avg"
+	MetricMin = "min"
+	MetricMax = "max"
+	MetricSum = "sum"
+)
+
+// AggregateOptions select the bucket size and the statistics computed per bucket
+type AggregateOptions struct {
+	Station string // restrict to one station, empty aggregates all stations
+	Bucket  string
+	Metrics []Metric
+}
+
+// Metric is a single statistic, e.g. {Field: "temperature", Op: MetricAvg}
+type Metric struct {
+	Field string
+	Op    string
+}
+
+// BucketEnd returns the exclusive end of the bucket starting at start
+func BucketEnd(start time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketWeek:
+		return start.AddDate(0, 0, 7)
+	case BucketMonth:
+		return start.AddDate(0, 1, 0)
+	case BucketYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// StationRepository manages station metadata
+type StationRepository interface {
+	UpsertStation(ctx context.Context, station *model.Station) error
+	GetStation(ctx context.Context, id string) (*model.Station, error)
+	ListStations(ctx context.Context) ([]*model.Station, error)
+}
+
+// CheckpointRepository persists the progress of file ingestion runs
+type CheckpointRepository interface {
+	GetCheckpoint(ctx context.Context, id string) (*model.IngestCheckpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint *model.IngestCheckpoint) error
+}
+
+// RevisionRepository reads the history of changes recorded by BulkUpsert, see BulkOptions.History
+type RevisionRepository interface {
+	// ListRevisions returns the changes of readings in [start, end] sorted by date and station,
+	// the changes of one reading oldest first, an empty station lists every station
+	ListRevisions(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error)
+}
+
+// IdempotencyRepository stores the responses of requests sent with an Idempotency-Key
+// expired records count as absent, backends remove them eventually
+type IdempotencyRepository interface {
+	// ClaimIdempotencyKey stores record unless an unexpired record with the same key exists,
+	// in which case that record is returned and nothing is written
+	ClaimIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	SaveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+}
+
+// APIKeyRepository stores API keys, revoked and expired keys are kept so that they stay visible to admins
+type APIKeyRepository interface {
+	SaveAPIKey(ctx context.Context, key *model.APIKey) error
+	// GetAPIKey and FindAPIKeyByHash return ErrNotFound for unknown keys
+	GetAPIKey(ctx context.Context, id string) (*model.APIKey, error)
+	FindAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	// ListAPIKeys returns every key ordered by id
+	ListAPIKeys(ctx context.Context) ([]*model.APIKey, error)
+}
+
+// how often the embedded backends drop expired idempotency records, MongoDB uses a TTL index
+const idempotencySweepInterval = time.Minute
+
+// ErrNotFound is returned when a single requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// BulkOptions control how BulkUpsert splits and executes writes
+// Ordered stops at the first failing record, records after it are not written
+// unordered keeps going and reports every failure
+type BulkOptions struct {
+	BatchSize int
+	Ordered   bool
+	// what happens to readings whose station and timestamp are stored already, see ConflictPolicy
+	Conflict ConflictPolicy
+	// record every insert and update in the revision history, the revisions policy records its changes regardless
+	History bool
+	// who wrote the records, stored as the ingestedBy of every reading they change and kept in the revision history
+	Source string
+}
+
+const defaultBulkBatchSize = 500
+
+func DefaultBulkOptions() BulkOptions {
+	return BulkOptions{
+		BatchSize: defaultBulkBatchSize,
+		Ordered:   false,
+	}
+}
+
+// BulkResult summarises a bulk write
+// Errors carry the index of the failed record within the slice passed to BulkUpsert
+// Outcomes holds the outcome of every record by index, records refused by the conflict policy are OutcomeConflict, other failed or unwritten records are empty
+type BulkResult struct {
+	Matched  int64
+	Upserted int64
+	Modified int64
+	Errors   []BulkWriteError
+	Outcomes []string
+}
+
+// BulkWriteError is a record that was not written, Conflict marks refusals of the reject policy
+type BulkWriteError struct {
+	Index    int
+	Message  string
+	Conflict bool
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"iter"
+	"reflect"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+)
+
+// readings fetched per scan call, matches the cursor batch size used for MongoDB
+const scanChunkSize = 1000
+
+// sortedStore is the primitive the in-process backends implement:
+// readings kept in (date, station) order, the same order as DefaultSort and Cursor
+type sortedStore interface {
+	// scan returns up to limit readings with start <= date <= end in (date, station) order
+	// after skips every reading up to and including that position, station restricts the result when set
+	scan(ctx context.Context, start, end time.Time, after *Cursor, station string, limit int) ([]*model.WeatherData, error)
+}
+
+// sortedRepository implements the read side of WeatherRepository on top of a sortedStore
+// results are always sorted by (date, station), QueryOptions.Sort is not interpreted
+type sortedRepository struct {
+	store sortedStore
+}
+
+// GetByDate returns every reading recorded within the given day
+func (r sortedRepository) GetByDate(ctx context.Context, date time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return r.query(ctx, start, start.Add(24*time.Hour-time.Nanosecond), opts...)
+}
+
+func (r sortedRepository) GetByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error) {
+	return r.query(ctx, start, end, opts...)
+}
+
+func (r sortedRepository) query(ctx context.Context, start, end time.Time, opts ...*QueryOptions) ([]*model.WeatherData, error) {
+	results := []*model.WeatherData{}
+	for data, err := range r.stream(ctx, start, end, opts...) {
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// CountByDateRange counts the readings in [start, end], ignoring pagination
+func (r sortedRepository) CountByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) (int64, error) {
+	var station string
+	if len(opts) > 0 && opts[0] != nil {
+		station = opts[0].Station
+	}
+
+	var count int64
+	var after *Cursor
+	for {
+		chunk, err := r.store.scan(ctx, start, end, after, station, scanChunkSize)
+		if err != nil {
+			return 0, err
+		}
+		count += int64(len(chunk))
+		if len(chunk) < scanChunkSize {
+			return count, nil
+		}
+		last := chunk[len(chunk)-1]
+		after = &Cursor{Date: last.Date, Station: last.Station}
+	}
+}
+
+// StreamByDateRange yields readings in chunks, no lock or transaction is held while the caller consumes them
+func (r sortedRepository) StreamByDateRange(ctx context.Context, start, end time.Time, opts ...*QueryOptions) iter.Seq2[*model.WeatherData, error] {
+	return r.stream(ctx, start, end, opts...)
+}
+
+func (r sortedRepository) stream(ctx context.Context, start, end time.Time, opts ...*QueryOptions) iter.Seq2[*model.WeatherData, error] {
+	queryOpts := DefaultQueryOptions()
+	if len(opts) > 0 && opts[0] != nil {
+		queryOpts = opts[0]
+	}
+
+	return func(yield func(*model.WeatherData, error) bool) {
+		var skip, remaining int64 = 0, -1
+		if queryOpts.Skip != nil {
+			skip = *queryOpts.Skip
+		}
+		if queryOpts.Limit != nil && *queryOpts.Limit > 0 {
+			remaining = *queryOpts.Limit
+		}
+
+		after := queryOpts.After
+		for remaining != 0 {
+			chunk, err := r.store.scan(ctx, start, end, after, queryOpts.Station, scanChunkSize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, data := range chunk {
+				if skip > 0 {
+					skip--
+					continue
+				}
+				if !yield(project(data, queryOpts.Projection), nil) {
+					return
+				}
+				if remaining > 0 {
+					remaining--
+					if remaining == 0 {
+						return
+					}
+				}
+			}
+
+			if len(chunk) < scanChunkSize {
+				return
+			}
+			last := chunk[len(chunk)-1]
+			after = &Cursor{Date: last.Date, Station: last.Station}
+		}
+	}
+}
+
+// project keeps date, station and the value fields included by a MongoDB style projection
+func project(data *model.WeatherData, projection map[string]any) *model.WeatherData {
+	if projection == nil {
+		return data
+	}
+
+	projected := &model.WeatherData{Station: data.Station, Date: data.Date, Values: make(map[string]any)}
+	for field, v := range data.Values {
+		if include, ok := projection[field]; ok && include != 0 {
+			projected.Values[field] = v
+		}
+	}
+	return projected
+}
+
+// Aggregate groups readings in [start, end] into calendar buckets (UTC, weeks start on Monday)
+// statistics follow MongoDB's accumulators: non-numeric values are ignored, sums of nothing are 0
+func (r sortedRepository) Aggregate(ctx context.Context, start, end time.Time, opts AggregateOptions) ([]*model.AggregateBucket, error) {
+	type accumulator struct {
+		sum, min, max float64
+		n             int64
+	}
+
+	buckets := []*model.AggregateBucket{}
+	var current *model.AggregateBucket
+	var acc map[Metric]*accumulator
+
+	// readings arrive in date order, so each bucket is complete once the next one starts
+	finish := func() {
+		if current == nil {
+			return
+		}
+		for _, m := range opts.Metrics {
+			if current.Metrics[m.Field] == nil {
+				current.Metrics[m.Field] = make(map[string]*float64)
+			}
+			a := acc[m]
+			var v *float64
+			switch {
+			case m.Op == MetricSum:
+				v = &a.sum
+			case a.n == 0:
+			case m.Op == MetricAvg:
+				avg := a.sum / float64(a.n)
+				v = &avg
+			case m.Op == MetricMin:
+				v = &a.min
+			case m.Op == MetricMax:
+				v = &a.max
+			}
+			current.Metrics[m.Field][m.Op] = v
+		}
+		buckets = append(buckets, current)
+	}
+
+	for data, err := range r.stream(ctx, start, end, &QueryOptions{Station: opts.Station}) {
+		if err != nil {
+			return nil, err
+		}
+
+		bucketStart := TruncateToBucket(data.Date, opts.Bucket)
+		if current == nil || !current.Start.Equal(bucketStart) {
+			finish()
+			current = &model.AggregateBucket{
+				Start:   bucketStart,
+				End:     BucketEnd(bucketStart, opts.Bucket),
+				Metrics: make(map[string]map[string]*float64),
+			}
+			acc = make(map[Metric]*accumulator, len(opts.Metrics))
+			for _, m := range opts.Metrics {
+				acc[m] = &accumulator{}
+			}
+		}
+
+		current.Count++
+		for _, m := range opts.Metrics {
+			f, ok := data.Float(m.Field)
+			if !ok {
+				continue
+			}
+			a := acc[m]
+			if a.n == 0 || f < a.min {
+				a.min = f
+			}
+			if a.n == 0 || f > a.max {
+				a.max = f
+			}
+			a.sum += f
+			a.n++
+		}
+	}
+	finish()
+
+	return buckets, nil
+}
+
+// TruncateToBucket returns the start of the calendar bucket containing t, in UTC
+func TruncateToBucket(t time.Time, bucket string) time.Time {
+	day := model.TruncateToDay(t.UTC())
+	switch bucket {
+	case BucketWeek:
+		// Go weeks start on Sunday, shift so that Monday is day 0
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case BucketMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case BucketYear:
+		return time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// storedDate normalises a timestamp the way BSON stores it: UTC with millisecond precision
+// keeping the same precision as MongoDB keeps continuation tokens exact across backends
+func storedDate(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// mergeReading applies the upsert semantics of MongoDB's $set:
+// fields of the update replace stored ones, fields missing from the update are kept
+func mergeReading(existing, update *model.WeatherData) (*model.WeatherData, bool) {
+	merged := update.Clone()
+	merged.ID = nil
+	merged.Date = storedDate(update.Date)
+	if merged.Values == nil {
+		merged.Values = make(map[string]any)
+	}
+	if existing == nil {
+		return merged, true
+	}
+
+	for field, v := range existing.Values {
+		if _, ok := merged.Values[field]; !ok {
+			merged.Values[field] = v
+		}
+	}
+	return merged, !reflect.DeepEqual(existing.Values, merged.Values)
+}
+
+// replacedReading returns data as it is stored when it replaces existing, nil if there is none,
+// and the outcome of the replacement
+func replacedReading(existing, data *model.WeatherData) (*model.WeatherData, string) {
+	store := data.Clone()
+	store.ID = nil
+	store.Date = storedDate(data.Date)
+	if store.Values == nil {
+		store.Values = make(map[string]any)
+	}
+	switch {
+	case existing == nil:
+		return store, OutcomeInserted
+	case reflect.DeepEqual(existing.Values, store.Values):
+		return store, OutcomeUnchanged
+	}
+	return store, OutcomeUpdated
+}
+
+// patchedReading applies values to existing like $set, nil values remove a field like $unset
+func patchedReading(existing *model.WeatherData, values map[string]any) (*model.WeatherData, bool) {
+	patched := existing.Clone()
+	patched.ID = nil
+	if patched.Values == nil {
+		patched.Values = make(map[string]any)
+	}
+	for field, v := range values {
+		if v == nil {
+			delete(patched.Values, field)
+			continue
+		}
+		patched.Values[field] = v
+	}
+	return patched, !reflect.DeepEqual(existing.Values, patched.Values)
+}
+
+// compareKey orders readings by (date, station)
+func compareKey(date time.Time, station string, c Cursor) int {
+	if cmp := date.Compare(c.Date); cmp != 0 {
+		return cmp
+	}
+	switch {
+	case station < c.Station:
+		return -1
+	case station > c.Station:
+		return 1
+	}
+	return 0
+}
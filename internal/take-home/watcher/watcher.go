@@ -0,0 +1,231 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"go.uber.org/zap"
+)
+
+const defaultInterval = time.Second
+
+// Watcher ingests files dropped into an inbox directory and follows files that grow in place
+// inbox files are ingested once their size stopped changing, then moved to the done or failed directory
+type Watcher struct {
+	ingestSvc service.IngestServiceInterface
+	logger    *zap.Logger
+
+	inbox    string
+	done     string
+	failed   string
+	follow   []string
+	interval time.Duration
+
+	// inbox files seen on the previous scan, a file is picked up once it looks the same twice
+	seen map[string]os.FileInfo
+}
+
+// Option customises a Watcher at construction time
+type Option func(*Watcher)
+
+// WithInbox watches dir for new files, processed files go to dir/done and dir/failed unless configured otherwise
+func WithInbox(dir string) Option {
+	return func(w *Watcher) {
+		w.inbox = dir
+	}
+}
+
+// WithDoneDir sets where successfully ingested inbox files are moved
+func WithDoneDir(dir string) Option {
+	return func(w *Watcher) {
+		w.done = dir
+	}
+}
+
+// WithFailedDir sets where inbox files that could not be ingested are moved
+func WithFailedDir(dir string) Option {
+	return func(w *Watcher) {
+		w.failed = dir
+	}
+}
+
+// WithFollow tails the given files like tail -F
+func WithFollow(paths ...string) Option {
+	return func(w *Watcher) {
+		w.follow = append(w.follow, paths...)
+	}
+}
+
+// WithInterval sets how often the inbox and followed files are polled
+func WithInterval(d time.Duration) Option {
+	return func(w *Watcher) {
+		if d > 0 {
+			w.interval = d
+		}
+	}
+}
+
+func New(ingestSvc service.IngestServiceInterface, logger *zap.Logger, opts ...Option) *Watcher {
+	w := &Watcher{
+		ingestSvc: ingestSvc,
+		logger:    logger.Named("watcher"),
+		interval:  defaultInterval,
+		seen:      make(map[string]os.FileInfo),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.inbox != "" {
+		if w.done == "" {
+			w.done = filepath.Join(w.inbox, "done")
+		}
+		if w.failed == "" {
+			w.failed = filepath.Join(w.inbox, "failed")
+		}
+	}
+	return w
+}
+
+// Run polls the inbox and follows files until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, path := range w.follow {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.followFile(ctx, path)
+		}()
+	}
+
+	if w.inbox != "" {
+		w.watchInbox(ctx)
+	}
+	wg.Wait()
+}
+
+func (w *Watcher) watchInbox(ctx context.Context) {
+	w.logger.Info("Watching inbox", zap.String("inbox", w.inbox), zap.String("done", w.done), zap.String("failed", w.failed))
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.scanInbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// followFile keeps following a file, a failing follow is retried after a pause
+func (w *Watcher) followFile(ctx context.Context, path string) {
+	w.logger.Info("Following file", zap.String("file", path))
+	for {
+		err := w.ingestSvc.FollowFile(ctx, path, &service.FileOptions{PollInterval: w.interval})
+		if ctx.Err() != nil {
+			return
+		}
+		w.logger.Error("Following file failed, retrying", zap.String("file", path), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * w.interval):
+		}
+	}
+}
+
+// scanInbox ingests every inbox file whose size and modification time did not change since the last scan
+func (w *Watcher) scanInbox(ctx context.Context) {
+	entries, err := os.ReadDir(w.inbox)
+	if err != nil {
+		w.logger.Warn("Failed to read inbox", zap.String("inbox", w.inbox), zap.Error(err))
+		return
+	}
+
+	current := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || ignored(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(w.inbox, entry.Name())
+		current[path] = info
+
+		prev, ok := w.seen[path]
+		if !ok || prev.Size() != info.Size() || !prev.ModTime().Equal(info.ModTime()) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		w.processFile(ctx, path)
+		delete(current, path)
+	}
+	w.seen = current
+}
+
+// hidden files and files still being written under a temporary name are left alone
+func ignored(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part")
+}
+
+func (w *Watcher) processFile(ctx context.Context, path string) {
+	summary, err := w.ingestSvc.IngestFile(ctx, path)
+	if ctx.Err() != nil {
+		// interrupted by shutdown, the checkpoint lets the next start resume the file
+		return
+	}
+
+	target := w.done
+	if err != nil {
+		target = w.failed
+		w.logger.Error("Failed to ingest inbox file", zap.String("file", path), zap.Error(err))
+	} else if summary.AlreadyIngested {
+		w.logger.Info("Inbox file was already ingested", zap.String("file", path))
+	} else {
+		w.logger.Info("Ingested inbox file",
+			zap.String("file", path),
+			zap.Int("accepted", summary.Accepted),
+			zap.Int("rejected", summary.Rejected),
+			zap.Int("skipped", summary.Skipped),
+		)
+	}
+
+	dest, moveErr := moveFile(path, target)
+	if moveErr != nil {
+		w.logger.Error("Failed to move inbox file", zap.String("file", path), zap.Error(moveErr))
+		return
+	}
+	w.logger.Debug("Moved inbox file", zap.String("from", path), zap.String("to", dest))
+}
+
+// moveFile moves path into dir, a name that is already taken gets a timestamp prefix
+func moveFile(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	dest := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		dest = filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000000000")+"-"+filepath.Base(path))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("failed to move %s: %w", path, err)
+	}
+	return dest, nil
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHTTPHandler_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	apiKeys := service.NewAPIKeyService(repo)
+	require.NoError(t, apiKeys.EnsureKey(ctx, "admin", "root-secret", []string{model.ScopeAdmin}))
+
+	ingestSvc := service.NewIngestService(repo, service.WithBulkOptions(storage.BulkOptions{History: true}))
+	querySvc := service.NewQueryService(repo, service.WithRevisions(repo))
+	wsHub := &MockWebSocketHub{}
+	wsHub.On("HandleStream", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(http.ResponseWriter).WriteHeader(http.StatusNoContent)
+	}).Return()
+	stationSvc := &MockStationService{}
+	stationSvc.On("GetStation", mock.Anything, "unknown").Return(nil, fmt.Errorf("station lookup failed: %w", storage.ErrNotFound))
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(ingestSvc, querySvc, stationSvc, wsHub, zap.NewNop(), handler.WithAPIKeys(apiKeys)).RegisterRoutes(router)
+
+	send := func(method, path, secret, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type createdKey struct {
+		ID        string     `json:"id"`
+		Scopes    []string   `json:"scopes"`
+		Secret    string     `json:"secret"`
+		RevokedAt *time.Time `json:"revokedAt"`
+	}
+	create := func(body string) createdKey {
+		w := send("POST", "/api/v1/admin/keys", "root-secret", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var key createdKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&key))
+		require.NotEmpty(t, key.Secret)
+		return key
+	}
+	const reading = `{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`
+
+	ingester := create(`{"name":"gateway","scopes":["ingest"]}`)
+	reader := create(`{"name":"dashboard","scopes":["read"]}`)
+
+	t.Run("routes require a key", func(t *testing.T) {
+		w := send("GET", "/api/v1/weather/2023-01-01", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+		w = send("GET", "/api/v1/weather/2023-01-01", "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("scopes are enforced per route", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("POST", "/api/v1/weather", reader.Secret, reading).Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", "/api/v1/weather/2023-01-01", ingester.Secret, "").Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", "/api/v1/admin/keys", ingester.Secret, "").Code)
+
+		assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/weather", ingester.Secret, reading).Code)
+		assert.Equal(t, http.StatusOK, send("GET", "/api/v1/weather/2023-01-01", reader.Secret, "").Code)
+		assert.Equal(t, http.StatusOK, send("GET", "/api/v1/weather/2023-01-01", "root-secret", "").Code, "admin implies every scope")
+	})
+
+	t.Run("station routes check the key before the station", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/stations/unknown/weather/2023-01-01", "", "").Code)
+		assert.Equal(t, http.StatusForbidden, send("POST", "/api/v1/stations/unknown/weather", reader.Secret, reading).Code)
+		stationSvc.AssertNotCalled(t, "GetStation", mock.Anything, "unknown")
+
+		assert.Equal(t, http.StatusNotFound, send("GET", "/api/v1/stations/unknown/weather/2023-01-01", reader.Secret, "").Code)
+		stationSvc.AssertCalled(t, "GetStation", mock.Anything, "unknown")
+	})
+
+	t.Run("the writing key is recorded", func(t *testing.T) {
+		revisions, err := repo.ListRevisions(ctx, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), "")
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+		assert.Equal(t, service.APIKeySource(ingester.ID), revisions[0].Source)
+
+		w := send("GET", "/api/v1/weather/2023-01-01", reader.Secret, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"ingestedBy":"`+service.APIKeySource(ingester.ID)+`"`, "the reading names its writer as well")
+	})
+
+	t.Run("the key may be sent as X-API-Key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather/2023-01-01", nil)
+		req.Header.Set("X-API-Key", reader.Secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("live update routes take the key as a parameter", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send("GET", "/api/v1/weather/stream?api_key="+reader.Secret, "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/weather/stream?api_key=wrong", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/weather/2023-01-01?api_key="+reader.Secret, "", "").Code, "other routes ignore the parameter")
+		wsHub.AssertNumberOfCalls(t, "HandleStream", 1)
+	})
+
+	t.Run("rotation replaces the secret", func(t *testing.T) {
+		w := send("POST", "/api/v1/admin/keys/"+reader.ID+"/rotate", "root-secret", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var rotated createdKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&rotated))
+		assert.Equal(t, reader.ID, rotated.ID)
+		assert.Equal(t, []string{model.ScopeRead}, rotated.Scopes)
+
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/weather/2023-01-01", reader.Secret, "").Code)
+		assert.Equal(t, http.StatusOK, send("GET", "/api/v1/weather/2023-01-01", rotated.Secret, "").Code)
+	})
+
+	t.Run("revoked keys are refused", func(t *testing.T) {
+		w := send("DELETE", "/api/v1/admin/keys/"+ingester.ID, "root-secret", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, send("POST", "/api/v1/weather", ingester.Secret, reading).Code)
+
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/v1/admin/keys/"+ingester.ID+"/rotate", "root-secret", "").Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", "/api/v1/admin/keys/unknown", "root-secret", "").Code)
+	})
+
+	t.Run("expired keys are refused", func(t *testing.T) {
+		key, secret, err := apiKeys.CreateKey(ctx, service.APIKeySpec{Scopes: []string{model.ScopeRead}, ExpiresAt: ptrTime(time.Now().Add(50 * time.Millisecond))})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, send("GET", "/api/v1/weather/2023-01-01", secret, "").Code)
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/weather/2023-01-01", secret, "").Code, key.ID)
+	})
+
+	t.Run("invalid specs and listings", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/admin/keys", "root-secret", `{"scopes":["write"]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/admin/keys", "root-secret", `{"scopes":[]}`).Code)
+
+		w := send("GET", "/api/v1/admin/keys", "root-secret", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "hash")
+		assert.NotContains(t, w.Body.String(), "secret")
+
+		var keys []createdKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&keys))
+		assert.Len(t, keys, 4)
+	})
+}
+
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,310 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// eventRecorder is a publisher that keeps every event
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *eventRecorder) Publish(ctx context.Context, evs ...events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, evs...)
+	return nil
+}
+
+func (r *eventRecorder) ofType(typ string) []events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matched []events.Event
+	for _, event := range r.events {
+		if event.Type == typ {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+// subscribe collects the events of sub until the test ends
+func subscribe(t *testing.T, sub events.Subscriber) <-chan events.Event {
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan events.Event, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, sub.Subscribe(ctx, func(event events.Event) { received <- event }))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return received
+}
+
+func nextEvent(t *testing.T, received <-chan events.Event) events.Event {
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for event")
+		return events.Event{}
+	}
+}
+
+func TestIngestService_PublishesChanges(t *testing.T) {
+	ctx := context.Background()
+	published := &eventRecorder{}
+	svc := service.NewIngestService(storage.NewMemoryRepository(),
+		service.WithDefaultStation("berlin"),
+		service.WithConflictPolicy(storage.ConflictPolicy{Default: storage.ConflictReject}),
+		service.WithEvents(published, zap.NewNop()),
+	)
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	t.Run("file rows", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "weather.dat")
+		content := "# Date\tTemperature\tHumidity\n2023-01-01\t22.5\t75.5\n2023-01-02\t23.5\t76.5\n"
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+		_, err := svc.IngestFile(ctx, path)
+		require.NoError(t, err)
+		stored := published.ofType(events.TypeStored)
+		require.Len(t, stored, 2)
+		assert.Equal(t, "berlin", stored[0].Reading.Station)
+		assert.True(t, stored[1].Reading.Date.Equal(day(2)))
+	})
+
+	t.Run("writes without a change are not published", func(t *testing.T) {
+		outcome, err := svc.IngestSingle(ctx, &model.WeatherData{Date: day(1), Values: map[string]any{"temperature": 22.5, "humidity": 75.5}})
+		require.NoError(t, err)
+		assert.Equal(t, storage.OutcomeUnchanged, outcome)
+
+		result, err := svc.IngestBatch(ctx, []*model.WeatherData{
+			{Date: day(2), Values: map[string]any{"temperature": 30.0, "humidity": 76.5}},
+			{Date: day(3), Values: map[string]any{"temperature": 24.5, "humidity": 77.5}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, storage.OutcomeConflict, result.Results[0].Outcome)
+
+		stored := published.ofType(events.TypeStored)
+		require.Len(t, stored, 3)
+		assert.True(t, stored[2].Reading.Date.Equal(day(3)))
+	})
+
+	t.Run("edits and deletions", func(t *testing.T) {
+		_, err := svc.PatchReading(ctx, "berlin", day(3), map[string]any{"humidity": 80.0})
+		require.NoError(t, err)
+		stored := published.ofType(events.TypeStored)
+		require.Len(t, stored, 4)
+		assert.Equal(t, 80.0, stored[3].Reading.Values["humidity"])
+
+		_, err = svc.DeleteReadings(ctx, day(1), day(2), "berlin", true)
+		require.NoError(t, err)
+		assert.Empty(t, published.ofType(events.TypeDeleted), "dry runs delete nothing")
+
+		_, err = svc.DeleteReadings(ctx, day(1), day(2), "berlin", false)
+		require.NoError(t, err)
+		assert.Len(t, published.ofType(events.TypeDeleted), 2)
+	})
+}
+
+// failingPublisher is a backplane that is down
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, evs ...events.Event) error {
+	return errors.New("connection refused")
+}
+
+func TestIngestService_FailedPublishIsLogged(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	svc := service.NewIngestService(storage.NewMemoryRepository(), service.WithEvents(failingPublisher{}, zap.New(core)))
+
+	outcome, err := svc.IngestSingle(context.Background(), &model.WeatherData{Station: "berlin", Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 20.0, "humidity": 50.0}})
+	require.NoError(t, err, "the reading is stored, whether it is announced or not")
+	assert.Equal(t, storage.OutcomeInserted, outcome)
+
+	entries := logs.FilterMessage("Failed to publish reading changes").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(1), entries[0].ContextMap()["changes"])
+	assert.Equal(t, "connection refused", entries[0].ContextMap()["error"])
+}
+
+func TestLocalBus(t *testing.T) {
+	bus := events.NewLocalBus()
+	defer bus.Close()
+
+	first, second := subscribe(t, bus), subscribe(t, bus)
+	reading := &model.WeatherData{Station: "berlin", Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	// both subscriptions have to be registered before publishing
+	require.Eventually(t, func() bool {
+		assert.NoError(t, bus.Publish(context.Background(), events.Stored(reading)))
+		return len(first) > 0 && len(second) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, events.Stored(reading), nextEvent(t, first))
+	assert.Equal(t, events.Stored(reading), nextEvent(t, second))
+}
+
+func TestRedisBus(t *testing.T) {
+	server := miniredis.RunT(t)
+	url := "redis://" + server.Addr()
+
+	// two instances of the service, sharing the Redis server only
+	open := func() (events.Bus, handler.WebSocketHub, *httptest.Server) {
+		bus, err := events.Open(context.Background(), events.Config{Backplane: events.BackplaneRedis, RedisURL: url})
+		require.NoError(t, err)
+		t.Cleanup(func() { bus.Close() })
+
+		hub := handler.NewWebSocketHub(zap.NewNop())
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go hub.Run(ctx)
+		go bus.Subscribe(ctx, func(event events.Event) {
+			if event.Type == events.TypeDeleted {
+				hub.BroadcastDeleted(event.Reading)
+				return
+			}
+			hub.Broadcast(event.Reading)
+		})
+		srv := httptest.NewServer(http.HandlerFunc(hub.HandleStream))
+		t.Cleanup(srv.Close)
+		return bus, hub, srv
+	}
+	busA, _, _ := open()
+	_, hubB, srvB := open()
+
+	svcA := service.NewIngestService(storage.NewMemoryRepository(), service.WithEvents(busA, zap.NewNop()))
+	require.Eventually(t, func() bool { return server.PubSubNumSub(events.DefaultRedisChannel)[events.DefaultRedisChannel] == 2 },
+		time.Second, 10*time.Millisecond)
+
+	t.Run("writes on one instance reach the clients of the other", func(t *testing.T) {
+		stream := openStream(t, srvB.URL+"?stations=berlin", nil)
+		require.Eventually(t, func() bool { return hubB.Stats().Clients == 1 }, time.Second, 10*time.Millisecond)
+
+		date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		_, err := svcA.IngestSingle(context.Background(), &model.WeatherData{Station: "berlin", Date: date, Values: map[string]any{"temperature": 20.0, "humidity": 50.0}})
+		require.NoError(t, err)
+
+		ev := stream.next()
+		assert.Equal(t, "berlin", ev.data["station"])
+		assert.Equal(t, 20.0, ev.data["temperature"])
+
+		_, err = svcA.DeleteReadings(context.Background(), date, date, "berlin", false)
+		require.NoError(t, err)
+		assert.Equal(t, "deleted", stream.next().event)
+	})
+
+	t.Run("undecodable messages are skipped", func(t *testing.T) {
+		received := subscribe(t, busA)
+		require.Eventually(t, func() bool { return server.PubSubNumSub(events.DefaultRedisChannel)[events.DefaultRedisChannel] == 3 },
+			time.Second, 10*time.Millisecond)
+
+		server.Publish(events.DefaultRedisChannel, "not json")
+		reading := &model.WeatherData{Station: "oslo", Date: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+		require.NoError(t, busA.Publish(context.Background(), events.Stored(reading)))
+
+		event := nextEvent(t, received)
+		assert.Equal(t, events.TypeStored, event.Type)
+		assert.Equal(t, "oslo", event.Reading.Station)
+	})
+}
+
+func TestOpenBackplane(t *testing.T) {
+	bus, err := events.Open(context.Background(), events.Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &events.LocalBus{}, bus)
+
+	_, err = events.Open(context.Background(), events.Config{Backplane: events.BackplaneMongo})
+	assert.ErrorContains(t, err, "mongo storage backend")
+
+	_, err = events.Open(context.Background(), events.Config{Backplane: events.BackplaneRedis, RedisURL: "localhost:6379"})
+	assert.ErrorContains(t, err, "invalid Redis URL")
+
+	_, err = events.Open(context.Background(), events.Config{Backplane: "kafka"})
+	assert.ErrorContains(t, err, "unknown backplane")
+
+	t.Run("unreachable backplanes fail on open", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		url := "redis://" + server.Addr()
+		server.Close()
+		_, err := events.Open(context.Background(), events.Config{Backplane: events.BackplaneRedis, RedisURL: url})
+		assert.ErrorContains(t, err, "failed to reach Redis")
+
+		_, err = events.Open(context.Background(), events.Config{Backplane: events.BackplaneMongo, Watcher: unwatchable{}})
+		assert.ErrorContains(t, err, "replica set")
+	})
+}
+
+// unwatchable is a storage backend without change streams
+type unwatchable struct{}
+
+func (unwatchable) WatchReadings(ctx context.Context, fn func(data *model.WeatherData, deleted bool)) error {
+	return errors.New("no replica set")
+}
+
+func (unwatchable) CheckWatch(ctx context.Context) error {
+	return errors.New("change streams need a replica set")
+}
+
+// flakySubscriber fails its first subscriptions, then delivers one event and holds
+type flakySubscriber struct {
+	failures int
+	attempts atomic.Int32
+}
+
+func (s *flakySubscriber) Subscribe(ctx context.Context, fn func(events.Event)) error {
+	if int(s.attempts.Add(1)) <= s.failures {
+		return errors.New("connection refused")
+	}
+	fn(events.Stored(&model.WeatherData{Station: "berlin"}))
+	<-ctx.Done()
+	return nil
+}
+
+func TestSubscribeWithRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := &flakySubscriber{failures: 3}
+	received := make(chan events.Event, 1)
+	var waits []time.Duration
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		events.SubscribeWithRetry(ctx, sub, func(event events.Event) { received <- event }, time.Millisecond,
+			func(err error, wait time.Duration) {
+				assert.ErrorContains(t, err, "connection refused")
+				waits = append(waits, wait)
+			})
+	}()
+
+	assert.Equal(t, "berlin", nextEvent(t, received).Reading.Station)
+	cancel()
+	<-done
+	assert.Equal(t, int32(4), sub.attempts.Load())
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}, waits)
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHTTPHandler_ConflictPolicy(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	published := &eventRecorder{}
+	ingestSvc := service.NewIngestService(repo, service.WithConflictPolicy(storage.ConflictPolicy{
+		Default:  storage.ConflictLastWriteWins,
+		Stations: map[string]string{"berlin": storage.ConflictReject},
+	}), service.WithEvents(published, zap.NewNop()))
+	wsHub := &MockWebSocketHub{}
+
+	stationSvc := &MockStationService{}
+	stationSvc.On("GetStation", mock.Anything, mock.Anything).Return(&model.Station{}, nil)
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(ingestSvc, &MockQueryService{}, stationSvc, wsHub, zap.NewNop()).RegisterRoutes(router)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	const berlin = "/api/v1/stations/berlin/weather"
+
+	w := post(berlin, "application/json", `{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, storage.OutcomeInserted, w.Header().Get("Ingest-Outcome"))
+
+	t.Run("identical reading is unchanged and not published", func(t *testing.T) {
+		w := post(berlin, "application/json", `{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, storage.OutcomeUnchanged, w.Header().Get("Ingest-Outcome"))
+		assert.Len(t, published.ofType(events.TypeStored), 1)
+	})
+
+	t.Run("different values are refused with 409", func(t *testing.T) {
+		w := post(berlin, "application/json", `{"date":"2023-01-01T00:00:00Z","temperature":25,"humidity":50}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, storage.OutcomeConflict, w.Header().Get("Ingest-Outcome"))
+	})
+
+	t.Run("stations without an override use the default", func(t *testing.T) {
+		post("/api/v1/stations/oslo/weather", "application/json", `{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`)
+		w := post("/api/v1/stations/oslo/weather", "application/json", `{"date":"2023-01-01T00:00:00Z","temperature":25,"humidity":50}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, storage.OutcomeUpdated, w.Header().Get("Ingest-Outcome"))
+	})
+
+	t.Run("batch reports outcomes per record", func(t *testing.T) {
+		w := post(berlin+"/batch", "application/json", `[
+			{"date":"2023-01-01T00:00:00Z","temperature":30,"humidity":50},
+			{"date":"2023-01-02T00:00:00Z","temperature":20,"humidity":50}
+		]`)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+
+		var result service.BatchResult
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result.Results, 2)
+		assert.Equal(t, service.RecordRejected, result.Results[0].Status)
+		assert.Equal(t, storage.OutcomeConflict, result.Results[0].Outcome)
+		assert.Equal(t, service.RecordAccepted, result.Results[1].Status)
+		assert.Equal(t, storage.OutcomeInserted, result.Results[1].Outcome)
+	})
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHTTPHandler_EditReadings(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	published := &eventRecorder{}
+	ingestSvc := service.NewIngestService(repo,
+		service.WithConflictPolicy(storage.ConflictPolicy{Default: storage.ConflictReject}),
+		service.WithEvents(published, zap.NewNop()),
+	)
+	wsHub := &MockWebSocketHub{}
+
+	stationSvc := &MockStationService{}
+	stationSvc.On("GetStation", mock.Anything, mock.Anything).Return(&model.Station{}, nil)
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(ingestSvc, &MockQueryService{}, stationSvc, wsHub, zap.NewNop()).RegisterRoutes(router)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	stored := func(station string, date time.Time) *model.WeatherData {
+		data, err := repo.GetByDateRange(context.Background(), date, date, &storage.QueryOptions{Station: station})
+		require.NoError(t, err)
+		if len(data) == 0 {
+			return nil
+		}
+		return data[0]
+	}
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	const berlin = "/api/v1/stations/berlin/weather"
+
+	t.Run("put creates and replaces regardless of the conflict policy", func(t *testing.T) {
+		w := send("PUT", berlin+"/2023-01-01", `{"temperature":20,"humidity":50}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.Equal(t, storage.OutcomeInserted, w.Header().Get("Ingest-Outcome"))
+
+		w = send("PUT", berlin+"/2023-01-01", `{"date":"2023-01-01T00:00:00Z","temperature":25,"humidity":50}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, storage.OutcomeUpdated, w.Header().Get("Ingest-Outcome"))
+		assert.Equal(t, 25.0, stored("berlin", day(1)).Values["temperature"])
+	})
+
+	t.Run("put rejects dates outside the route day and invalid readings", func(t *testing.T) {
+		w := send("PUT", berlin+"/2023-01-01", `{"date":"2023-01-02T00:00:00Z","temperature":25,"humidity":50}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("PUT", berlin+"/2023-01-01", `{"temperature":25}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("patch changes single columns", func(t *testing.T) {
+		w := send("PATCH", berlin+"/2023-01-01", `{"humidity":55}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		data := stored("berlin", day(1))
+		assert.Equal(t, 25.0, data.Values["temperature"])
+		assert.Equal(t, 55.0, data.Values["humidity"])
+		stored := published.ofType(events.TypeStored)
+		require.NotEmpty(t, stored)
+		last := stored[len(stored)-1].Reading
+		assert.Equal(t, "berlin", last.Station)
+		assert.Equal(t, 55.0, last.Values["humidity"])
+	})
+
+	t.Run("patch is validated", func(t *testing.T) {
+		for _, body := range []string{`{"humidity":500}`, `{"humidity":null}`, `{"pressure":1000}`, `{"humidity":"wet"}`, `{}`} {
+			w := send("PATCH", berlin+"/2023-01-01", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+		assert.Equal(t, 55.0, stored("berlin", day(1)).Values["humidity"])
+	})
+
+	t.Run("patch of a missing reading is 404", func(t *testing.T) {
+		w := send("PATCH", berlin+"/2023-01-05", `{"humidity":55}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	for _, d := range []string{"2023-01-02", "2023-01-03", "2023-01-04"} {
+		require.Equal(t, http.StatusCreated, send("PUT", "/api/v1/weather/"+d+"?station=oslo", `{"temperature":1,"humidity":80}`).Code)
+	}
+
+	t.Run("dry run reports without deleting", func(t *testing.T) {
+		w := send("DELETE", "/api/v1/weather?from=2023-01-01&to=2023-01-03&dryRun=true", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result struct {
+			Deleted  int                  `json:"deleted"`
+			DryRun   bool                 `json:"dryRun"`
+			Readings []*model.WeatherData `json:"readings"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		assert.Equal(t, 3, result.Deleted)
+		assert.True(t, result.DryRun)
+		assert.Len(t, result.Readings, 3)
+		assert.NotNil(t, stored("oslo", day(2)))
+		assert.Empty(t, published.ofType(events.TypeDeleted))
+	})
+
+	t.Run("delete a day and a range", func(t *testing.T) {
+		w := send("DELETE", "/api/v1/weather/2023-01-04?station=oslo", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, stored("oslo", day(4)))
+
+		w = send("DELETE", "/api/v1/weather?from=2023-01-01&to=2023-01-03&station=oslo", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, stored("oslo", day(2)))
+		assert.NotNil(t, stored("berlin", day(1)), "other stations are kept")
+		assert.Len(t, published.ofType(events.TypeDeleted), 3)
+
+		w = send("DELETE", "/api/v1/weather/2023-01-04?station=oslo", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
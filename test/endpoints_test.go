@@ -3,6 +3,9 @@ package test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,9 +15,11 @@ import (
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
 	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -22,14 +27,51 @@ type MockIngestService struct {
 	mock.Mock
 }
 
-func (m *MockIngestService) IngestFile(ctx context.Context, filePath string) error {
-	args := m.Called(ctx, filePath)
+func (m *MockIngestService) IngestFile(ctx context.Context, filePath string, opts ...*service.FileOptions) (*service.FileSummary, error) {
+	args := m.Called(ctx, filePath, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.FileSummary), args.Error(1)
+}
+
+func (m *MockIngestService) FollowFile(ctx context.Context, filePath string, opts ...*service.FileOptions) error {
+	args := m.Called(ctx, filePath, opts)
 	return args.Error(0)
 }
 
-func (m *MockIngestService) IngestSingle(ctx context.Context, data *model.WeatherData) error {
+func (m *MockIngestService) IngestSingle(ctx context.Context, data *model.WeatherData) (string, error) {
 	args := m.Called(ctx, data)
-	return args.Error(0)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockIngestService) IngestBatch(ctx context.Context, data []*model.WeatherData) (*service.BatchResult, error) {
+	args := m.Called(ctx, data)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.BatchResult), args.Error(1)
+}
+
+func (m *MockIngestService) ReplaceReading(ctx context.Context, data *model.WeatherData) (string, error) {
+	args := m.Called(ctx, data)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockIngestService) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any) (*model.WeatherData, error) {
+	args := m.Called(ctx, station, date, values)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.WeatherData), args.Error(1)
+}
+
+func (m *MockIngestService) DeleteReadings(ctx context.Context, start, end time.Time, station string, dryRun bool) ([]*model.WeatherData, error) {
+	args := m.Called(ctx, start, end, station, dryRun)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.WeatherData), args.Error(1)
 }
 
 type MockQueryService struct {
@@ -43,9 +85,65 @@ func (m *MockQueryService) GetByDate(ctx context.Context, date time.Time, opts .
 
 func (m *MockQueryService) GetByDateRange(ctx context.Context, start, end time.Time, opts ...*service.QueryOptions) ([]*model.WeatherData, error) {
 	args := m.Called(ctx, start, end, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).([]*model.WeatherData), args.Error(1)
 }
 
+type MockStationService struct {
+	mock.Mock
+}
+
+func (m *MockStationService) RegisterStation(ctx context.Context, station *model.Station) error {
+	return m.Called(ctx, station).Error(0)
+}
+
+func (m *MockStationService) GetStation(ctx context.Context, id string) (*model.Station, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Station), args.Error(1)
+}
+
+func (m *MockStationService) ListStations(ctx context.Context) ([]*model.Station, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*model.Station), args.Error(1)
+}
+
+func (m *MockQueryService) CountByDateRange(ctx context.Context, start, end time.Time, opts ...*service.QueryOptions) (int64, error) {
+	args := m.Called(ctx, start, end, opts)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockQueryService) StreamByDateRange(ctx context.Context, start, end time.Time, opts ...*service.QueryOptions) (iter.Seq2[*model.WeatherData, error], error) {
+	args := m.Called(ctx, start, end, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	if records, ok := args.Get(0).(iter.Seq2[*model.WeatherData, error]); ok {
+		return records, args.Error(1)
+	}
+	return recordSeq(args.Get(0).([]*model.WeatherData)), args.Error(1)
+}
+
+func (m *MockQueryService) Aggregate(ctx context.Context, start, end time.Time, opts service.AggregateOptions) ([]*model.AggregateBucket, error) {
+	args := m.Called(ctx, start, end, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.AggregateBucket), args.Error(1)
+}
+
+func (m *MockQueryService) History(ctx context.Context, start, end time.Time, station string) ([]*model.WeatherRevision, error) {
+	args := m.Called(ctx, start, end, station)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.WeatherRevision), args.Error(1)
+}
+
 type MockWebSocketHub struct {
 	mock.Mock
 }
@@ -54,10 +152,22 @@ func (m *MockWebSocketHub) HandleConnection(w http.ResponseWriter, r *http.Reque
 	m.Called(w, r)
 }
 
+func (m *MockWebSocketHub) HandleStream(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
 func (m *MockWebSocketHub) Broadcast(data *model.WeatherData) {
 	m.Called(data)
 }
 
+func (m *MockWebSocketHub) BroadcastDeleted(data *model.WeatherData) {
+	m.Called(data)
+}
+
+func (m *MockWebSocketHub) Stats() handler.HubStats {
+	return m.Called().Get(0).(handler.HubStats)
+}
+
 func (m *MockWebSocketHub) Run(ctx context.Context) {
 	m.Called(ctx)
 }
@@ -68,27 +178,31 @@ func (m *MockWebSocketHub) Shutdown() {
 
 type TestHandler struct {
 	*handler.HTTPHandler
-	IngestSvc *MockIngestService
-	QuerySvc  *MockQueryService
-	WSHub     *MockWebSocketHub
+	IngestSvc  *MockIngestService
+	QuerySvc   *MockQueryService
+	StationSvc *MockStationService
+	WSHub      *MockWebSocketHub
 }
 
 func setupTestHandler() *TestHandler {
 	logger := zap.NewNop()
 	ingestSvc := &MockIngestService{}
 	querySvc := &MockQueryService{}
+	stationSvc := &MockStationService{}
 	wsHub := &MockWebSocketHub{}
 
 	return &TestHandler{
 		HTTPHandler: handler.NewHTTPHandler(
 			ingestSvc,
 			querySvc,
+			stationSvc,
 			wsHub,
 			logger,
 		),
-		IngestSvc: ingestSvc,
-		QuerySvc:  querySvc,
-		WSHub:     wsHub,
+		IngestSvc:  ingestSvc,
+		QuerySvc:   querySvc,
+		StationSvc: stationSvc,
+		WSHub:      wsHub,
 	}
 }
 
@@ -100,9 +214,8 @@ func TestHTTPHandler_GetWeatherByDate(t *testing.T) {
 	testDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
 	expectedData := []*model.WeatherData{
 		{
-			Date:        testDate,
-			Temperature: 22.5,
-			Humidity:    75.5,
+			Date:   testDate,
+			Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 		},
 	}
 
@@ -139,21 +252,21 @@ func TestHTTPHandler_GetWeatherByDateRange(t *testing.T) {
 
 	startDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	// the whole "to" day is requested
+	endOfDay := endDate.Add(24*time.Hour - time.Nanosecond)
 	expectedData := []*model.WeatherData{
 		{
-			Date:        startDate,
-			Temperature: 22.5,
-			Humidity:    75.5,
+			Date:   startDate,
+			Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 		},
 		{
-			Date:        endDate,
-			Temperature: 23.5,
-			Humidity:    76.5,
+			Date:   endDate,
+			Values: map[string]any{"temperature": 23.5, "humidity": 76.5},
 		},
 	}
 
 	t.Run("successful request", func(t *testing.T) {
-		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endDate, mock.Anything).Return(expectedData, nil)
+		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endOfDay, mock.Anything).Return(expectedData, nil)
 
 		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02", nil)
 		w := httptest.NewRecorder()
@@ -179,20 +292,267 @@ func TestHTTPHandler_GetWeatherByDateRange(t *testing.T) {
 	})
 }
 
+func TestHTTPHandler_DateRangeIncludesToDay(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	evening := time.Date(2023, 1, 2, 18, 30, 0, 0, time.UTC)
+	_, err := repo.BulkUpsert(context.Background(), []*model.WeatherData{
+		{Station: "berlin", Date: time.Date(2023, 1, 1, 6, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 1.0, "humidity": 80.0}},
+		{Station: "berlin", Date: evening, Values: map[string]any{"temperature": 2.0, "humidity": 70.0}},
+		{Station: "berlin", Date: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 3.0, "humidity": 60.0}},
+	}, storage.DefaultBulkOptions())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(&MockIngestService{}, service.NewQueryService(repo), &MockStationService{}, &MockWebSocketHub{}, zap.NewNop()).RegisterRoutes(router)
+
+	for _, query := range []string{"", "&stream=true", "&count=true&limit=10"} {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, query)
+		var response []*model.WeatherData
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response), query)
+		if assert.Len(t, response, 2, query) {
+			assert.True(t, response[1].Date.Equal(evening), query)
+		}
+		if query == "&count=true&limit=10" {
+			assert.Equal(t, "2", w.Header().Get("X-Total-Count"))
+		}
+	}
+}
+
+func TestHTTPHandler_KeysetPagination(t *testing.T) {
+	th := setupTestHandler()
+	router := mux.NewRouter()
+	th.RegisterRoutes(router)
+
+	startDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+	// the whole "to" day is requested
+	endOfDay := endDate.Add(24*time.Hour - time.Nanosecond)
+	page := []*model.WeatherData{
+		{Station: "berlin-1", Date: startDate, Values: map[string]any{"temperature": 22.5, "humidity": 75.5}},
+		{Station: "berlin-1", Date: startDate.AddDate(0, 0, 1), Values: map[string]any{"temperature": 23.5, "humidity": 76.5}},
+	}
+	nextToken := service.EncodeCursor(page[1])
+
+	t.Run("full page announces the next one", func(t *testing.T) {
+		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endOfDay, mock.MatchedBy(func(opts []*service.QueryOptions) bool {
+			return opts[0].Pagination.Limit == 2 && opts[0].Pagination.After == ""
+		})).Return(page, nil).Once()
+		th.QuerySvc.On("CountByDateRange", mock.Anything, startDate, endOfDay, mock.Anything).Return(int64(31), nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-31&limit=2&count=true", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "31", w.Header().Get("X-Total-Count"))
+		assert.Equal(t, nextToken, w.Header().Get("X-Next-Cursor"))
+		assert.Contains(t, w.Header().Get("Link"), "after="+nextToken)
+		assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
+	})
+
+	t.Run("continuation token is passed through", func(t *testing.T) {
+		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endOfDay, mock.MatchedBy(func(opts []*service.QueryOptions) bool {
+			return opts[0].Pagination.After == nextToken
+		})).Return([]*model.WeatherData{}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-31&limit=2&after="+nextToken, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		// the last page is empty rather than not found
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Link"))
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endOfDay, mock.MatchedBy(func(opts []*service.QueryOptions) bool {
+			return opts[0].Pagination.After == "garbage"
+		})).Return(nil, service.ErrInvalidCursor).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-31&after=garbage", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHTTPHandler_StreamWeatherByDateRange(t *testing.T) {
+	th := setupTestHandler()
+	router := mux.NewRouter()
+	th.RegisterRoutes(router)
+
+	startDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	// the whole "to" day is requested
+	endOfDay := endDate.Add(24*time.Hour - time.Nanosecond)
+	streamed := []*model.WeatherData{
+		{Date: startDate, Values: map[string]any{"temperature": 22.5, "humidity": 75.5}},
+		{Date: endDate, Values: map[string]any{"temperature": 23.5, "humidity": 76.5}},
+	}
+	th.QuerySvc.On("StreamByDateRange", mock.Anything, startDate, endOfDay, mock.Anything).Return(streamed, nil)
+
+	t.Run("chunked JSON array", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02&stream=true", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var response []*model.WeatherData
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, streamed, response)
+	})
+
+	t.Run("NDJSON with projection", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02&stream=true&fields=humidity", nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if assert.Len(t, lines, 2) {
+			assert.JSONEq(t, `{"date":"2023-01-01T00:00:00Z","humidity":75.5}`, lines[0])
+			assert.JSONEq(t, `{"date":"2023-01-02T00:00:00Z","humidity":76.5}`, lines[1])
+		}
+	})
+
+	t.Run("failure before the first record is a 500", func(t *testing.T) {
+		th := setupTestHandler()
+		router := mux.NewRouter()
+		th.RegisterRoutes(router)
+
+		failing := iter.Seq2[*model.WeatherData, error](func(yield func(*model.WeatherData, error) bool) {
+			yield(nil, errors.New("cursor failed"))
+		})
+		th.QuerySvc.On("StreamByDateRange", mock.Anything, startDate, endOfDay, mock.Anything).Return(failing, nil)
+
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02&stream=true", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "[")
+	})
+}
+
+func TestHTTPHandler_ExportFormats(t *testing.T) {
+	th := setupTestHandler()
+	router := mux.NewRouter()
+	th.RegisterRoutes(router)
+
+	startDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	data := []*model.WeatherData{
+		{Station: "berlin", Date: startDate, Values: map[string]any{"temperature": 22.5, "humidity": 75.5}},
+		{Station: "berlin", Date: endDate, Values: map[string]any{"temperature": 23.5, "humidity": nil}},
+	}
+	th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endDate.Add(24*time.Hour-time.Nanosecond), mock.Anything).Return(data, nil)
+
+	t.Run("CSV via Accept header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02", nil)
+		req.Header.Set("Accept", "text/csv, application/json;q=0.5")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Date,Station,Temperature (°C),Humidity (%)\n"+
+			"2023-01-01T00:00:00Z,berlin,22.5,75.5\n"+
+			"2023-01-02T00:00:00Z,berlin,23.5,\n", w.Body.String())
+	})
+
+	t.Run("weather.dat via format parameter with projection", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02&format=dat&fields=humidity", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "# Date\tHumidity (%)\n2023-01-01\t75.5\n2023-01-02\tNA\n", w.Body.String())
+	})
+
+	t.Run("weather.dat with several stations has a station column", func(t *testing.T) {
+		th := setupTestHandler()
+		router := mux.NewRouter()
+		th.RegisterRoutes(router)
+
+		mixed := []*model.WeatherData{
+			{Station: "berlin", Date: startDate, Values: map[string]any{"humidity": 75.5}},
+			{Station: "oslo", Date: startDate, Values: map[string]any{"humidity": 80.0}},
+		}
+		th.QuerySvc.On("GetByDateRange", mock.Anything, startDate, endDate.Add(24*time.Hour-time.Nanosecond), mock.Anything).Return(mixed, nil)
+		th.QuerySvc.On("StreamByDateRange", mock.Anything, startDate, endDate.Add(24*time.Hour-time.Nanosecond), mock.Anything).Return(mixed, nil)
+
+		want := "# Date\tStation\tHumidity (%)\n2023-01-01\tberlin\t75.5\n2023-01-01\toslo\t80\n"
+		for _, url := range []string{
+			"/api/v1/weather?from=2023-01-01&to=2023-01-02&format=dat&fields=humidity",
+			"/api/v1/weather?from=2023-01-01&to=2023-01-02&format=dat&fields=humidity&stream=true",
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+			assert.Equal(t, http.StatusOK, w.Code, url)
+			assert.Equal(t, want, w.Body.String(), url)
+		}
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02&format=columnar", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Rows int              `json:"rows"`
+			Data map[string][]any `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, 2, response.Rows)
+		assert.Equal(t, []any{22.5, 23.5}, response.Data["temperature"])
+		assert.Equal(t, []any{75.5, nil}, response.Data["humidity"])
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather?from=2023-01-01&to=2023-01-02", nil)
+		req.Header.Set("Accept", "application/xml")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	})
+}
+
 func TestHTTPHandler_IngestWeatherData(t *testing.T) {
 	th := setupTestHandler()
 	router := mux.NewRouter()
 	th.RegisterRoutes(router)
 
 	testData := &model.WeatherData{
-		Date:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
-		Temperature: 22.5,
-		Humidity:    75.5,
+		Date:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 	}
 
 	t.Run("successful ingestion", func(t *testing.T) {
-		th.IngestSvc.On("IngestSingle", mock.Anything, testData).Return(nil)
-		th.WSHub.On("Broadcast", testData).Return()
+		th.IngestSvc.On("IngestSingle", mock.Anything, testData).Return(storage.OutcomeInserted, nil)
 
 		body := `{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5}`
 		req := httptest.NewRequest("POST", "/api/v1/weather", strings.NewReader(body))
@@ -219,6 +579,216 @@ func TestHTTPHandler_IngestWeatherData(t *testing.T) {
 	})
 }
 
+func TestHTTPHandler_IngestWeatherBatch(t *testing.T) {
+	first := &model.WeatherData{Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 22.5, "humidity": 75.5}}
+	second := &model.WeatherData{Date: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 23.5, "humidity": 76.5}}
+
+	t.Run("json array", func(t *testing.T) {
+		th := setupTestHandler()
+		router := mux.NewRouter()
+		th.RegisterRoutes(router)
+
+		th.IngestSvc.On("IngestBatch", mock.Anything, []*model.WeatherData{first, second}).Return(&service.BatchResult{
+			Accepted: 2,
+			Results: []service.RecordResult{
+				{Index: 0, Status: service.RecordAccepted},
+				{Index: 1, Status: service.RecordAccepted},
+			},
+		}, nil)
+
+		body := `[{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5},{"date":"2023-01-02T00:00:00Z","temperature":23.5,"humidity":76.5}]`
+		req := httptest.NewRequest("POST", "/api/v1/weather/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response service.BatchResult
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, 2, response.Accepted)
+		th.IngestSvc.AssertExpectations(t)
+		th.WSHub.AssertExpectations(t)
+	})
+
+	t.Run("ndjson with malformed and rejected lines", func(t *testing.T) {
+		th := setupTestHandler()
+		router := mux.NewRouter()
+		th.RegisterRoutes(router)
+
+		th.IngestSvc.On("IngestBatch", mock.Anything, []*model.WeatherData{first, second}).Return(&service.BatchResult{
+			Accepted: 1,
+			Rejected: 1,
+			Results: []service.RecordResult{
+				{Index: 0, Status: service.RecordAccepted},
+				{Index: 1, Status: service.RecordRejected, Reason: "duplicate"},
+			},
+		}, nil)
+
+		body := "{\"date\":\"2023-01-01T00:00:00Z\",\"temperature\":22.5,\"humidity\":75.5}\n" +
+			"not json\n" +
+			"{\"date\":\"2023-01-02T00:00:00Z\",\"temperature\":23.5,\"humidity\":76.5}\n"
+		req := httptest.NewRequest("POST", "/api/v1/weather/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		var response service.BatchResult
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, 1, response.Accepted)
+		assert.Equal(t, 2, response.Rejected)
+		if assert.Len(t, response.Results, 3) {
+			assert.Equal(t, service.RecordAccepted, response.Results[0].Status)
+			assert.Equal(t, 1, response.Results[1].Index)
+			assert.Equal(t, service.RecordRejected, response.Results[1].Status)
+			assert.Equal(t, 2, response.Results[2].Index)
+			assert.Equal(t, "duplicate", response.Results[2].Reason)
+		}
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		th := setupTestHandler()
+		router := mux.NewRouter()
+		th.RegisterRoutes(router)
+
+		req := httptest.NewRequest("POST", "/api/v1/weather/batch", strings.NewReader("[]"))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+}
+
+func TestHTTPHandler_StationRoutes(t *testing.T) {
+	th := setupTestHandler()
+	router := mux.NewRouter()
+	th.RegisterRoutes(router)
+
+	station := &model.Station{ID: "berlin-1", Name: "Berlin Mitte", Latitude: 52.52, Longitude: 13.40, Timezone: "Europe/Berlin"}
+	th.StationSvc.On("GetStation", mock.Anything, "berlin-1").Return(station, nil)
+	th.StationSvc.On("GetStation", mock.Anything, "unknown").Return(nil, fmt.Errorf("station lookup failed: %w", storage.ErrNotFound))
+
+	testDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("station-scoped date query", func(t *testing.T) {
+		expectedData := []*model.WeatherData{{Station: "berlin-1", Date: testDate, Values: map[string]any{"temperature": 22.5, "humidity": 75.5}}}
+		th.QuerySvc.On("GetByDate", mock.Anything, testDate, mock.MatchedBy(func(opts []*service.QueryOptions) bool {
+			return len(opts) == 1 && opts[0].Station == "berlin-1"
+		})).Return(expectedData, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/stations/berlin-1/weather/2023-01-01", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		th.QuerySvc.AssertExpectations(t)
+	})
+
+	t.Run("station-scoped ingest assigns station", func(t *testing.T) {
+		th.IngestSvc.On("IngestSingle", mock.Anything, mock.MatchedBy(func(data *model.WeatherData) bool {
+			return data.Station == "berlin-1"
+		})).Return(storage.OutcomeInserted, nil).Once()
+
+		body := `{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5}`
+		req := httptest.NewRequest("POST", "/api/v1/stations/berlin-1/weather", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		th.IngestSvc.AssertExpectations(t)
+	})
+
+	t.Run("unknown station", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stations/unknown/weather/2023-01-01", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("register station", func(t *testing.T) {
+		th.StationSvc.On("RegisterStation", mock.Anything, station).Return(nil).Once()
+
+		body := `{"name":"Berlin Mitte","latitude":52.52,"longitude":13.40,"timezone":"Europe/Berlin"}`
+		req := httptest.NewRequest("PUT", "/api/v1/stations/berlin-1", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		th.StationSvc.AssertExpectations(t)
+	})
+}
+
+func TestHTTPHandler_GetWeatherAggregate(t *testing.T) {
+	th := setupTestHandler()
+	router := mux.NewRouter()
+	th.RegisterRoutes(router)
+
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	avg := 21.3
+
+	t.Run("successful request", func(t *testing.T) {
+		expectedOpts := service.AggregateOptions{
+			Bucket:  "month",
+			Metrics: map[string][]string{"temperature": {"avg", "max"}, "humidity": {"min"}},
+		}
+		th.QuerySvc.On("Aggregate", mock.Anything, from, to, expectedOpts).Return([]*model.AggregateBucket{
+			{
+				Start:   from,
+				End:     from.AddDate(0, 1, 0),
+				Count:   31,
+				Metrics: map[string]map[string]*float64{"temperature": {"avg": &avg}},
+			},
+		}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/weather/aggregate?from=2023-01-01&to=2023-01-31&bucket=month&metrics=temperature:avg,max;humidity:min", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response []*model.AggregateBucket
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		if assert.Len(t, response, 1) {
+			assert.Equal(t, int64(31), response[0].Count)
+			assert.Equal(t, avg, *response[0].Metrics["temperature"]["avg"])
+		}
+		th.QuerySvc.AssertExpectations(t)
+	})
+
+	t.Run("invalid bucket is a bad request", func(t *testing.T) {
+		th.QuerySvc.On("Aggregate", mock.Anything, from, to, mock.MatchedBy(func(opts service.AggregateOptions) bool {
+			return opts.Bucket == "decade"
+		})).Return(nil, fmt.Errorf("%w: unknown bucket", service.ErrInvalidAggregate)).Once()
+
+		req := httptest.NewRequest("GET", "/api/v1/weather/aggregate?from=2023-01-01&to=2023-01-31&bucket=decade", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("malformed metrics", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/weather/aggregate?from=2023-01-01&to=2023-01-31&metrics=temperature", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
 func BenchmarkHTTPHandler_GetWeatherByDate(b *testing.B) {
 	th := setupTestHandler()
 	router := mux.NewRouter()
@@ -227,9 +797,8 @@ func BenchmarkHTTPHandler_GetWeatherByDate(b *testing.B) {
 	testDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
 	expectedData := []*model.WeatherData{
 		{
-			Date:        testDate,
-			Temperature: 22.5,
-			Humidity:    75.5,
+			Date:   testDate,
+			Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 		},
 	}
 
@@ -250,8 +819,7 @@ func BenchmarkHTTPHandler_IngestWeatherData(b *testing.B) {
 	router := mux.NewRouter()
 	th.RegisterRoutes(router)
 
-	th.IngestSvc.On("IngestSingle", mock.Anything, mock.Anything).Return(nil).Maybe()
-	th.WSHub.On("Broadcast", mock.Anything).Maybe()
+	th.IngestSvc.On("IngestSingle", mock.Anything, mock.Anything).Return(storage.OutcomeInserted, nil).Maybe()
 
 	body := `{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5}`
 
@@ -265,3 +833,29 @@ func BenchmarkHTTPHandler_IngestWeatherData(b *testing.B) {
 		router.ServeHTTP(w, req)
 	}
 }
+
+func TestHTTPHandler_RangeLimit(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	_, err := repo.BulkUpsert(context.Background(), []*model.WeatherData{
+		{Date: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 20.0, "humidity": 50.0}},
+		{Date: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 5.0, "humidity": 80.0}},
+	}, storage.DefaultBulkOptions())
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(&MockIngestService{}, service.NewQueryService(repo), &MockStationService{}, &MockWebSocketHub{}, zap.NewNop()).RegisterRoutes(router)
+
+	tests := map[string]int{
+		"from=2024-01-01&to=2024-12-31": http.StatusOK, // a leap year
+		"from=2023-01-01&to=2024-01-01": http.StatusOK,
+		"from=2023-01-01&to=2024-01-02": http.StatusBadRequest,
+		"from=2023-01-02&to=2023-01-01": http.StatusBadRequest,
+	}
+	for query, status := range tests {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/weather?"+query, nil))
+			assert.Equal(t, status, w.Code, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHTTPHandler_History(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	ingestSvc := service.NewIngestService(repo, service.WithBulkOptions(storage.BulkOptions{History: true}))
+	querySvc := service.NewQueryService(repo, service.WithRevisions(repo))
+	wsHub := &MockWebSocketHub{}
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(ingestSvc, querySvc, &MockStationService{}, wsHub, zap.NewNop()).RegisterRoutes(router)
+
+	post := func(body string) {
+		req := httptest.NewRequest("POST", "/api/v1/weather/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+	// the history has millisecond precision, moments are taken between writes
+	moment := func() string {
+		time.Sleep(2 * time.Millisecond)
+		at := time.Now().UTC().Format(time.RFC3339Nano)
+		time.Sleep(2 * time.Millisecond)
+		return at
+	}
+
+	beforeAll := moment()
+	post(`[{"station":"berlin","date":"2023-01-01T00:00:00Z","temperature":10,"humidity":50}]`)
+	afterFirst := moment()
+	post(`[{"station":"berlin","date":"2023-01-01T00:00:00Z","temperature":12,"humidity":50},
+		{"station":"oslo","date":"2023-01-01T00:00:00Z","temperature":5,"humidity":80}]`)
+
+	decode := func(w *httptest.ResponseRecorder) []*model.WeatherData {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var data []*model.WeatherData
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&data))
+		return data
+	}
+
+	t.Run("current data", func(t *testing.T) {
+		data := decode(get("/api/v1/weather/2023-01-01"))
+		require.Len(t, data, 2)
+		assert.Equal(t, 12.0, data[0].Values["temperature"])
+	})
+
+	t.Run("as of a past moment", func(t *testing.T) {
+		data := decode(get("/api/v1/weather/2023-01-01?asOf=" + afterFirst))
+		require.Len(t, data, 1)
+		assert.Equal(t, "berlin", data[0].Station)
+		assert.Equal(t, 10.0, data[0].Values["temperature"])
+
+		data = decode(get("/api/v1/weather?from=2023-01-01&to=2023-01-02&asOf=" + afterFirst))
+		assert.Len(t, data, 1)
+
+		assert.Equal(t, http.StatusNotFound, get("/api/v1/weather/2023-01-01?asOf="+beforeAll).Code)
+	})
+
+	t.Run("as of with pagination", func(t *testing.T) {
+		w := get("/api/v1/weather?from=2023-01-01&to=2023-01-02&limit=1&asOf=" + time.Now().UTC().Format(time.RFC3339Nano))
+		data := decode(w)
+		require.Len(t, data, 1)
+		require.NotEmpty(t, w.Header().Get("X-Next-Cursor"))
+
+		data = decode(get("/api/v1/weather?from=2023-01-01&to=2023-01-02&limit=1&asOf=" + afterFirst + "&after=" + w.Header().Get("X-Next-Cursor")))
+		assert.Empty(t, data)
+	})
+
+	t.Run("invalid asOf", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/weather/2023-01-01?asOf=yesterday").Code)
+	})
+
+	t.Run("history of a day", func(t *testing.T) {
+		w := get("/api/v1/weather/2023-01-01/history")
+		require.Equal(t, http.StatusOK, w.Code)
+		var revisions []*model.WeatherRevision
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&revisions))
+		require.Len(t, revisions, 3)
+		assert.Equal(t, storage.OutcomeInserted, revisions[0].Change)
+		assert.Equal(t, storage.OutcomeUpdated, revisions[1].Change)
+		assert.Equal(t, 10.0, revisions[1].Previous["temperature"])
+		assert.Equal(t, service.SourceAPI, revisions[1].Source)
+		assert.Equal(t, "oslo", revisions[2].Station)
+
+		w = get("/api/v1/weather/2023-01-01/history?station=oslo")
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&revisions))
+		assert.Len(t, revisions, 1)
+	})
+}
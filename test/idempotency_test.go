@@ -0,0 +1,66 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestHTTPHandler_IdempotencyKey(t *testing.T) {
+	ingestSvc := &MockIngestService{}
+	wsHub := &MockWebSocketHub{}
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(ingestSvc, &MockQueryService{}, &MockStationService{}, wsHub, zap.NewNop(),
+		handler.WithIdempotency(service.NewIdempotencyService(storage.NewMemoryRepository())),
+	).RegisterRoutes(router)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/weather", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`
+
+	t.Run("retry replays the first response and ingests once", func(t *testing.T) {
+		ingestSvc.On("IngestSingle", mock.Anything, mock.Anything).Return(storage.OutcomeInserted, nil).Once()
+
+		first := post("gateway-1", body)
+		assert.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		retry := post("gateway-1", body)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+
+		ingestSvc.AssertExpectations(t)
+	})
+
+	t.Run("same key with a different body", func(t *testing.T) {
+		w := post("gateway-1", `{"date":"2023-01-02T00:00:00Z","temperature":20,"humidity":50}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		ingestSvc.On("IngestSingle", mock.Anything, mock.Anything).Return("", errors.New("database unavailable")).Once()
+		assert.Equal(t, http.StatusInternalServerError, post("gateway-2", body).Code)
+
+		ingestSvc.On("IngestSingle", mock.Anything, mock.Anything).Return(storage.OutcomeInserted, nil).Once()
+		assert.Equal(t, http.StatusCreated, post("gateway-2", body).Code)
+		ingestSvc.AssertExpectations(t)
+	})
+}
@@ -2,9 +2,12 @@ package test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"iter"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,15 +17,40 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type MockDBRepository struct {
 	mock.Mock
 }
 
-// mocks the repository's insert method
-func (m *MockDBRepository) InsertWeatherData(ctx context.Context, data any) error {
-	return m.Called(ctx, data).Error(0)
+func (m *MockDBRepository) BulkUpsert(ctx context.Context, data []*model.WeatherData, opts storage.BulkOptions) (*storage.BulkResult, error) {
+	args := m.Called(ctx, data, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*storage.BulkResult), args.Error(1)
+}
+
+func (m *MockDBRepository) ReplaceReading(ctx context.Context, data *model.WeatherData, opts storage.BulkOptions) (string, error) {
+	args := m.Called(ctx, data, opts)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockDBRepository) PatchReading(ctx context.Context, station string, date time.Time, values map[string]any, opts storage.BulkOptions) (*model.WeatherData, error) {
+	args := m.Called(ctx, station, date, values, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.WeatherData), args.Error(1)
+}
+
+func (m *MockDBRepository) DeleteReadings(ctx context.Context, start, end time.Time, station string, opts storage.BulkOptions) ([]*model.WeatherData, error) {
+	args := m.Called(ctx, start, end, station, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.WeatherData), args.Error(1)
 }
 
 func (m *MockDBRepository) GetByDate(ctx context.Context, date time.Time, opts ...*storage.QueryOptions) ([]*model.WeatherData, error) {
@@ -41,25 +69,55 @@ func (m *MockDBRepository) GetByDateRange(ctx context.Context, start, end time.T
 	return args.Get(0).([]*model.WeatherData), args.Error(1)
 }
 
+func (m *MockDBRepository) CountByDateRange(ctx context.Context, start, end time.Time, opts ...*storage.QueryOptions) (int64, error) {
+	args := m.Called(ctx, start, end, opts)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDBRepository) StreamByDateRange(ctx context.Context, start, end time.Time, opts ...*storage.QueryOptions) iter.Seq2[*model.WeatherData, error] {
+	args := m.Called(ctx, start, end, opts)
+	return recordSeq(args.Get(0).([]*model.WeatherData))
+}
+
+// recordSeq turns a fixture slice into the iterator returned by streaming methods
+func recordSeq(data []*model.WeatherData) iter.Seq2[*model.WeatherData, error] {
+	return func(yield func(*model.WeatherData, error) bool) {
+		for _, d := range data {
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *MockDBRepository) Aggregate(ctx context.Context, start, end time.Time, opts storage.AggregateOptions) ([]*model.AggregateBucket, error) {
+	args := m.Called(ctx, start, end, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.AggregateBucket), args.Error(1)
+}
+
 func (m *MockDBRepository) CloseConnection(ctx context.Context) error {
 	return m.Called(ctx).Error(0)
 }
 
 func TestIngestService(t *testing.T) {
 	validData := &model.WeatherData{
-		Date:        time.Now(),
-		Temperature: 22.5,
-		Humidity:    75.5,
+		Date:   time.Now(),
+		Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 	}
 
 	t.Run("Valid data inserts successfully", func(t *testing.T) {
 		repo := new(MockDBRepository)
-		repo.On("InsertWeatherData", mock.Anything, validData).Return(nil)
+		repo.On("BulkUpsert", mock.Anything, []*model.WeatherData{validData}, mock.Anything).
+			Return(&storage.BulkResult{Upserted: 1, Outcomes: []string{storage.OutcomeInserted}}, nil)
 
 		svc := service.NewIngestService(repo)
-		err := svc.IngestSingle(context.Background(), validData)
+		outcome, err := svc.IngestSingle(context.Background(), validData)
 
 		assert.NoError(t, err)
+		assert.Equal(t, storage.OutcomeInserted, outcome)
 		repo.AssertExpectations(t)
 	})
 
@@ -68,13 +126,13 @@ func TestIngestService(t *testing.T) {
 		// no need to set up expectations as validation should fail before repo is called
 
 		svc := service.NewIngestService(repo)
-		invalidData := *validData
-		invalidData.Temperature = 150 // out of range
+		invalidData := validData.Clone()
+		invalidData.Values["temperature"] = 150.0 // out of range
 
-		err := svc.IngestSingle(context.Background(), &invalidData)
+		_, err := svc.IngestSingle(context.Background(), invalidData)
 		assert.Error(t, err)
 		// ensure repo was never called
-		repo.AssertNotCalled(t, "InsertWeatherData")
+		repo.AssertNotCalled(t, "BulkUpsert")
 	})
 
 	t.Run("File ingestion parses lines correctly", func(t *testing.T) {
@@ -84,19 +142,47 @@ func TestIngestService(t *testing.T) {
 		}
 		defer os.Remove(tmpFile.Name())
 
-		testData := "2023-01-01\t22.5\t75.5\n2023-01-02\t23.5\t76.5\n"
+		// the header comment of a weather.dat export is skipped
+		testData := "# Date\tTemperature (°C)\tHumidity (%)\n2023-01-01\t22.5\t75.5\n2023-01-02\t23.5\t76.5\n"
+		if _, err := tmpFile.WriteString(testData); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		tmpFile.Close()
+
+		repo := new(MockDBRepository)
+		// set up expectations - both lines should be written in a single bulk call
+		repo.On("BulkUpsert", mock.Anything, mock.MatchedBy(func(data []*model.WeatherData) bool {
+			return len(data) == 2
+		}), mock.Anything).Return(&storage.BulkResult{Upserted: 2}, nil).Once()
+
+		svc := service.NewIngestService(repo)
+
+		_, err = svc.IngestFile(context.Background(), tmpFile.Name())
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("File ingestion keeps sub-daily timestamps", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", "weather_hourly*.dat")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		testData := "2023-01-01T06:00:00Z\t18.5\t80.5\n2023-01-01T12:00:00Z\t22.5\t75.5\n"
 		if _, err := tmpFile.WriteString(testData); err != nil {
 			t.Fatalf("Failed to write to temp file: %v", err)
 		}
 		tmpFile.Close()
 
 		repo := new(MockDBRepository)
-		// set up expectations - should be called for each line in the file
-		repo.On("InsertWeatherData", mock.Anything, mock.Anything).Return(nil).Times(2)
+		repo.On("BulkUpsert", mock.Anything, mock.MatchedBy(func(data []*model.WeatherData) bool {
+			return len(data) == 2 && data[0].Date.Hour() == 6 && data[1].Date.Hour() == 12
+		}), mock.Anything).Return(&storage.BulkResult{Upserted: 2}, nil).Once()
 
 		svc := service.NewIngestService(repo)
 
-		err = svc.IngestFile(context.Background(), tmpFile.Name())
+		_, err = svc.IngestFile(context.Background(), tmpFile.Name())
 		assert.NoError(t, err)
 		repo.AssertExpectations(t)
 	})
@@ -108,32 +194,48 @@ func TestIngestService(t *testing.T) {
 		// use non-existent file path
 		nonExistentPath := filepath.Join(os.TempDir(), "non_existent_file.dat")
 
-		err := svc.IngestFile(context.Background(), nonExistentPath)
+		_, err := svc.IngestFile(context.Background(), nonExistentPath)
 		assert.Error(t, err)
 		// no repo calls expected
-		repo.AssertNotCalled(t, "InsertWeatherData")
+		repo.AssertNotCalled(t, "BulkUpsert", mock.Anything, mock.Anything, mock.Anything)
 	})
 
-	t.Run("Date is normalized to midnight UTC", func(t *testing.T) {
+	t.Run("Date is normalized to midnight UTC with daily upserts", func(t *testing.T) {
 		repo := new(MockDBRepository)
 		// Use custom matcher to verify date normalization
-		repo.On("InsertWeatherData", mock.Anything, mock.MatchedBy(func(data any) bool {
-			if wd, ok := data.(*model.WeatherData); ok {
-				return wd.Date.Hour() == 0 && wd.Date.Minute() == 0 &&
-					wd.Date.Second() == 0 && wd.Date.Nanosecond() == 0
-			}
-			return false
-		})).Return(nil)
+		repo.On("BulkUpsert", mock.Anything, mock.MatchedBy(func(data []*model.WeatherData) bool {
+			wd := data[0]
+			return wd.Date.Hour() == 0 && wd.Date.Minute() == 0 &&
+				wd.Date.Second() == 0 && wd.Date.Nanosecond() == 0
+		}), mock.Anything).Return(&storage.BulkResult{Upserted: 1}, nil)
 
-		svc := service.NewIngestService(repo)
+		svc := service.NewIngestService(repo, service.WithDailyUpsert(true))
 
 		dataWithTime := &model.WeatherData{
-			Date:        time.Date(2023, 10, 15, 14, 30, 45, 123000000, time.Local),
-			Temperature: 22.5,
-			Humidity:    75.5,
+			Date:   time.Date(2023, 10, 15, 14, 30, 45, 123000000, time.Local),
+			Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 		}
 
-		err := svc.IngestSingle(context.Background(), dataWithTime)
+		_, err := svc.IngestSingle(context.Background(), dataWithTime)
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("Full timestamp is kept in UTC by default", func(t *testing.T) {
+		readingTime := time.Date(2023, 10, 15, 14, 30, 45, 0, time.FixedZone("CEST", 2*60*60))
+
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.MatchedBy(func(data []*model.WeatherData) bool {
+			wd := data[0]
+			return wd.Date.Equal(readingTime) && wd.Date.Location() == time.UTC
+		}), mock.Anything).Return(&storage.BulkResult{Upserted: 1}, nil)
+
+		svc := service.NewIngestService(repo)
+
+		_, err := svc.IngestSingle(context.Background(), &model.WeatherData{
+			Date:   readingTime,
+			Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
+		})
 		assert.NoError(t, err)
 		repo.AssertExpectations(t)
 	})
@@ -141,33 +243,252 @@ func TestIngestService(t *testing.T) {
 	t.Run("Repository error is propagated", func(t *testing.T) {
 		repo := new(MockDBRepository)
 		expectedErr := assert.AnError // testify's built-in error
-		repo.On("InsertWeatherData", mock.Anything, mock.Anything).Return(expectedErr)
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, mock.Anything).Return(nil, expectedErr)
 
 		svc := service.NewIngestService(repo)
 
-		err := svc.IngestSingle(context.Background(), validData)
+		_, err := svc.IngestSingle(context.Background(), validData)
 		assert.Error(t, err)
 		assert.ErrorIs(t, err, expectedErr)
 		repo.AssertExpectations(t)
 	})
+
+	t.Run("Conflict policy is passed to the repository", func(t *testing.T) {
+		policy := storage.ConflictPolicy{Default: storage.ConflictReject}
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, mock.MatchedBy(func(opts storage.BulkOptions) bool {
+			return opts.Conflict.For("berlin") == storage.ConflictReject
+		})).Return(&storage.BulkResult{
+			Outcomes: []string{storage.OutcomeConflict},
+			Errors:   []storage.BulkWriteError{{Index: 0, Message: "different values stored", Conflict: true}},
+		}, nil)
+
+		svc := service.NewIngestService(repo, service.WithConflictPolicy(policy))
+
+		outcome, err := svc.IngestSingle(context.Background(), validData)
+		assert.ErrorIs(t, err, storage.ErrConflict)
+		assert.Equal(t, storage.OutcomeConflict, outcome)
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestIngestService_IngestFileBatching(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "weather_batches*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	for day := 1; day <= 5; day++ {
+		fmt.Fprintf(tmpFile, "2023-01-%02d\t20.0\t50.0\n", day)
+	}
+	tmpFile.Close()
+
+	opts := storage.BulkOptions{BatchSize: 2, Ordered: true}
+	// file rows are recorded in the revision history as written by the file
+	written := opts
+	written.Source = "file:" + tmpFile.Name()
+
+	repo := new(MockDBRepository)
+	repo.On("BulkUpsert", mock.Anything, mock.Anything, written).Return(&storage.BulkResult{}, nil).Times(3)
+
+	svc := service.NewIngestService(repo, service.WithBulkOptions(opts))
+	_, err = svc.IngestFile(context.Background(), tmpFile.Name())
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+
+	t.Run("write errors fail the run", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, written).Return(&storage.BulkResult{
+			Errors: []storage.BulkWriteError{{Index: 1, Message: "duplicate key"}},
+		}, nil).Once()
+
+		svc := service.NewIngestService(repo, service.WithBulkOptions(opts))
+		_, err := svc.IngestFile(context.Background(), tmpFile.Name())
+		assert.ErrorContains(t, err, "duplicate key")
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestIngestService_IngestFileLenient(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "weather.dat")
+	content := "# Date\tTemperature\tHumidity\n" +
+		"2023-01-01\t22.5\t75.5\n" +
+		"2023-01-02\tabc\t75.5\n" +
+		"\n" +
+		"2023-01-03\t150\t75.5\n" +
+		"2023-01-04\t23.5\t76.5\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	t.Run("strict mode stops at the first bad row", func(t *testing.T) {
+		svc := service.NewIngestService(storage.NewMemoryRepository())
+		_, err := svc.IngestFile(context.Background(), path)
+		assert.ErrorContains(t, err, "line 3")
+	})
+
+	t.Run("lenient mode keeps going and dead-letters bad rows", func(t *testing.T) {
+		repo := storage.NewMemoryRepository()
+		deadLetterPath := filepath.Join(dir, "dead_letter.ndjson")
+		svc := service.NewIngestService(repo,
+			service.WithLenientIngestion(true),
+			service.WithDeadLetterSink(storage.NewFileDeadLetterSink(deadLetterPath)),
+		)
+
+		summary, err := svc.IngestFile(context.Background(), path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Parsed)
+		assert.Equal(t, 2, summary.Accepted)
+		assert.Equal(t, 2, summary.Rejected)
+		assert.Equal(t, 2, summary.Skipped)
+		require.Len(t, summary.Rejections, 2)
+		assert.Equal(t, 3, summary.Rejections[0].Line)
+		assert.Equal(t, "2023-01-02\tabc\t75.5", summary.Rejections[0].Raw)
+		assert.Contains(t, summary.Rejections[1].Reason, "temperature outside valid range")
+
+		stored, err := repo.GetByDateRange(context.Background(), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.Len(t, stored, 2)
+
+		deadLetters, err := os.ReadFile(deadLetterPath)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(deadLetters)), "\n")
+		require.Len(t, lines, 2)
+		var rejected model.RejectedLine
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &rejected))
+		assert.Equal(t, 5, rejected.Line)
+		assert.Equal(t, path, rejected.Source)
+	})
+
+	t.Run("lenient mode reports write errors per row", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, mock.Anything).Return(&storage.BulkResult{
+			Upserted: 1,
+			Errors:   []storage.BulkWriteError{{Index: 1, Message: "duplicate key"}},
+		}, nil).Once()
+
+		svc := service.NewIngestService(repo, service.WithLenientIngestion(true))
+		summary, err := svc.IngestFile(context.Background(), path)
+		require.NoError(t, err)
+		assert.Equal(t, 1, summary.Accepted)
+		assert.Equal(t, 3, summary.Rejected)
+		assert.Equal(t, 6, summary.Rejections[2].Line)
+		assert.Equal(t, "duplicate key", summary.Rejections[2].Reason)
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestIngestService_IngestFileCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weather.dat")
+	var content strings.Builder
+	for day := 1; day <= 5; day++ {
+		fmt.Fprintf(&content, "2023-01-%02d\t20.0\t50.0\n", day)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0o644))
+
+	opts := storage.BulkOptions{BatchSize: 2}
+	written := opts
+	written.Source = "file:" + path
+	checkpoints := storage.NewMemoryRepository()
+	startsOn := func(day int) any {
+		return mock.MatchedBy(func(data []*model.WeatherData) bool {
+			return data[0].Date.Day() == day
+		})
+	}
+
+	t.Run("an interrupted run keeps its checkpoint", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, startsOn(1), written).Return(&storage.BulkResult{Upserted: 2}, nil).Once()
+		repo.On("BulkUpsert", mock.Anything, startsOn(3), written).Return(nil, fmt.Errorf("connection reset")).Once()
+
+		svc := service.NewIngestService(repo, service.WithBulkOptions(opts), service.WithCheckpoints(checkpoints))
+		_, err := svc.IngestFile(context.Background(), path)
+		assert.ErrorContains(t, err, "connection reset")
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("a restarted run resumes after the last written batch", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, startsOn(3), written).Return(&storage.BulkResult{Upserted: 2}, nil).Once()
+		repo.On("BulkUpsert", mock.Anything, startsOn(5), written).Return(&storage.BulkResult{Upserted: 1}, nil).Once()
+
+		svc := service.NewIngestService(repo, service.WithBulkOptions(opts), service.WithCheckpoints(checkpoints))
+		summary, err := svc.IngestFile(context.Background(), path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.ResumedFromLine)
+		assert.Equal(t, 3, summary.Accepted)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("a completed file is skipped unless forced", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		svc := service.NewIngestService(repo, service.WithBulkOptions(opts), service.WithCheckpoints(checkpoints))
+		summary, err := svc.IngestFile(context.Background(), path)
+		require.NoError(t, err)
+		assert.True(t, summary.AlreadyIngested)
+		repo.AssertNotCalled(t, "BulkUpsert", mock.Anything, mock.Anything, mock.Anything)
+
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, written).Return(&storage.BulkResult{}, nil).Times(3)
+		summary, err = svc.IngestFile(context.Background(), path, &service.FileOptions{Force: true})
+		require.NoError(t, err)
+		assert.False(t, summary.AlreadyIngested)
+		assert.Equal(t, 5, summary.Accepted)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("changed content starts a new run", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(content.String()+"2023-01-06\t20.0\t50.0\n"), 0o644))
+
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.Anything, written).Return(&storage.BulkResult{}, nil).Times(3)
+		svc := service.NewIngestService(repo, service.WithBulkOptions(opts), service.WithCheckpoints(checkpoints))
+		summary, err := svc.IngestFile(context.Background(), path)
+		require.NoError(t, err)
+		assert.Equal(t, 6, summary.Accepted)
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestIngestService_IngestBatch(t *testing.T) {
+	valid := &model.WeatherData{Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 22.5, "humidity": 75.5}}
+	invalid := &model.WeatherData{Date: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 150, "humidity": 75.5}}
+	conflicting := &model.WeatherData{Date: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": 20, "humidity": 50}}
+
+	repo := new(MockDBRepository)
+	repo.On("BulkUpsert", mock.Anything, []*model.WeatherData{valid, conflicting}, mock.Anything).Return(&storage.BulkResult{
+		Upserted: 1,
+		Errors:   []storage.BulkWriteError{{Index: 1, Message: "write failed"}},
+	}, nil)
+
+	svc := service.NewIngestService(repo)
+	result, err := svc.IngestBatch(context.Background(), []*model.WeatherData{valid, invalid, conflicting})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Accepted)
+	assert.Equal(t, 2, result.Rejected)
+	assert.Equal(t, service.RecordAccepted, result.Results[0].Status)
+	assert.Equal(t, service.RecordRejected, result.Results[1].Status)
+	assert.Contains(t, result.Results[1].Reason, "temperature")
+	assert.Equal(t, service.RecordRejected, result.Results[2].Status)
+	assert.Equal(t, "write failed", result.Results[2].Reason)
+	repo.AssertExpectations(t)
 }
 
 func BenchmarkIngestService_IngestSingle(b *testing.B) {
 	data := &model.WeatherData{
-		Date:        time.Now(),
-		Temperature: 22.5,
-		Humidity:    75.5,
+		Date:   time.Now(),
+		Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 	}
 
 	repo := new(MockDBRepository)
-	repo.On("InsertWeatherData", mock.Anything, mock.Anything).Return(nil)
+	repo.On("BulkUpsert", mock.Anything, mock.Anything, mock.Anything).Return(&storage.BulkResult{Upserted: 1}, nil)
 
 	svc := service.NewIngestService(repo)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err := svc.IngestSingle(context.Background(), data)
+		_, err := svc.IngestSingle(context.Background(), data)
 		if err != nil {
 			b.Fatalf("Unexpected error: %v", err)
 		}
@@ -196,14 +517,14 @@ func BenchmarkIngestService_IngestFile(b *testing.B) {
 	tmpFile.Close()
 
 	repo := new(MockDBRepository)
-	repo.On("InsertWeatherData", mock.Anything, mock.Anything).Return(nil)
+	repo.On("BulkUpsert", mock.Anything, mock.Anything, mock.Anything).Return(&storage.BulkResult{}, nil)
 
 	svc := service.NewIngestService(repo)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err := svc.IngestFile(context.Background(), tmpFile.Name())
+		_, err := svc.IngestFile(context.Background(), tmpFile.Name())
 		if err != nil {
 			b.Fatalf("Unexpected error: %v", err)
 		}
@@ -0,0 +1,283 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// runPipeline starts the workers, the returned func shuts the pipeline down and waits for the queue to drain
+func runPipeline(p *service.IngestPipeline) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func dayReading(d int) *model.WeatherData {
+	return reading("", time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC), float64(d), 50)
+}
+
+func TestIngestPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("micro-batches queued readings", func(t *testing.T) {
+		repo := storage.NewMemoryRepository()
+		rows := &rowRecorder{}
+		p, err := service.NewIngestPipeline(service.NewIngestService(repo, service.WithEvents(rows, zap.NewNop())),
+			service.WithWorkers(2),
+			service.WithLinger(5*time.Millisecond),
+		)
+		require.NoError(t, err)
+		stop := runPipeline(p)
+
+		var tickets []*service.IngestTicket
+		for d := 1; d <= 5; d++ {
+			ticket, err := p.Enqueue(ctx, dayReading(d))
+			require.NoError(t, err)
+			assert.Equal(t, service.TicketQueued, ticket.Status)
+			tickets = append(tickets, ticket)
+		}
+
+		require.Eventually(t, func() bool { return p.Stats().Stored == 5 }, time.Second, 5*time.Millisecond)
+		ticket, ok := p.Ticket(tickets[4].ID)
+		require.True(t, ok)
+		assert.Equal(t, service.TicketStored, ticket.Status)
+		assert.NotNil(t, ticket.CompletedAt)
+		assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, rows.days())
+
+		stats := p.Stats()
+		assert.Equal(t, int64(5), stats.Enqueued)
+		assert.Len(t, stats.Workers, 2)
+		stop()
+
+		_, err = p.Enqueue(ctx, dayReading(6))
+		assert.ErrorIs(t, err, service.ErrPipelineClosed)
+	})
+
+	t.Run("invalid readings are refused up front", func(t *testing.T) {
+		p, err := service.NewIngestPipeline(service.NewIngestService(storage.NewMemoryRepository()))
+		require.NoError(t, err)
+		_, err = p.Enqueue(ctx, &model.WeatherData{Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"humidity": 150.0}})
+		assert.ErrorIs(t, err, service.ErrInvalidData)
+	})
+
+	t.Run("reject when full", func(t *testing.T) {
+		p, err := service.NewIngestPipeline(service.NewIngestService(storage.NewMemoryRepository()),
+			service.WithQueueSize(1),
+			service.WithBackpressure(service.BackpressureReject),
+		)
+		require.NoError(t, err)
+
+		// no workers yet, the queue fills up
+		_, err = p.Enqueue(ctx, dayReading(1))
+		require.NoError(t, err)
+		_, err = p.Enqueue(ctx, dayReading(2))
+		assert.ErrorIs(t, err, service.ErrQueueFull)
+		assert.Equal(t, int64(1), p.Stats().Rejected)
+	})
+
+	t.Run("block until the context gives up", func(t *testing.T) {
+		p, err := service.NewIngestPipeline(service.NewIngestService(storage.NewMemoryRepository()), service.WithQueueSize(1))
+		require.NoError(t, err)
+		_, err = p.Enqueue(ctx, dayReading(1))
+		require.NoError(t, err)
+
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = p.Enqueue(timeout, dayReading(2))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("spill to disk and feed back in order", func(t *testing.T) {
+		repo := storage.NewMemoryRepository()
+		rows := &rowRecorder{}
+		spillPath := filepath.Join(t.TempDir(), "spill.ndjson")
+		p, err := service.NewIngestPipeline(service.NewIngestService(repo, service.WithEvents(rows, zap.NewNop())),
+			service.WithQueueSize(1),
+			service.WithWorkers(1),
+			service.WithMicroBatchSize(1),
+			service.WithLinger(time.Millisecond),
+			service.WithBackpressure(service.BackpressureSpill),
+			service.WithSpillFile(spillPath),
+		)
+		require.NoError(t, err)
+
+		var last *service.IngestTicket
+		for d := 1; d <= 4; d++ {
+			last, err = p.Enqueue(ctx, dayReading(d))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, service.TicketSpilled, last.Status)
+		assert.Equal(t, 3, p.Stats().Spilled)
+		spilled, ok := p.Ticket(last.ID)
+		require.True(t, ok, "spilled tickets are looked up in the spill file")
+		assert.Equal(t, service.TicketSpilled, spilled.Status)
+		assert.Equal(t, last.EnqueuedAt, spilled.EnqueuedAt)
+
+		stop := runPipeline(p)
+		require.Eventually(t, func() bool { return p.Stats().Stored == 4 }, time.Second, 5*time.Millisecond)
+		stop()
+
+		ticket, ok := p.Ticket(last.ID)
+		require.True(t, ok, "fed back readings are tracked again")
+		assert.Equal(t, service.TicketStored, ticket.Status)
+		assert.Equal(t, last.EnqueuedAt, ticket.EnqueuedAt)
+
+		assert.Equal(t, []int{1, 2, 3, 4}, rows.days())
+		content, err := os.ReadFile(spillPath)
+		require.NoError(t, err)
+		assert.Empty(t, content)
+	})
+
+	t.Run("spilled readings survive a restart", func(t *testing.T) {
+		repo := storage.NewMemoryRepository()
+		spillPath := filepath.Join(t.TempDir(), "spill.ndjson")
+		opts := []service.PipelineOption{
+			service.WithQueueSize(1),
+			service.WithBackpressure(service.BackpressureSpill),
+			service.WithSpillFile(spillPath),
+		}
+
+		first, err := service.NewIngestPipeline(service.NewIngestService(repo), opts...)
+		require.NoError(t, err)
+		var last *service.IngestTicket
+		for d := 1; d <= 3; d++ {
+			last, err = first.Enqueue(ctx, dayReading(d))
+			require.NoError(t, err)
+		}
+		// the first process never runs, as if it crashed: the queued reading is lost and the spilled ones stay on disk
+
+		second, err := service.NewIngestPipeline(service.NewIngestService(repo), opts...)
+		require.NoError(t, err)
+		assert.Equal(t, 2, second.Stats().Spilled)
+		spilled, ok := second.Ticket(last.ID)
+		require.True(t, ok, "spilled tickets can be looked up after a restart")
+		assert.Equal(t, service.TicketSpilled, spilled.Status)
+		stop := runPipeline(second)
+		require.Eventually(t, func() bool { return second.Stats().Stored == 2 }, time.Second, 5*time.Millisecond)
+		stop()
+		ticket, ok := second.Ticket(last.ID)
+		require.True(t, ok, "the ticket survives the restart with the reading")
+		assert.Equal(t, service.TicketStored, ticket.Status)
+
+		stored, err := repo.GetByDateRange(ctx, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.Len(t, stored, 2)
+	})
+}
+
+func TestIngestPipeline_ShutdownEndsRetries(t *testing.T) {
+	attempts := make(chan struct{}, 8)
+	ingestSvc := &MockIngestService{}
+	ingestSvc.On("IngestBatch", mock.Anything, mock.Anything).Run(func(mock.Arguments) { attempts <- struct{}{} }).
+		Return(nil, errors.New("database unreachable"))
+
+	p, err := service.NewIngestPipeline(ingestSvc, service.WithLinger(time.Millisecond))
+	require.NoError(t, err)
+	ticket, err := p.Enqueue(context.Background(), dayReading(1))
+	require.NoError(t, err)
+
+	stop := runPipeline(p)
+	select {
+	case <-attempts:
+	case <-time.After(time.Second):
+		t.Fatal("the reading was not written")
+	}
+	began := time.Now()
+	stop()
+	assert.Less(t, time.Since(began), 150*time.Millisecond, "the backoff does not hold up the shutdown")
+	assert.Empty(t, attempts)
+
+	completed, ok := p.Ticket(ticket.ID)
+	require.True(t, ok)
+	assert.Equal(t, service.TicketFailed, completed.Status)
+	assert.Equal(t, "database unreachable", completed.Error)
+}
+
+func TestHTTPHandler_AsyncIngestion(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	p, err := service.NewIngestPipeline(service.NewIngestService(repo),
+		service.WithQueueSize(1),
+		service.WithBackpressure(service.BackpressureReject),
+		service.WithLinger(time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(service.NewIngestService(repo), &MockQueryService{}, &MockStationService{}, &MockWebSocketHub{}, zap.NewNop(),
+		handler.WithIngestPipeline(p),
+	).RegisterRoutes(router)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/weather", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"date":"2023-01-01T00:00:00Z","temperature":20,"humidity":50}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var ticket service.IngestTicket
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&ticket))
+	assert.Equal(t, "/api/v1/ingest/"+ticket.ID, w.Header().Get("Location"))
+
+	t.Run("full queue answers 503", func(t *testing.T) {
+		w := post(`{"date":"2023-01-02T00:00:00Z","temperature":20,"humidity":50}`)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("invalid reading answers 400", func(t *testing.T) {
+		w := post(`{"date":"2023-01-02T00:00:00Z","temperature":20,"humidity":500}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("ticket and queue state", func(t *testing.T) {
+		stop := runPipeline(p)
+		defer stop()
+
+		require.Eventually(t, func() bool {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ingest/"+ticket.ID, nil))
+			var current service.IngestTicket
+			return w.Code == http.StatusOK && json.NewDecoder(w.Body).Decode(&current) == nil && current.Status == service.TicketStored
+		}, time.Second, 5*time.Millisecond)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ingest", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var stats service.PipelineStats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		assert.Equal(t, int64(1), stats.Stored)
+		assert.Equal(t, 1, stats.QueueCapacity)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ingest/unknown", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
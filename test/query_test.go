@@ -0,0 +1,164 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestQueryService_Aggregate(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	t.Run("requested metrics are passed in schema order", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("Aggregate", mock.Anything, from, to, storage.AggregateOptions{
+			Station: "berlin-1",
+			Bucket:  storage.BucketWeek,
+			Metrics: []storage.Metric{
+				{Field: "temperature", Op: storage.MetricAvg},
+				{Field: "temperature", Op: storage.MetricMax},
+				{Field: "humidity", Op: storage.MetricMin},
+			},
+		}).Return([]*model.AggregateBucket{}, nil).Once()
+
+		svc := service.NewQueryService(repo)
+		_, err := svc.Aggregate(context.Background(), from, to, service.AggregateOptions{
+			Station: "berlin-1",
+			Bucket:  storage.BucketWeek,
+			Metrics: map[string][]string{"humidity": {"min"}, "temperature": {"avg", "max"}},
+		})
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("repeated operations are computed once", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("Aggregate", mock.Anything, from, to, storage.AggregateOptions{
+			Bucket: storage.BucketDay,
+			Metrics: []storage.Metric{
+				{Field: "temperature", Op: storage.MetricAvg},
+				{Field: "temperature", Op: storage.MetricMax},
+			},
+		}).Return([]*model.AggregateBucket{}, nil).Once()
+
+		svc := service.NewQueryService(repo)
+		_, err := svc.Aggregate(context.Background(), from, to, service.AggregateOptions{
+			Bucket:  storage.BucketDay,
+			Metrics: map[string][]string{"temperature": {"avg", "avg", "max", "avg"}},
+		})
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("default metrics cover every numeric column", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("Aggregate", mock.Anything, from, to, mock.MatchedBy(func(opts storage.AggregateOptions) bool {
+			return len(opts.Metrics) == 6
+		})).Return([]*model.AggregateBucket{}, nil).Once()
+
+		svc := service.NewQueryService(repo)
+		_, err := svc.Aggregate(context.Background(), from, to, service.AggregateOptions{Bucket: storage.BucketMonth})
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	invalid := map[string]service.AggregateOptions{
+		"unknown bucket":    {Bucket: "decade"},
+		"unknown field":     {Bucket: storage.BucketDay, Metrics: map[string][]string{"pressure": {"avg"}}},
+		"unknown operation": {Bucket: storage.BucketDay, Metrics: map[string][]string{"temperature": {"median"}}},
+	}
+	for name, opts := range invalid {
+		t.Run(name, func(t *testing.T) {
+			repo := new(MockDBRepository)
+			svc := service.NewQueryService(repo)
+
+			_, err := svc.Aggregate(context.Background(), from, to, opts)
+			assert.ErrorIs(t, err, service.ErrInvalidAggregate)
+			repo.AssertNotCalled(t, "Aggregate")
+		})
+	}
+}
+
+func TestQueryService_StreamByDateRange(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	t.Run("multi-year ranges are allowed by default", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("StreamByDateRange", mock.Anything, from, to, mock.Anything).Return([]*model.WeatherData{
+			{Date: from, Values: map[string]any{"temperature": 22.5, "humidity": 75.5}},
+		}).Once()
+
+		svc := service.NewQueryService(repo)
+		records, err := svc.StreamByDateRange(context.Background(), from, to)
+		assert.NoError(t, err)
+
+		count := 0
+		for _, err := range records {
+			assert.NoError(t, err)
+			count++
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("configured limit is enforced", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		svc := service.NewQueryService(repo, service.WithMaxStreamRange(30*24*time.Hour))
+
+		_, err := svc.StreamByDateRange(context.Background(), from, to)
+		assert.ErrorContains(t, err, "30 days")
+		repo.AssertNotCalled(t, "StreamByDateRange")
+	})
+
+	t.Run("buffered queries keep the one year limit", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		svc := service.NewQueryService(repo)
+
+		_, err := svc.GetByDateRange(context.Background(), from, to)
+		assert.ErrorIs(t, err, service.ErrInvalidRange)
+		repo.AssertNotCalled(t, "GetByDateRange")
+	})
+}
+
+func TestQueryService_KeysetPagination(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+	last := &model.WeatherData{Station: "berlin-1", Date: time.Date(2023, 1, 10, 12, 0, 0, 0, time.UTC)}
+
+	t.Run("token is decoded into a storage cursor", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		repo.On("GetByDateRange", mock.Anything, from, to, mock.MatchedBy(func(opts []*storage.QueryOptions) bool {
+			o := opts[0]
+			return o.After != nil && o.After.Date.Equal(last.Date) && o.After.Station == "berlin-1" &&
+				o.Skip == nil && *o.Limit == 10
+		})).Return([]*model.WeatherData{}, nil).Once()
+
+		opts := &service.QueryOptions{}
+		opts.Pagination.Page = 3 // ignored once a token is given
+		opts.Pagination.Limit = 10
+		opts.Pagination.After = service.EncodeCursor(last)
+
+		svc := service.NewQueryService(repo)
+		_, err := svc.GetByDateRange(context.Background(), from, to, opts)
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		repo := new(MockDBRepository)
+		opts := &service.QueryOptions{}
+		opts.Pagination.After = "not-a-token"
+
+		svc := service.NewQueryService(repo)
+		_, err := svc.GetByDateRange(context.Background(), from, to, opts)
+		assert.ErrorIs(t, err, service.ErrInvalidCursor)
+		repo.AssertNotCalled(t, "GetByDateRange")
+	})
+}
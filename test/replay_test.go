@@ -0,0 +1,136 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/replay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer_Replay(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []*model.WeatherData
+		attempts = map[int]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/weather", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var data model.WeatherData
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		day := data.Date.Day()
+		attempts[day]++
+		switch {
+		case day == 2 && attempts[day] == 1:
+			// transient failure, succeeds on retry
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case day == 3:
+			// rejected, not retried
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			received = append(received, &data)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	input := "2023-01-01\t20.0\t50.0\n2023-01-02\t21.0\t51.0\nnot a row\n2023-01-03\t22.0\t52.0\n2023-01-04\t23.0\t53.0\n"
+	replayer := replay.New(server.URL,
+		replay.WithConcurrency(2),
+		replay.WithRetries(2),
+		replay.WithRetryBackoff(time.Millisecond),
+	)
+
+	report, err := replayer.Replay(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, report.Sent)
+	assert.Equal(t, 3, report.Succeeded)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Invalid)
+	assert.Equal(t, 1, report.Retries)
+	assert.Equal(t, map[int]int{http.StatusCreated: 3, http.StatusBadRequest: 1}, report.StatusCodes)
+	assert.Equal(t, 1, attempts[3])
+	assert.Len(t, received, 3)
+	assert.Greater(t, report.Latency().Max, time.Duration(0))
+
+	var out bytes.Buffer
+	require.NoError(t, report.Print(&out))
+	assert.Contains(t, out.String(), "throughput")
+}
+
+func TestReplayer_APIKey(t *testing.T) {
+	var authorization []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	input := "2023-01-01\t20.0\t50.0\n"
+	_, err := replay.New(server.URL).Replay(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+	_, err = replay.New(server.URL, replay.WithAPIKey("secret")).Replay(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"", "Bearer secret"}, authorization)
+}
+
+func TestReplayer_Pacing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	input := "2023-01-01\t20.0\t50.0\n2023-01-02\t21.0\t51.0\n2023-01-03\t22.0\t52.0\n"
+
+	t.Run("fixed rate", func(t *testing.T) {
+		report, err := replay.New(server.URL, replay.WithRate(20)).Replay(context.Background(), strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Succeeded)
+		// the third row is due 100ms after the first
+		assert.GreaterOrEqual(t, report.Elapsed, 100*time.Millisecond)
+	})
+
+	t.Run("speed-up of the real timestamps", func(t *testing.T) {
+		// one day of readings every 50ms
+		report, err := replay.New(server.URL, replay.WithSpeedup(float64(24*time.Hour/(50*time.Millisecond)))).Replay(context.Background(), strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Succeeded)
+		assert.GreaterOrEqual(t, report.Elapsed, 100*time.Millisecond)
+	})
+
+	t.Run("rate and speed-up are exclusive", func(t *testing.T) {
+		_, err := replay.New(server.URL, replay.WithRate(1), replay.WithSpeedup(2)).Replay(context.Background(), strings.NewReader(input))
+		assert.Error(t, err)
+	})
+
+	t.Run("cancellation returns a partial report", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		report, err := replay.New(server.URL, replay.WithRate(10)).Replay(ctx, strings.NewReader(input))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NotNil(t, report)
+		assert.Equal(t, 1, report.Succeeded)
+	})
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/config"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func ptrFloat(v float64) *float64 { return &v }
+
+// useWindSchema activates a schema with an additional nullable wind speed column for the duration of a test
+func useWindSchema(t *testing.T) {
+	t.Helper()
+
+	schema, err := config.BuildSchema(map[string]config.ColumnDefinition{
+		"Date":        {Position: 0, Type: "date"},
+		"Temperature": {Position: 1, Type: "float", Min: ptrFloat(-100), Max: ptrFloat(100)},
+		"Humidity":    {Position: 2, Type: "float", Min: ptrFloat(0), Max: ptrFloat(100)},
+		"WindSpeed":   {Position: 3, Field: "wind_speed", Type: "float", Min: ptrFloat(0), Nullable: true},
+		"Gusts":       {Position: 4, Type: "int"},
+	})
+	require.NoError(t, err)
+
+	previous := model.ActiveSchema()
+	model.SetSchema(schema)
+	t.Cleanup(func() { model.SetSchema(previous) })
+}
+
+func TestSchema_BuildValidation(t *testing.T) {
+	t.Run("positions must be contiguous", func(t *testing.T) {
+		_, err := config.BuildSchema(map[string]config.ColumnDefinition{
+			"Date":        {Position: 0, Type: "date"},
+			"Temperature": {Position: 2, Type: "float"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("date column is required", func(t *testing.T) {
+		_, err := config.BuildSchema(map[string]config.ColumnDefinition{
+			"Temperature": {Position: 0, Type: "float"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := config.BuildSchema(map[string]config.ColumnDefinition{
+			"Date":     {Position: 0, Type: "date"},
+			"Pressure": {Position: 1, Type: "decimal"},
+		})
+		assert.Error(t, err)
+	})
+
+	for _, field := range []string{"station", "ingestedBy"} {
+		t.Run("reserved field "+field, func(t *testing.T) {
+			_, err := config.BuildSchema(map[string]config.ColumnDefinition{
+				"Date":   {Position: 0, Type: "date"},
+				"Writer": {Position: 1, Type: "string", Field: field},
+			})
+			assert.ErrorContains(t, err, "reserved")
+		})
+	}
+}
+
+func TestSchema_ExtraColumns(t *testing.T) {
+	useWindSchema(t)
+
+	t.Run("file rows follow the configured columns", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", "weather_wind*.dat")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("2023-01-01\t22.5\t75.5\t12.5\t3\n2023-01-02\t23.5\t76.5\tNA\t4\n")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		repo := new(MockDBRepository)
+		repo.On("BulkUpsert", mock.Anything, mock.MatchedBy(func(data []*model.WeatherData) bool {
+			return len(data) == 2 &&
+				data[0].Values["wind_speed"] == 12.5 &&
+				data[0].Values["gusts"] == int64(3) &&
+				data[1].Values["wind_speed"] == nil
+		}), mock.Anything).Return(&storage.BulkResult{Upserted: 2}, nil).Once()
+
+		svc := service.NewIngestService(repo)
+		_, err = svc.IngestFile(context.Background(), tmpFile.Name())
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("validation uses configured ranges and nullability", func(t *testing.T) {
+		var data model.WeatherData
+		require.NoError(t, json.Unmarshal([]byte(`{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5,"wind_speed":-1,"gusts":2}`), &data))
+		assert.ErrorContains(t, data.Validate(), "wind_speed outside valid range")
+
+		require.NoError(t, json.Unmarshal([]byte(`{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5,"gusts":2}`), &data))
+		assert.NoError(t, data.Validate())
+
+		require.NoError(t, json.Unmarshal([]byte(`{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5}`), &data))
+		assert.ErrorContains(t, data.Validate(), "gusts is required")
+
+		assert.Error(t, json.Unmarshal([]byte(`{"date":"2023-01-01T00:00:00Z","gusts":2.5}`), &data))
+	})
+
+	t.Run("JSON output follows column order", func(t *testing.T) {
+		var data model.WeatherData
+		require.NoError(t, json.Unmarshal([]byte(`{"gusts":2,"wind_speed":3.5,"humidity":75.5,"temperature":22.5,"date":"2023-01-01T00:00:00Z","ignored":true}`), &data))
+
+		encoded, err := json.Marshal(data)
+		require.NoError(t, err)
+		assert.Equal(t, `{"date":"2023-01-01T00:00:00Z","temperature":22.5,"humidity":75.5,"wind_speed":3.5,"gusts":2}`, string(encoded))
+	})
+}
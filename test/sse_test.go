@@ -0,0 +1,192 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/handler"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// sseEvent is one server-sent event, comment holds the text of a comment line instead
+type sseEvent struct {
+	event   string
+	id      string
+	data    map[string]any
+	comment string
+}
+
+type sseStream struct {
+	t      *testing.T
+	resp   *http.Response
+	events chan sseEvent
+}
+
+func openStream(t *testing.T, url string, header http.Header) *sseStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	s := &sseStream{t: t, resp: resp, events: make(chan sseEvent, 64)}
+	go func() {
+		defer close(s.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				s.events <- ev
+				ev = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				ev.comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.data); err != nil {
+					t.Errorf("invalid data %q: %v", line, err)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// next returns the next event, skipping keepalive comments
+func (s *sseStream) next() sseEvent {
+	for {
+		select {
+		case ev, ok := <-s.events:
+			require.True(s.t, ok, "stream closed")
+			if ev.comment == "" {
+				return ev
+			}
+		case <-time.After(2 * time.Second):
+			s.t.Fatal("timeout waiting for event")
+		}
+	}
+}
+
+func TestWebSocketHub_Stream(t *testing.T) {
+	hub := handler.NewWebSocketHub(zap.NewNop(), handler.WithStreamKeepalive(20*time.Millisecond))
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleStream))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	reading := func(station string, day int, temperature float64) *model.WeatherData {
+		return &model.WeatherData{Station: station, Date: time.Date(2023, 1, day, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": temperature, "humidity": 50.0}}
+	}
+	hub.Broadcast(reading("berlin", 1, 10))
+	hub.Broadcast(reading("oslo", 1, -2))
+	time.Sleep(50 * time.Millisecond)
+
+	t.Run("snapshot, live readings and deletions", func(t *testing.T) {
+		stream := openStream(t, server.URL+"?stations=berlin&fields=temperature", nil)
+		assert.Equal(t, "text/event-stream", stream.resp.Header.Get("Content-Type"))
+		assert.Equal(t, "no-cache", stream.resp.Header.Get("Cache-Control"))
+
+		ev := stream.next()
+		assert.Equal(t, "snapshot", ev.event)
+		assert.Equal(t, seq(hub, 2), ev.id)
+		assert.Equal(t, []any{map[string]any{"station": "berlin", "date": "2023-01-01T00:00:00Z", "temperature": 10.0}}, ev.data["readings"])
+
+		hub.Broadcast(reading("oslo", 2, 1))
+		hub.Broadcast(reading("berlin", 2, 12))
+		ev = stream.next()
+		assert.Equal(t, "", ev.event)
+		assert.Equal(t, seq(hub, 4), ev.id)
+		assert.Equal(t, map[string]any{"seq": seq(hub, 4), "station": "berlin", "date": "2023-01-02T00:00:00Z", "temperature": 12.0}, ev.data)
+
+		hub.BroadcastDeleted(reading("berlin", 2, 0))
+		ev = stream.next()
+		assert.Equal(t, "deleted", ev.event)
+		assert.Equal(t, seq(hub, 5), ev.id)
+	})
+
+	t.Run("resume from Last-Event-ID", func(t *testing.T) {
+		stream := openStream(t, server.URL+"?since="+seq(hub, 1), http.Header{"Last-Event-ID": {seq(hub, 3)}})
+		ev := stream.next()
+		assert.Equal(t, seq(hub, 4), ev.id, "the header wins over ?since=")
+		assert.Equal(t, seq(hub, 5), stream.next().id)
+
+		stream = openStream(t, server.URL+"?since="+seq(hub, 4), nil)
+		assert.Equal(t, seq(hub, 5), stream.next().id)
+	})
+
+	t.Run("keepalive comments", func(t *testing.T) {
+		stream := openStream(t, server.URL+"?stations=none", nil)
+		select {
+		case ev := <-stream.events:
+			assert.Equal(t, "keepalive", ev.comment)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no keepalive")
+		}
+	})
+
+	t.Run("clients share the hub", func(t *testing.T) {
+		// streams of the previous subtests end when their requests are cancelled
+		require.Eventually(t, func() bool { return hub.Stats().Clients == 0 }, time.Second, 10*time.Millisecond)
+		stream := openStream(t, server.URL+"?stations=rome", nil)
+		require.Eventually(t, func() bool { return hub.Stats().Clients == 1 }, time.Second, 10*time.Millisecond)
+
+		var transports []string
+		for _, c := range hub.Stats().Connections {
+			transports = append(transports, c.Transport)
+		}
+		assert.Contains(t, transports, "sse")
+
+		stream.resp.Body.Close()
+		assert.Eventually(t, func() bool { return hub.Stats().Clients == 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?since=abc")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = http.Get(server.URL + "?where=unknown%3E1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestHTTPHandler_StreamRoute(t *testing.T) {
+	wsHub := &MockWebSocketHub{}
+	wsHub.On("HandleStream", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(http.ResponseWriter).WriteHeader(http.StatusNoContent)
+	}).Return()
+
+	router := mux.NewRouter()
+	handler.NewHTTPHandler(&MockIngestService{}, &MockQueryService{}, &MockStationService{}, wsHub, zap.NewNop()).RegisterRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/weather/stream", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code, "not taken for a date")
+	wsHub.AssertNumberOfCalls(t, "HandleStream", 1)
+}
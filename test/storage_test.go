@@ -0,0 +1,526 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// embeddedBackends returns a fresh repository of every backend that runs without external services
+func embeddedBackends(t *testing.T) map[string]storage.Repository {
+	t.Helper()
+
+	bolt, err := storage.NewBoltRepository(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { bolt.CloseConnection(context.Background()) })
+
+	return map[string]storage.Repository{
+		storage.BackendMemory: storage.NewMemoryRepository(),
+		storage.BackendBolt:   bolt,
+	}
+}
+
+// testBackends returns the embedded backends and, if MONGO_TEST_URI is set, a MongoDB repository
+// on a throwaway database that is dropped when the test ends
+func testBackends(t *testing.T) map[string]storage.Repository {
+	t.Helper()
+
+	backends := embeddedBackends(t)
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		return backends
+	}
+	client, err := storage.Connect(context.Background(), uri)
+	require.NoError(t, err)
+	database := fmt.Sprintf("take-home-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		client.Database(database).Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	backends[storage.BackendMongo] = storage.NewMongoDBRepository(client, storage.WithDatabase(database))
+	return backends
+}
+
+func reading(station string, date time.Time, temperature, humidity float64) *model.WeatherData {
+	return &model.WeatherData{
+		Station: station,
+		Date:    date,
+		Values:  map[string]any{"temperature": temperature, "humidity": humidity},
+	}
+}
+
+func TestEmbeddedRepositories(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	for name, repo := range embeddedBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			result, err := repo.BulkUpsert(ctx, []*model.WeatherData{
+				reading("oslo", day(2), 1, 80),
+				reading("berlin", day(2), 5, 70),
+				reading("berlin", day(1), 4, 75),
+				reading("berlin", day(3), 6, 65),
+				reading("berlin", time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), -2, 90),
+			}, storage.DefaultBulkOptions())
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), result.Upserted)
+
+			t.Run("range is sorted by date and station", func(t *testing.T) {
+				data, err := repo.GetByDateRange(ctx, day(1), day(3))
+				require.NoError(t, err)
+				require.Len(t, data, 4)
+				assert.Equal(t, reading("berlin", day(1), 4, 75), data[0])
+				assert.Equal(t, "berlin", data[1].Station)
+				assert.Equal(t, "oslo", data[2].Station)
+				assert.Equal(t, day(3), data[3].Date)
+			})
+
+			t.Run("dates before 1970 sort first", func(t *testing.T) {
+				data, err := repo.GetByDateRange(ctx, time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), day(1))
+				require.NoError(t, err)
+				require.Len(t, data, 2)
+				assert.Equal(t, 1969, data[0].Date.Year())
+			})
+
+			t.Run("upsert merges fields like $set", func(t *testing.T) {
+				result, err := repo.BulkUpsert(ctx, []*model.WeatherData{
+					{Station: "berlin", Date: day(1), Values: map[string]any{"temperature": 4.5}},
+					reading("oslo", day(2), 1, 80),
+				}, storage.DefaultBulkOptions())
+				require.NoError(t, err)
+				assert.Equal(t, int64(2), result.Matched)
+				assert.Equal(t, int64(1), result.Modified)
+
+				data, err := repo.GetByDate(ctx, day(1))
+				require.NoError(t, err)
+				require.Len(t, data, 1)
+				assert.Equal(t, reading("berlin", day(1), 4.5, 75), data[0])
+			})
+
+			t.Run("station, keyset and projection", func(t *testing.T) {
+				limit := int64(1)
+				data, err := repo.GetByDateRange(ctx, day(1), day(3), &storage.QueryOptions{
+					Station:    "berlin",
+					After:      &storage.Cursor{Date: day(1), Station: "berlin"},
+					Projection: map[string]any{"humidity": 1, "date": 1, "station": 1, "_id": 0},
+					Limit:      &limit,
+				})
+				require.NoError(t, err)
+				require.Len(t, data, 1)
+				assert.Equal(t, &model.WeatherData{Station: "berlin", Date: day(2), Values: map[string]any{"humidity": 70.0}}, data[0])
+
+				unscoped, err := repo.GetByDateRange(ctx, day(1), day(3), &storage.QueryOptions{
+					After: &storage.Cursor{Date: day(2), Station: "berlin"},
+				})
+				require.NoError(t, err)
+				require.Len(t, unscoped, 2)
+				assert.Equal(t, "oslo", unscoped[0].Station)
+			})
+
+			t.Run("count and stream", func(t *testing.T) {
+				count, err := repo.CountByDateRange(ctx, day(1), day(3), &storage.QueryOptions{Station: "berlin"})
+				require.NoError(t, err)
+				assert.Equal(t, int64(3), count)
+
+				skip := int64(1)
+				var streamed []*model.WeatherData
+				for data, err := range repo.StreamByDateRange(ctx, day(1), day(3), &storage.QueryOptions{Skip: &skip}) {
+					require.NoError(t, err)
+					streamed = append(streamed, data)
+				}
+				assert.Len(t, streamed, 3)
+			})
+
+			t.Run("aggregate", func(t *testing.T) {
+				buckets, err := repo.Aggregate(ctx, day(1), day(3), storage.AggregateOptions{
+					Station: "berlin",
+					Bucket:  storage.BucketWeek,
+					Metrics: []storage.Metric{{Field: "temperature", Op: storage.MetricMax}, {Field: "humidity", Op: storage.MetricAvg}},
+				})
+				require.NoError(t, err)
+				// 2023-01-01 is a Sunday, the next week starts on Monday the 2nd
+				require.Len(t, buckets, 2)
+				assert.Equal(t, time.Date(2022, 12, 26, 0, 0, 0, 0, time.UTC), buckets[0].Start)
+				assert.Equal(t, int64(1), buckets[0].Count)
+				assert.Equal(t, int64(2), buckets[1].Count)
+				assert.Equal(t, 6.0, *buckets[1].Metrics["temperature"][storage.MetricMax])
+				assert.Equal(t, 67.5, *buckets[1].Metrics["humidity"][storage.MetricAvg])
+			})
+
+			t.Run("stations", func(t *testing.T) {
+				_, err := repo.GetStation(ctx, "berlin")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				require.NoError(t, repo.UpsertStation(ctx, &model.Station{ID: "oslo", Name: "Oslo"}))
+				require.NoError(t, repo.UpsertStation(ctx, &model.Station{ID: "berlin", Name: "Berlin"}))
+
+				station, err := repo.GetStation(ctx, "berlin")
+				require.NoError(t, err)
+				assert.Equal(t, "Berlin", station.Name)
+
+				stations, err := repo.ListStations(ctx)
+				require.NoError(t, err)
+				require.Len(t, stations, 2)
+				assert.Equal(t, "berlin", stations[0].ID)
+			})
+
+			t.Run("checkpoints", func(t *testing.T) {
+				_, err := repo.GetCheckpoint(ctx, "weather.dat@abc")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				checkpoint := &model.IngestCheckpoint{ID: "weather.dat@abc", Path: "weather.dat", Hash: "abc", Status: model.RunRunning, Offset: 42, Line: 3}
+				require.NoError(t, repo.SaveCheckpoint(ctx, checkpoint))
+				found, err := repo.GetCheckpoint(ctx, checkpoint.ID)
+				require.NoError(t, err)
+				assert.Equal(t, checkpoint, found)
+			})
+
+			t.Run("idempotency keys", func(t *testing.T) {
+				now := time.Now().UTC().Truncate(time.Millisecond)
+				claim := &model.IdempotencyRecord{Key: "k1", RequestHash: "a", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+				existing, err := repo.ClaimIdempotencyKey(ctx, claim)
+				require.NoError(t, err)
+				assert.Nil(t, existing)
+
+				existing, err = repo.ClaimIdempotencyKey(ctx, &model.IdempotencyRecord{Key: "k1", RequestHash: "b", CreatedAt: now, ExpiresAt: now.Add(time.Minute)})
+				require.NoError(t, err)
+				require.NotNil(t, existing)
+				assert.Equal(t, "a", existing.RequestHash)
+
+				// an expired record can be claimed again
+				require.NoError(t, repo.SaveIdempotencyKey(ctx, &model.IdempotencyRecord{Key: "k1", RequestHash: "a", Completed: true, Status: 201, ExpiresAt: now.Add(-time.Second)}))
+				existing, err = repo.ClaimIdempotencyKey(ctx, claim)
+				require.NoError(t, err)
+				assert.Nil(t, existing)
+
+				require.NoError(t, repo.DeleteIdempotencyKey(ctx, "k1"))
+				existing, err = repo.ClaimIdempotencyKey(ctx, claim)
+				require.NoError(t, err)
+				assert.Nil(t, existing)
+			})
+
+			t.Run("conflict policies", func(t *testing.T) {
+				opts := storage.DefaultBulkOptions()
+				opts.Ordered = false
+				opts.Conflict = storage.ConflictPolicy{
+					Default:  storage.ConflictFirstWriteWins,
+					Stations: map[string]string{"rome": storage.ConflictReject, "paris": storage.ConflictRevisions},
+				}
+				write := func(data ...*model.WeatherData) *storage.BulkResult {
+					result, err := repo.BulkUpsert(ctx, data, opts)
+					require.NoError(t, err)
+					return result
+				}
+
+				result := write(reading("madrid", day(10), 10, 50), reading("rome", day(10), 10, 50), reading("paris", day(10), 10, 50))
+				assert.Equal(t, []string{storage.OutcomeInserted, storage.OutcomeInserted, storage.OutcomeInserted}, result.Outcomes)
+
+				result = write(
+					reading("madrid", day(10), 11, 50),
+					reading("rome", day(10), 10, 50),
+					reading("rome", day(10), 11, 50),
+					reading("paris", day(10), 11, 50),
+				)
+				assert.Equal(t, []string{storage.OutcomeKept, storage.OutcomeUnchanged, storage.OutcomeConflict, storage.OutcomeRevised}, result.Outcomes)
+				require.Len(t, result.Errors, 1)
+				assert.Equal(t, 2, result.Errors[0].Index)
+				assert.True(t, result.Errors[0].Conflict)
+
+				data, err := repo.GetByDate(ctx, day(10))
+				require.NoError(t, err)
+				require.Len(t, data, 3)
+				for _, d := range data {
+					want := 10.0
+					if d.Station == "paris" {
+						want = 11
+					}
+					assert.Equal(t, want, d.Values["temperature"], d.Station)
+				}
+
+				revisions, err := repo.ListRevisions(ctx, day(10), day(10), "paris")
+				require.NoError(t, err)
+				require.Len(t, revisions, 1)
+				assert.Equal(t, storage.OutcomeRevised, revisions[0].Change)
+				assert.Equal(t, 10.0, revisions[0].Previous["temperature"])
+				assert.Equal(t, 11.0, revisions[0].Values["temperature"])
+				assert.Equal(t, day(10), revisions[0].Date)
+
+				revisions, err = repo.ListRevisions(ctx, day(10), day(10), "madrid")
+				require.NoError(t, err)
+				assert.Empty(t, revisions)
+			})
+
+			t.Run("revision history", func(t *testing.T) {
+				opts := storage.DefaultBulkOptions()
+				opts.History = true
+				opts.Source = "gateway"
+				for _, temperature := range []float64{10, 10, 12} {
+					_, err := repo.BulkUpsert(ctx, []*model.WeatherData{reading("lisbon", day(20), temperature, 50)}, opts)
+					require.NoError(t, err)
+				}
+				_, err := repo.BulkUpsert(ctx, []*model.WeatherData{reading("athens", day(20), 30, 20)}, opts)
+				require.NoError(t, err)
+
+				revisions, err := repo.ListRevisions(ctx, day(19), day(21), "")
+				require.NoError(t, err)
+				require.Len(t, revisions, 3)
+				assert.Equal(t, "athens", revisions[0].Station)
+				assert.Equal(t, storage.OutcomeInserted, revisions[1].Change)
+				assert.Nil(t, revisions[1].Previous)
+				assert.Equal(t, storage.OutcomeUpdated, revisions[2].Change)
+				assert.Equal(t, 10.0, revisions[2].Previous["temperature"])
+				assert.Equal(t, 12.0, revisions[2].Values["temperature"])
+				assert.Equal(t, "gateway", revisions[2].Source)
+				assert.False(t, revisions[2].IngestedAt.Before(revisions[1].IngestedAt))
+
+				revisions, err = repo.ListRevisions(ctx, day(20), day(20), "lisbon")
+				require.NoError(t, err)
+				assert.Len(t, revisions, 2)
+			})
+
+			t.Run("api keys", func(t *testing.T) {
+				expires := day(30)
+				for _, key := range []*model.APIKey{
+					{ID: "b", Hash: "hash-b", Scopes: []string{model.ScopeRead}, CreatedAt: day(1)},
+					{ID: "a", Hash: "hash-a", Scopes: []string{model.ScopeIngest}, CreatedAt: day(1), ExpiresAt: &expires},
+				} {
+					require.NoError(t, repo.SaveAPIKey(ctx, key))
+				}
+
+				key, err := repo.FindAPIKeyByHash(ctx, "hash-a")
+				require.NoError(t, err)
+				assert.Equal(t, "a", key.ID)
+				assert.Equal(t, []string{model.ScopeIngest}, key.Scopes)
+				require.NotNil(t, key.ExpiresAt)
+				assert.True(t, expires.Equal(*key.ExpiresAt))
+
+				_, err = repo.FindAPIKeyByHash(ctx, "unknown")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+				_, err = repo.GetAPIKey(ctx, "unknown")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				key.Hash = "hash-a2"
+				require.NoError(t, repo.SaveAPIKey(ctx, key))
+				_, err = repo.FindAPIKeyByHash(ctx, "hash-a")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				keys, err := repo.ListAPIKeys(ctx)
+				require.NoError(t, err)
+				require.Len(t, keys, 2)
+				assert.Equal(t, "a", keys[0].ID)
+				assert.Equal(t, "hash-a2", keys[0].Hash)
+			})
+
+			t.Run("replace, patch and delete", func(t *testing.T) {
+				opts := storage.DefaultBulkOptions()
+				opts.History = true
+
+				outcome, err := repo.ReplaceReading(ctx, reading("vienna", day(25), 10, 50), opts)
+				require.NoError(t, err)
+				assert.Equal(t, storage.OutcomeInserted, outcome)
+
+				replacement := &model.WeatherData{Station: "vienna", Date: day(25), Values: map[string]any{"temperature": 12.0}}
+				outcome, err = repo.ReplaceReading(ctx, replacement, opts)
+				require.NoError(t, err)
+				assert.Equal(t, storage.OutcomeUpdated, outcome)
+
+				outcome, err = repo.ReplaceReading(ctx, replacement, opts)
+				require.NoError(t, err)
+				assert.Equal(t, storage.OutcomeUnchanged, outcome)
+
+				data, err := repo.GetByDate(ctx, day(25))
+				require.NoError(t, err)
+				require.Len(t, data, 1)
+				assert.Equal(t, map[string]any{"temperature": 12.0}, data[0].Values, "replace removes missing columns")
+
+				patched, err := repo.PatchReading(ctx, "vienna", day(25), map[string]any{"humidity": 40.0, "temperature": nil}, opts)
+				require.NoError(t, err)
+				assert.Equal(t, map[string]any{"humidity": 40.0}, patched.Values)
+				assert.Equal(t, day(25), patched.Date)
+
+				_, err = repo.PatchReading(ctx, "vienna", day(26), map[string]any{"humidity": 40.0}, opts)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				_, err = repo.ReplaceReading(ctx, reading("vienna", day(26), 1, 1), opts)
+				require.NoError(t, err)
+				_, err = repo.ReplaceReading(ctx, reading("zurich", day(26), 1, 1), opts)
+				require.NoError(t, err)
+
+				deleted, err := repo.DeleteReadings(ctx, day(25), day(26), "vienna", opts)
+				require.NoError(t, err)
+				require.Len(t, deleted, 2)
+				assert.Equal(t, day(25), deleted[0].Date)
+				assert.Equal(t, map[string]any{"humidity": 40.0}, deleted[0].Values)
+
+				data, err = repo.GetByDateRange(ctx, day(25), day(26))
+				require.NoError(t, err)
+				require.Len(t, data, 1)
+				assert.Equal(t, "zurich", data[0].Station)
+
+				revisions, err := repo.ListRevisions(ctx, day(25), day(25), "vienna")
+				require.NoError(t, err)
+				require.Len(t, revisions, 4)
+				changes := make([]string, len(revisions))
+				for i, revision := range revisions {
+					changes[i] = revision.Change
+				}
+				assert.Equal(t, []string{storage.OutcomeInserted, storage.OutcomeUpdated, storage.OutcomeUpdated, storage.OutcomeDeleted}, changes)
+				assert.Nil(t, revisions[3].Values)
+				assert.Equal(t, map[string]any{"humidity": 40.0}, revisions[3].Previous)
+			})
+		})
+	}
+}
+
+func TestBoltRepository_Persists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "weather.db")
+	date := time.Date(2023, 1, 1, 12, 30, 0, 0, time.UTC)
+
+	repo, err := storage.NewBoltRepository(path)
+	require.NoError(t, err)
+	_, err = repo.BulkUpsert(ctx, []*model.WeatherData{reading("berlin", date, 4, 75)}, storage.BulkOptions{})
+	require.NoError(t, err)
+	require.NoError(t, repo.CloseConnection(ctx))
+
+	reopened, err := storage.NewBoltRepository(path)
+	require.NoError(t, err)
+	defer reopened.CloseConnection(ctx)
+
+	data, err := reopened.GetByDate(ctx, date)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	assert.Equal(t, reading("berlin", date, 4, 75), data[0])
+}
+
+func TestRepositories_DuplicateReadingsInBatch(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	at := func(d, hour int) time.Time { return day(d).Add(time.Duration(hour) * time.Hour) }
+
+	for name, repo := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			history := storage.BulkOptions{Ordered: true, History: true}
+
+			t.Run("last write wins folds resent and daily rows", func(t *testing.T) {
+				// rows of one day truncated to midnight, as with DAILY_UPSERT=true
+				result, err := repo.BulkUpsert(ctx, []*model.WeatherData{
+					reading("berlin", day(1), 20, 50),
+					reading("berlin", day(1), 21, 50),
+					reading("oslo", day(1), 1, 80),
+					reading("berlin", day(1), 21, 50),
+				}, history)
+				require.NoError(t, err)
+				assert.Empty(t, result.Errors)
+				assert.Equal(t, []string{storage.OutcomeInserted, storage.OutcomeUpdated, storage.OutcomeInserted, storage.OutcomeUnchanged}, result.Outcomes)
+
+				data, err := repo.GetByDate(ctx, day(1))
+				require.NoError(t, err)
+				require.Len(t, data, 2)
+				assert.Equal(t, 21.0, data[0].Values["temperature"])
+
+				revisions, err := repo.ListRevisions(ctx, day(1), day(1), "berlin")
+				require.NoError(t, err)
+				require.Len(t, revisions, 2)
+				assert.Equal(t, 20.0, revisions[1].Previous["temperature"], "each change supersedes the previous row of the batch")
+			})
+
+			t.Run("stored readings are changed once per batch", func(t *testing.T) {
+				result, err := repo.BulkUpsert(ctx, []*model.WeatherData{
+					reading("berlin", day(1), 22, 50),
+					reading("berlin", day(1), 23, 50),
+				}, history)
+				require.NoError(t, err)
+				assert.Empty(t, result.Errors)
+				assert.Equal(t, []string{storage.OutcomeUpdated, storage.OutcomeUpdated}, result.Outcomes)
+
+				data, err := repo.GetByDateRange(ctx, day(1), day(1), &storage.QueryOptions{Station: "berlin"})
+				require.NoError(t, err)
+				require.Len(t, data, 1)
+				assert.Equal(t, 23.0, data[0].Values["temperature"])
+
+				revisions, err := repo.ListRevisions(ctx, day(1), day(1), "berlin")
+				require.NoError(t, err)
+				assert.Len(t, revisions, 4)
+			})
+
+			t.Run("other policies see the earlier rows of the batch", func(t *testing.T) {
+				opts := storage.BulkOptions{Ordered: false, Conflict: storage.ConflictPolicy{
+					Default:  storage.ConflictReject,
+					Stations: map[string]string{"oslo": storage.ConflictFirstWriteWins, "rome": storage.ConflictRevisions},
+				}}
+				result, err := repo.BulkUpsert(ctx, []*model.WeatherData{
+					reading("berlin", at(2, 1), 20, 50),
+					reading("berlin", at(2, 1), 20, 50),
+					reading("berlin", at(2, 1), 25, 50),
+					reading("oslo", at(2, 1), 1, 80),
+					reading("oslo", at(2, 1), 2, 80),
+					reading("rome", at(2, 1), 10, 60),
+					reading("rome", at(2, 1), 11, 60),
+				}, opts)
+				require.NoError(t, err)
+				assert.Equal(t, []string{
+					storage.OutcomeInserted, storage.OutcomeUnchanged, storage.OutcomeConflict,
+					storage.OutcomeInserted, storage.OutcomeKept,
+					storage.OutcomeInserted, storage.OutcomeRevised,
+				}, result.Outcomes)
+				require.Len(t, result.Errors, 1)
+				assert.Equal(t, 2, result.Errors[0].Index)
+				assert.True(t, result.Errors[0].Conflict)
+
+				data, err := repo.GetByDateRange(ctx, at(2, 1), at(2, 1))
+				require.NoError(t, err)
+				require.Len(t, data, 3)
+				assert.Equal(t, 20.0, data[0].Values["temperature"])
+				assert.Equal(t, 1.0, data[1].Values["temperature"])
+				assert.Equal(t, 11.0, data[2].Values["temperature"])
+			})
+		})
+	}
+}
+
+func TestRepositories_IngestedBy(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2023, 1, 5, 0, 0, 0, 0, time.UTC)
+	stored := func(t *testing.T, repo storage.Repository) *model.WeatherData {
+		data, err := repo.GetByDateRange(ctx, date, date, &storage.QueryOptions{Station: "berlin"})
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		return data[0]
+	}
+
+	for name, repo := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, history := range []bool{false, true} {
+				spoofed := reading("berlin", date, 20, 50)
+				spoofed.IngestedBy = "key:someone-else"
+				_, err := repo.BulkUpsert(ctx, []*model.WeatherData{spoofed}, storage.BulkOptions{History: history, Source: "key:first"})
+				require.NoError(t, err)
+				assert.Equal(t, "key:first", stored(t, repo).IngestedBy, "the writer is recorded, whatever the record says")
+
+				_, err = repo.BulkUpsert(ctx, []*model.WeatherData{reading("berlin", date, 20, 50)}, storage.BulkOptions{History: history, Source: "key:second"})
+				require.NoError(t, err)
+				assert.Equal(t, "key:first", stored(t, repo).IngestedBy, "a write without a change keeps the writer")
+
+				_, err = repo.PatchReading(ctx, "berlin", date, map[string]any{"humidity": 55.0}, storage.BulkOptions{Source: "key:second"})
+				require.NoError(t, err)
+				assert.Equal(t, "key:second", stored(t, repo).IngestedBy)
+
+				_, err = repo.ReplaceReading(ctx, reading("berlin", date, 21, 50), storage.BulkOptions{Source: "key:third"})
+				require.NoError(t, err)
+				assert.Equal(t, "key:third", stored(t, repo).IngestedBy)
+
+				_, err = repo.DeleteReadings(ctx, date, date, "berlin", storage.BulkOptions{})
+				require.NoError(t, err)
+			}
+		})
+	}
+}
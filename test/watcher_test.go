@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/events"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/model"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/service"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/storage"
+	"github.com/francescorizzello94/senior-fullstack-engineer-takehome/internal/take-home/watcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// rowRecorder collects the readings announced as stored, see service.WithEvents
+type rowRecorder struct {
+	mu   sync.Mutex
+	rows []*model.WeatherData
+}
+
+func (r *rowRecorder) Publish(ctx context.Context, evs ...events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range evs {
+		if event.Type == events.TypeStored {
+			r.rows = append(r.rows, event.Reading)
+		}
+	}
+	return nil
+}
+
+func (r *rowRecorder) days() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	days := make([]int, len(r.rows))
+	for i, row := range r.rows {
+		days[i] = row.Date.Day()
+	}
+	return days
+}
+
+func appendToFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func TestIngestService_FollowFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "station.dat")
+	appendToFile(t, path, "2023-01-01\t20.0\t50.0\n")
+
+	rows := &rowRecorder{}
+	svc := service.NewIngestService(storage.NewMemoryRepository(), service.WithEvents(rows, zap.NewNop()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.FollowFile(ctx, path, &service.FileOptions{PollInterval: 5 * time.Millisecond})
+	}()
+
+	require.Eventually(t, func() bool { return len(rows.days()) == 1 }, time.Second, 5*time.Millisecond)
+
+	// a row is only picked up once its line is complete
+	appendToFile(t, path, "2023-01-02\t21.0")
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, []int{1}, rows.days())
+	appendToFile(t, path, "\t51.0\nnot a row\n2023-01-03\t22.0\t52.0\n")
+	require.Eventually(t, func() bool { return len(rows.days()) == 3 }, time.Second, 5*time.Millisecond)
+
+	// rotation: the old file is renamed, a new one takes its place
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendToFile(t, path, "2023-01-04\t23.0\t53.0\n")
+	require.Eventually(t, func() bool { return len(rows.days()) == 4 }, time.Second, 5*time.Millisecond)
+
+	// truncation (the file shrank) starts over at the beginning of the file
+	require.NoError(t, os.WriteFile(path, []byte("2023-01-05\t4\t54\n"), 0o644))
+	require.Eventually(t, func() bool { return len(rows.days()) == 5 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, rows.days())
+}
+
+func TestWatcher_Inbox(t *testing.T) {
+	inbox := t.TempDir()
+	repo := storage.NewMemoryRepository()
+	rows := &rowRecorder{}
+	svc := service.NewIngestService(repo, service.WithEvents(rows, zap.NewNop()))
+
+	w := watcher.New(svc, zap.NewNop(),
+		watcher.WithInbox(inbox),
+		watcher.WithInterval(5*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.NoError(t, os.WriteFile(filepath.Join(inbox, "good.dat"), []byte("2023-01-01\t20.0\t50.0\n2023-01-02\t21.0\t51.0\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(inbox, "bad.dat"), []byte("2023-01-03\tabc\t50.0\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(inbox, "upload.dat.part"), []byte("2023-01-04\t20.0\t50.0\n"), 0o644))
+
+	require.Eventually(t, func() bool {
+		_, goodErr := os.Stat(filepath.Join(inbox, "done", "good.dat"))
+		_, badErr := os.Stat(filepath.Join(inbox, "failed", "bad.dat"))
+		return goodErr == nil && badErr == nil
+	}, 2*time.Second, 5*time.Millisecond)
+
+	assert.ElementsMatch(t, []int{1, 2}, rows.days())
+	_, err := os.Stat(filepath.Join(inbox, "upload.dat.part"))
+	assert.NoError(t, err, "partial uploads stay in the inbox")
+
+	stored, err := repo.GetByDateRange(context.Background(), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+}
@@ -2,8 +2,10 @@ package test
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -37,9 +39,8 @@ func TestWebSocketHub_Broadcast(t *testing.T) {
 	hub := handler.NewWebSocketHub(logger)
 
 	data := &model.WeatherData{
-		Date:        time.Now().UTC(),
-		Temperature: 22.5,
-		Humidity:    75.5,
+		Date:   time.Now().UTC(),
+		Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 	}
 
 	// test that broadcast doesn't panic when there are no clients
@@ -72,9 +73,8 @@ func TestWebSocketHub_Integration(t *testing.T) {
 	defer ws.Close()
 
 	testData := &model.WeatherData{
-		Date:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
-		Temperature: 22.5,
-		Humidity:    75.5,
+		Date:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Values: map[string]any{"temperature": 22.5, "humidity": 75.5},
 	}
 
 	type wsResponse struct {
@@ -95,9 +95,454 @@ func TestWebSocketHub_Integration(t *testing.T) {
 	case resp := <-responseChan:
 		assert.NoError(t, resp.err)
 		assert.Equal(t, testData.Date.Format(time.RFC3339), resp.data.Date.Format(time.RFC3339))
-		assert.Equal(t, testData.Temperature, resp.data.Temperature)
-		assert.Equal(t, testData.Humidity, resp.data.Humidity)
+		assert.Equal(t, testData.Values["temperature"], resp.data.Values["temperature"])
+		assert.Equal(t, testData.Values["humidity"], resp.data.Values["humidity"])
 	case <-time.After(2 * time.Second):
 		t.Fatal("Timeout waiting for WebSocket response")
 	}
 }
+
+func TestWebSocketHub_StationSubscription(t *testing.T) {
+	logger := zap.NewNop()
+	hub := handler.NewWebSocketHub(logger)
+
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?stations=hamburg-1"
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	// give the hub a moment to register the client before broadcasting
+	time.Sleep(50 * time.Millisecond)
+
+	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	hub.Broadcast(&model.WeatherData{Station: "berlin-1", Date: date, Values: map[string]any{"temperature": 10, "humidity": 50}})
+	hub.Broadcast(&model.WeatherData{Station: "hamburg-1", Date: date, Values: map[string]any{"temperature": 12, "humidity": 60}})
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var received model.WeatherData
+	require.NoError(t, ws.ReadJSON(&received))
+	assert.Equal(t, "hamburg-1", received.Station)
+	assert.Equal(t, 12.0, received.Values["temperature"])
+}
+
+func TestWebSocketHub_SlowClients(t *testing.T) {
+	// fills the socket buffers of a client that never reads, so its queue overflows
+	bigReading := func() *model.WeatherData {
+		return &model.WeatherData{
+			Station: "slow",
+			Date:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			Values:  map[string]any{"note": strings.Repeat("x", 64<<10)},
+		}
+	}
+	slowStats := func(hub handler.WebSocketHub) (handler.ClientStats, bool) {
+		for _, c := range hub.Stats().Connections {
+			if len(c.Subscriptions[0].Stations) == 0 {
+				return c, true
+			}
+		}
+		return handler.ClientStats{}, false
+	}
+	start := func(t *testing.T, overflow string) (handler.WebSocketHub, string) {
+		hub := handler.NewWebSocketHub(zap.NewNop(), handler.WithClientQueueSize(4), handler.WithOverflowPolicy(overflow))
+		server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+		t.Cleanup(server.Close)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go hub.Run(ctx)
+		return hub, "ws" + strings.TrimPrefix(server.URL, "http")
+	}
+	dial := func(t *testing.T, url string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	saturate := func(t *testing.T, hub handler.WebSocketHub, overflowed func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !overflowed() {
+			require.True(t, time.Now().Before(deadline), "the slow client never overflowed")
+			for range 20 {
+				hub.Broadcast(bigReading())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for _, overflow := range []string{handler.OverflowDropNewest, handler.OverflowDropOldest} {
+		t.Run(overflow, func(t *testing.T) {
+			hub, url := start(t, overflow)
+			dial(t, url) // never reads
+			fast := dial(t, url+"?stations=fast")
+			require.Eventually(t, func() bool { return hub.Stats().Clients == 2 }, time.Second, 10*time.Millisecond)
+
+			saturate(t, hub, func() bool {
+				c, _ := slowStats(hub)
+				return c.Dropped > 0
+			})
+
+			// the stuck client does not hold up the others
+			for i := range 5 {
+				hub.Broadcast(&model.WeatherData{Station: "fast", Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": float64(i)}})
+				fast.SetReadDeadline(time.Now().Add(2 * time.Second))
+				var received model.WeatherData
+				require.NoError(t, fast.ReadJSON(&received))
+				assert.Equal(t, float64(i), received.Values["temperature"])
+			}
+
+			stats := hub.Stats()
+			assert.Equal(t, 2, stats.Clients)
+			assert.Equal(t, overflow, stats.Overflow)
+			slow, ok := slowStats(hub)
+			require.True(t, ok)
+			assert.Positive(t, slow.Dropped)
+			assert.LessOrEqual(t, slow.Queued, 4)
+			for _, c := range stats.Connections {
+				if len(c.Subscriptions[0].Stations) > 0 {
+					assert.Zero(t, c.Dropped)
+					assert.EqualValues(t, 5, c.Sent)
+				}
+			}
+		})
+	}
+
+	t.Run(handler.OverflowDisconnect, func(t *testing.T) {
+		hub, url := start(t, handler.OverflowDisconnect)
+		slow := dial(t, url)
+		require.Eventually(t, func() bool { return hub.Stats().Clients == 1 }, time.Second, 10*time.Millisecond)
+
+		saturate(t, hub, func() bool { return hub.Stats().Disconnected > 0 })
+		assert.Equal(t, 0, hub.Stats().Clients)
+
+		// whatever made it into the socket can still be read, then the connection ends
+		slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := slow.ReadMessage(); err != nil {
+				var netErr interface{ Timeout() bool }
+				if errors.As(err, &netErr) {
+					assert.False(t, netErr.Timeout(), "connection was not closed")
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestWebSocketHub_SubscriptionProtocol(t *testing.T) {
+	hub := handler.NewWebSocketHub(zap.NewNop())
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	type frame map[string]any
+	read := func() frame {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var f frame
+		require.NoError(t, ws.ReadJSON(&f))
+		return f
+	}
+	send := func(msg string) frame {
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(msg)))
+		return read()
+	}
+	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	reading := func(station string, temperature float64) *model.WeatherData {
+		return &model.WeatherData{Station: station, Date: date, Values: map[string]any{"temperature": temperature, "humidity": 50.0}}
+	}
+
+	t.Run("subscribe replaces the default subscription", func(t *testing.T) {
+		ack := send(`{"type":"subscribe","fields":["temperature","unknown"],"where":["temperature > 30"]}`)
+		assert.Equal(t, frame{"event": "ack", "type": "subscribe", "id": "default"}, ack)
+
+		hub.Broadcast(reading("berlin", 25))
+		hub.Broadcast(reading("berlin", 31))
+
+		f := read()
+		assert.Equal(t, 31.0, f["temperature"])
+		assert.Equal(t, "berlin", f["station"])
+		assert.NotContains(t, f, "humidity", "readings are projected")
+	})
+
+	t.Run("matching subscriptions are merged", func(t *testing.T) {
+		ack := send(`{"type":"subscribe","id":"oslo","stations":["oslo"],"fields":["humidity"],"where":["humidity >= 50","station = oslo"]}`)
+		assert.Equal(t, "ack", ack["event"])
+
+		hub.Broadcast(reading("oslo", 20))
+		f := read()
+		assert.Equal(t, "oslo", f["station"])
+		assert.Equal(t, 50.0, f["humidity"])
+		assert.NotContains(t, f, "temperature")
+
+		hub.Broadcast(reading("oslo", 35))
+		f = read()
+		assert.Equal(t, 35.0, f["temperature"])
+		assert.Equal(t, 50.0, f["humidity"], "both subscriptions match, their fields are merged")
+
+		stats := hub.Stats()
+		require.Len(t, stats.Connections, 1)
+		subs := stats.Connections[0].Subscriptions
+		require.Len(t, subs, 2)
+		assert.Equal(t, handler.SubscriptionStats{ID: "default", Fields: []string{"temperature"}, Where: []string{"temperature > 30"}}, subs[0])
+		assert.Equal(t, []string{"oslo"}, subs[1].Stations)
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		assert.Equal(t, frame{"event": "ack", "type": "unsubscribe", "id": "oslo"}, send(`{"type":"unsubscribe","id":"oslo"}`))
+
+		hub.Broadcast(reading("oslo", 20))
+		hub.Broadcast(reading("oslo", 40))
+		assert.Equal(t, 40.0, read()["temperature"])
+	})
+
+	t.Run("invalid messages are answered with an error frame", func(t *testing.T) {
+		for msg, want := range map[string]string{
+			`not json`:                                           "invalid JSON",
+			`{"type":"publish"}`:                                 "unknown message type",
+			`{"type":"unsubscribe","id":"missing"}`:              "unknown subscription",
+			`{"type":"subscribe","where":["pressure > 1"]}`:      "unknown field",
+			`{"type":"subscribe","where":["temperature > hot"]}`: "invalid value",
+			`{"type":"subscribe","where":["temperature"]}`:       "invalid predicate",
+		} {
+			f := send(msg)
+			assert.Equal(t, "error", f["event"], msg)
+			assert.Contains(t, f["error"], want, msg)
+		}
+
+		// a rejected subscribe keeps the previous subscription
+		hub.Broadcast(reading("berlin", 20))
+		hub.Broadcast(reading("berlin", 33))
+		assert.Equal(t, 33.0, read()["temperature"])
+	})
+
+	t.Run("deletes ignore predicates and projections", func(t *testing.T) {
+		hub.BroadcastDeleted(reading("berlin", 0))
+		assert.Equal(t, frame{"event": "deleted", "seq": seq(hub, 9), "station": "berlin", "date": "2023-01-01T00:00:00Z"}, read())
+	})
+}
+
+func TestWebSocketHub_SubscriptionFromURL(t *testing.T) {
+	hub := handler.NewWebSocketHub(zap.NewNop())
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?where=pressure%3E1", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL+"?stations=berlin&fields=humidity&where=temperature%3C0", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	require.Eventually(t, func() bool { return hub.Stats().Clients == 1 }, time.Second, 10*time.Millisecond)
+
+	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	hub.Broadcast(&model.WeatherData{Station: "berlin", Date: date, Values: map[string]any{"temperature": 5.0, "humidity": 40.0}})
+	hub.Broadcast(&model.WeatherData{Station: "oslo", Date: date, Values: map[string]any{"temperature": -5.0, "humidity": 45.0}})
+	hub.Broadcast(&model.WeatherData{Station: "berlin", Date: date, Values: map[string]any{"temperature": -3.0, "humidity": 60.0}})
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var received map[string]any
+	require.NoError(t, ws.ReadJSON(&received))
+	assert.Equal(t, map[string]any{"seq": seq(hub, 3), "station": "berlin", "date": "2023-01-01T00:00:00Z", "humidity": 60.0}, received)
+}
+
+// seq is the id of the n-th broadcast of hub, as sent in "seq" and as the SSE event id
+func seq(hub handler.WebSocketHub, n int64) string {
+	return hub.Stats().Epoch + "-" + strconv.FormatInt(n, 10)
+}
+
+func TestWebSocketHub_ResumeAndSnapshot(t *testing.T) {
+	hub := handler.NewWebSocketHub(zap.NewNop(), handler.WithReplayBuffer(4))
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	type frame map[string]any
+	connect := func(query string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL+query, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	read := func(ws *websocket.Conn) frame {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var f frame
+		require.NoError(t, ws.ReadJSON(&f))
+		return f
+	}
+	// a control message is acknowledged after everything queued before it, so the ack marks the end of the backlog
+	readUntilAck := func(ws *websocket.Conn) []frame {
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"unsubscribe","id":"marker"}`)))
+		var frames []frame
+		for {
+			f := read(ws)
+			if f["event"] == "error" {
+				return frames
+			}
+			frames = append(frames, f)
+		}
+	}
+	// broadcasts are numbered by the hub loop, a watcher makes sure they were processed
+	watcher := connect("?stations=none")
+	broadcast := func(station string, day int, temperature float64) {
+		hub.Broadcast(&model.WeatherData{Station: station, Date: time.Date(2023, 1, day, 0, 0, 0, 0, time.UTC), Values: map[string]any{"temperature": temperature, "humidity": 50.0}})
+	}
+
+	broadcast("berlin", 2, 10)
+	broadcast("berlin", 1, 5) // backfill, berlin-2 stays the latest reading
+	broadcast("oslo", 1, -3)
+	hub.BroadcastDeleted(&model.WeatherData{Station: "oslo", Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)})
+	broadcast("rome", 1, 20)
+	readUntilAck(watcher)
+
+	t.Run("snapshot on connect", func(t *testing.T) {
+		frames := readUntilAck(connect("?fields=temperature"))
+		require.Len(t, frames, 1)
+		assert.Equal(t, "snapshot", frames[0]["event"])
+		assert.Equal(t, seq(hub, 5), frames[0]["seq"])
+		assert.Equal(t, []any{
+			map[string]any{"station": "berlin", "date": "2023-01-02T00:00:00Z", "temperature": 10.0},
+			map[string]any{"station": "rome", "date": "2023-01-01T00:00:00Z", "temperature": 20.0},
+		}, frames[0]["readings"])
+	})
+
+	t.Run("snapshot respects the subscription", func(t *testing.T) {
+		frames := readUntilAck(connect("?stations=oslo"))
+		assert.Empty(t, frames, "oslo has no reading left")
+
+		frames = readUntilAck(connect("?where=temperature%3E15"))
+		require.Len(t, frames, 1)
+		assert.Len(t, frames[0]["readings"], 1)
+	})
+
+	t.Run("resume replays missed broadcasts", func(t *testing.T) {
+		frames := readUntilAck(connect("?since=" + seq(hub, 2) + "&stations=oslo,rome"))
+		require.Len(t, frames, 3)
+		assert.Equal(t, seq(hub, 3), frames[0]["seq"])
+		assert.Equal(t, "deleted", frames[1]["event"])
+		assert.Equal(t, seq(hub, 4), frames[1]["seq"])
+		assert.Equal(t, "rome", frames[2]["station"])
+		assert.Equal(t, seq(hub, 5), frames[2]["seq"])
+
+		assert.Empty(t, readUntilAck(connect("?since="+seq(hub, 5))), "nothing was missed")
+	})
+
+	t.Run("live broadcasts follow the backlog", func(t *testing.T) {
+		ws := connect("?since=" + seq(hub, 4) + "&stations=rome")
+		broadcast("rome", 2, 22)
+		assert.Equal(t, seq(hub, 5), read(ws)["seq"])
+		f := read(ws)
+		assert.Equal(t, seq(hub, 6), f["seq"])
+		assert.Equal(t, 22.0, f["temperature"])
+	})
+
+	t.Run("reset when the gap is too old", func(t *testing.T) {
+		// the buffer holds the last 4 of 6 broadcasts
+		for _, query := range []string{"?since=" + seq(hub, 1), "?since=" + seq(hub, 99)} {
+			frames := readUntilAck(connect(query + "&stations=rome"))
+			require.Len(t, frames, 2, query)
+			assert.Equal(t, frame{"event": "reset", "seq": seq(hub, 6)}, frames[0])
+			assert.Equal(t, "snapshot", frames[1]["event"])
+			assert.Equal(t, []any{map[string]any{"station": "rome", "date": "2023-01-02T00:00:00Z", "temperature": 22.0, "humidity": 50.0}}, frames[1]["readings"])
+		}
+		assert.Len(t, readUntilAck(connect("?since="+seq(hub, 2)+"&stations=rome")), 2)
+	})
+
+	t.Run("reset when the seq is from another instance", func(t *testing.T) {
+		// the seq is in the buffer, but the events of another hub are unrelated to it
+		other := handler.NewWebSocketHub(zap.NewNop())
+		require.NotEqual(t, hub.Stats().Epoch, other.Stats().Epoch)
+		for _, since := range []string{seq(other, 4), "4"} {
+			frames := readUntilAck(connect("?since=" + since + "&stations=rome"))
+			require.Len(t, frames, 2, since)
+			assert.Equal(t, frame{"event": "reset", "seq": seq(hub, 6)}, frames[0])
+			assert.Equal(t, "snapshot", frames[1]["event"])
+		}
+	})
+
+	t.Run("invalid since", func(t *testing.T) {
+		for _, since := range []string{"-1", hub.Stats().Epoch + "-x", "abc"} {
+			_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?since="+since, nil)
+			require.Error(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, since)
+		}
+	})
+}
+
+func TestWebSocketHub_DroppedBroadcastsForceReset(t *testing.T) {
+	hub := handler.NewWebSocketHub(zap.NewNop())
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleConnection))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the hub loop is not running yet, so the broadcast channel fills up
+	var n int64
+	for hub.Stats().Dropped < 2 {
+		n++
+		hub.Broadcast(&model.WeatherData{Station: "berlin", Date: date, Values: map[string]any{"temperature": float64(n)}})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	type frame map[string]any
+	connect := func(query string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL+query, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	read := func(ws *websocket.Conn) frame {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var f frame
+		require.NoError(t, ws.ReadJSON(&f))
+		return f
+	}
+	readUntilAck := func(ws *websocket.Conn) []frame {
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"unsubscribe","id":"marker"}`)))
+		var frames []frame
+		for f := read(ws); f["event"] != "error"; f = read(ws) {
+			frames = append(frames, f)
+		}
+		return frames
+	}
+
+	// the oslo reading follows every buffered broadcast, once it is seen they were all processed
+	watcher := connect("?stations=oslo")
+	hub.Broadcast(&model.WeatherData{Station: "oslo", Date: date, Values: map[string]any{"temperature": 1.0}})
+	read(watcher)
+
+	frames := readUntilAck(connect("?since=" + seq(hub, n-2)))
+	require.Len(t, frames, 2, "the broadcasts after it were dropped")
+	assert.Equal(t, frame{"event": "reset", "seq": seq(hub, n+1)}, frames[0])
+	assert.Equal(t, "snapshot", frames[1]["event"])
+
+	frames = readUntilAck(connect("?since=" + seq(hub, n)))
+	require.Len(t, frames, 1)
+	assert.Equal(t, seq(hub, n+1), frames[0]["seq"])
+	assert.Equal(t, "oslo", frames[0]["station"])
+}